@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+const (
+	accountEmailTopic = "email_account"
+
+	purposeEmailVerification = "email_verification"
+	purposePasswordReset     = "password_reset"
+
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = 30 * time.Minute
+)
+
+// accountClaims are carried by email verification and password reset tokens. The audience is set to the
+// purpose so that these tokens are never accepted as access tokens.
+type accountClaims struct {
+	jwt.StandardClaims
+}
+
+type accountTokenRequest struct {
+	Token    string `json:"token"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RequestEmailVerificationHandler sends a new verification link to the email address of the logged in user.
+func (uc *UserController) RequestEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	user, err := uc.user.GetUserByID(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if user.EmailVerified {
+		errRes := ErrorResponse{Error: "Email address is already verified"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err = uc.sendEmailVerification(user); err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to send verification email"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmEmailVerificationHandler marks the email address of the token's user as verified.
+func (uc *UserController) ConfirmEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, err := uc.consumeAccountToken(req.Token, purposeEmailVerification)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid or expired token"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err = uc.user.MarkEmailVerified(userID, auditActor(r, userID)); err != nil {
+		errRes := ErrorResponse{Error: "Failed to verify email address"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, map[string]bool{"email_verified": true}, http.StatusOK)
+}
+
+// RequestPasswordResetHandler emails a reset link to every account registered with the email address.
+// It always answers 202 so that it cannot be used to find out which addresses have accounts.
+func (uc *UserController) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validEmail(req.Email) {
+		errRes := ErrorResponse{Error: "Invalid email address"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	users, err := uc.user.GetUsersByEmail(req.Email)
+	if err != nil {
+		logger.LogError(err)
+	}
+	for i := range users {
+		if err = uc.sendPasswordReset(&users[i]); err != nil {
+			logger.LogError(err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmPasswordResetHandler sets a new password and logs the user out of every session.
+func (uc *UserController) ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Password == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	// The policy is checked first so that a rejected password does not use up the token.
+	if err := uc.user.CheckPasswordPolicy(req.Password); err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, err := uc.consumeAccountToken(req.Token, purposePasswordReset)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid or expired token"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err = uc.user.UpdatePassword(userID, req.Password, auditActor(r, userID)); err != nil {
+		errRes := ErrorResponse{Error: "Failed to reset password"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if err = uc.token.RevokeUserTokens(userID); err != nil {
+		logger.LogError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (uc *UserController) sendEmailVerification(user *model.User) error {
+	token, err := uc.newAccountToken(user.ID, purposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return uc.queueAccountEmail(&model.AccountEmail{
+		UserID:  user.ID,
+		Subject: "Verify your Tigerhall Kittens email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your email address by submitting this token to %s/user/email/verify/confirm within %v:\n\n%s\n",
+			user.Username, appBaseURL(), emailVerificationTTL, token),
+	})
+}
+
+func (uc *UserController) sendPasswordReset(user *model.User) error {
+	token, err := uc.newAccountToken(user.ID, purposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return uc.queueAccountEmail(&model.AccountEmail{
+		UserID:  user.ID,
+		Subject: "Reset your Tigerhall Kittens password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomebody asked to reset your password. Submit this token with your new password to %s/user/password/reset/confirm within %v:\n\n%s\n\nIf it wasn't you, you can ignore this email.\n",
+			user.Username, appBaseURL(), passwordResetTTL, token),
+	})
+}
+
+// newAccountToken signs a single use token for the purpose and records it so that it can only be consumed once.
+func (uc *UserController) newAccountToken(userID int64, purpose string, ttl time.Duration) (string, error) {
+	jti, err := newOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(ttl)
+	token, err := uc.keys.Sign(&accountClaims{jwt.StandardClaims{
+		Id:        jti,
+		Subject:   strconv.FormatInt(userID, 10),
+		Audience:  purpose,
+		ExpiresAt: expiresAt.Unix(),
+	}})
+	if err != nil {
+		return "", err
+	}
+	if err = uc.token.CreateAccountToken(jti, userID, purpose, expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (uc *UserController) consumeAccountToken(token string, purpose string) (int64, error) {
+	claims, err := uc.parseAccountToken(token, purpose)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Id == "" {
+		return 0, errors.New("token without id")
+	}
+	userID, err := uc.token.ConsumeAccountToken(claims.Id, purpose)
+	if errors.Is(err, database.ErrNotFound) {
+		return 0, errors.New("token already used or expired")
+	}
+	return userID, err
+}
+
+// parseAccountToken verifies the token and checks that it was issued for the purpose.
+func (uc *UserController) parseAccountToken(token string, purpose string) (*accountClaims, error) {
+	claims := &accountClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, uc.keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Audience != purpose {
+		return nil, errors.New("token issued for another purpose")
+	}
+	return claims, nil
+}
+
+func (uc *UserController) queueAccountEmail(email *model.AccountEmail) error {
+	message, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	_, _, err = uc.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: accountEmailTopic,
+		Value: sarama.ByteEncoder(message),
+	})
+	return err
+}
+
+func appBaseURL() string {
+	if baseURL := os.Getenv("APP_BASE_URL"); baseURL != "" {
+		return baseURL
+	}
+	return "http://localhost:" + os.Getenv("PORT")
+}
+
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
+	"time"
+)
+
+func (f *fakeTokenDB) CreateAccountToken(jti string, userId int64, purpose string, expiresAt time.Time) error {
+	if f.accountTokens == nil {
+		f.accountTokens = make(map[string]int64)
+	}
+	f.accountTokens[purpose+"/"+jti] = userId
+	return nil
+}
+
+func (f *fakeTokenDB) ConsumeAccountToken(jti string, purpose string) (int64, error) {
+	userId, ok := f.accountTokens[purpose+"/"+jti]
+	if !ok {
+		return 0, database.ErrNotFound
+	}
+	delete(f.accountTokens, purpose+"/"+jti)
+	return userId, nil
+}
+
+func TestAccountToken(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	uc := NewUserController(nil, &fakeTokenDB{}, nil, nil, keys, nil)
+
+	token, err := uc.newAccountToken(7, purposePasswordReset, time.Minute)
+	assert.NoError(t, err, "Unexpected error while creating token")
+
+	_, err = uc.consumeAccountToken(token, purposeEmailVerification)
+	assert.Error(t, err, "Token should only be accepted for its purpose")
+
+	userID, err := uc.consumeAccountToken(token, purposePasswordReset)
+	assert.NoError(t, err, "Unexpected error while consuming token")
+	assert.Equal(t, int64(7), userID, "User ID mismatch")
+
+	_, err = uc.consumeAccountToken(token, purposePasswordReset)
+	assert.Error(t, err, "Token should only be usable once")
+
+	expired, err := uc.newAccountToken(7, purposePasswordReset, -time.Minute)
+	assert.NoError(t, err, "Unexpected error while creating token")
+	_, err = uc.consumeAccountToken(expired, purposePasswordReset)
+	assert.Error(t, err, "Expired token should be rejected")
+}
+
+func TestValidEmail(t *testing.T) {
+	assert.True(t, validEmail("ranger@tigerhall.org"), "Plain address should be valid")
+	assert.False(t, validEmail("not an email"), "Free text should be rejected")
+	assert.False(t, validEmail("Ranger <ranger@tigerhall.org>"), "Display names should be rejected")
+	assert.False(t, validEmail(""), "Empty address should be rejected")
+}
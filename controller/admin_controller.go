@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+type AdminController struct {
+	user         database.IUser
+	loginAttempt database.ILoginAttempt
+	twoFactor    database.ITwoFactor
+	audit        database.IAudit
+}
+
+func NewAdminController(repo database.IUser, loginAttempt database.ILoginAttempt, twoFactor database.ITwoFactor, audit database.IAudit) *AdminController {
+	return &AdminController{
+		user:         repo,
+		loginAttempt: loginAttempt,
+		twoFactor:    twoFactor,
+		audit:        audit,
+	}
+}
+
+// AdminUserHandler serves /admin/user and /admin/user/{id}/... and must only be reachable by principals allowed to manage users.
+func (ac *AdminController) AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r, "/admin/user")
+	if len(segments) == 0 {
+		if r.Method != http.MethodGet {
+			errRes := ErrorResponse{Error: "Method not allowed"}
+			WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+			return
+		}
+		ac.listUsers(w, r)
+		return
+	}
+	userID, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User id should be of bigint value"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		user, err := ac.user.GetAdminUser(userID)
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "User not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve user"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, user, http.StatusOK)
+	case len(segments) == 2 && segments[1] == "role" && r.Method == http.MethodPut:
+		ac.assignRole(w, r, userID)
+	case len(segments) == 2 && segments[1] == "actions" && r.Method == http.MethodGet:
+		actions, err := ac.user.ListUserAdminActions(userID)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve admin actions"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, actions, http.StatusOK)
+	case len(segments) == 2 && r.Method == http.MethodPost &&
+		(segments[1] == "disable" || segments[1] == "enable" || segments[1] == "logout"):
+		ac.moderateUser(w, r, userID, segments[1])
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxReasonLength = 255
+)
+
+// listUsers pages through the users matching the q, role and disabled query parameters.
+// The number of matching users is sent in the X-Total-Count header.
+func (ac *AdminController) listUsers(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	filter := model.UserFilter{
+		Query:  queryParams.Get("q"),
+		Role:   model.Role(queryParams.Get("role")),
+		Limit:  defaultPageSize,
+		Offset: 0,
+	}
+	if filter.Role != "" && !filter.Role.Valid() {
+		errRes := ErrorResponse{Error: "role should be one of reporter, ranger, moderator or admin"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if disabled := queryParams.Get("disabled"); disabled != "" {
+		value, err := strconv.ParseBool(disabled)
+		if err != nil {
+			errRes := ErrorResponse{Error: "disabled query parameter should be a boolean"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		filter.Disabled = &value
+	}
+	var err error
+	if filter.Limit, filter.Offset, err = pageParams(r); err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	users, total, err := ac.user.ListUsers(filter)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve users"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	WriteJSONResponse(w, users, http.StatusOK)
+}
+
+// moderateUser disables, enables or logs out the user. The reason is mandatory and stored with the action.
+func (ac *AdminController) moderateUser(w http.ResponseWriter, r *http.Request, userID int64, action string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if err != nil || req.Reason == "" || len(req.Reason) > maxReasonLength {
+		errRes := ErrorResponse{Error: fmt.Sprintf("reason is mandatory and at most %d characters", maxReasonLength)}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	if principal.UserID == userID && action != "enable" {
+		errRes := ErrorResponse{Error: "You cannot " + action + " your own account"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	var user *model.AdminUser
+	switch action {
+	case "disable":
+		user, err = ac.user.SetUserDisabled(userID, true, auditActor(r, principal.UserID), req.Reason)
+	case "enable":
+		user, err = ac.user.SetUserDisabled(userID, false, auditActor(r, principal.UserID), req.Reason)
+	case "logout":
+		err = ac.user.ForceLogout(userID, auditActor(r, principal.UserID), req.Reason)
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to " + action + " user"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	logger.LogAudit(principal.Username, "user."+action, "user", userID, "reason", req.Reason)
+	if user == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	WriteJSONResponse(w, user, http.StatusOK)
+}
+
+func (ac *AdminController) assignRole(w http.ResponseWriter, r *http.Request, userID int64) {
+	var roleReq struct {
+		Role model.Role `json:"role"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&roleReq)
+	if err != nil || !roleReq.Role.Valid() {
+		errRes := ErrorResponse{Error: "role should be one of reporter, ranger, moderator or admin"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	user, err := ac.user.UpdateUserRole(userID, roleReq.Role, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to assign role"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	logger.LogInfo("Admin", principal.UserID, "assigned role", user.Role, "to user", user.ID)
+	logger.LogAudit(principal.Username, "user.role", "user", user.ID, "role", user.Role)
+	response := map[string]interface{}{
+		"userId":   user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	}
+	WriteJSONResponse(w, response, http.StatusOK)
+}
+
+// AdminLockoutHandler lists active login lockouts and clears the lockout given by the kind and key query parameters.
+func (ac *AdminController) AdminLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		lockouts, err := ac.loginAttempt.ListLockouts()
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve lockouts"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, lockouts, http.StatusOK)
+	case http.MethodDelete:
+		queryParams := r.URL.Query()
+		key := model.LoginKey{Kind: queryParams.Get("kind"), Key: queryParams.Get("key")}
+		if (key.Kind != database.LoginKeyUsername && key.Kind != database.LoginKeyIP) || key.Key == "" {
+			errRes := ErrorResponse{Error: "kind (username or ip) and key are mandatory query parameter(s)"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		if key.Kind == database.LoginKeyUsername {
+			key.Key = strings.ToLower(key.Key)
+		}
+		err := ac.loginAttempt.ClearLoginFailures(key)
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "Lockout not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to clear lockout"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		principal, _ := r.Context().Value("principal").(model.Principal)
+		logger.LogAudit(principal.Username, "login.lockout.clear", key.Kind, key.Key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminTwoFactorPolicyHandler lists the roles that must use two-factor authentication on GET /admin/2fa/roles
+// and adds or removes a role on PUT /admin/2fa/roles/{role}. Users of those roles have to enrol at their next login.
+func (ac *AdminController) AdminTwoFactorPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r, "/admin/2fa/roles")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		roles, err := ac.twoFactor.TwoFactorRequiredRoles()
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve two-factor policy"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, map[string][]model.Role{"required_roles": roles}, http.StatusOK)
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		role := model.Role(segments[0])
+		if !role.Valid() {
+			errRes := ErrorResponse{Error: "role should be one of reporter, ranger, moderator or admin"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		var policyReq struct {
+			Required *bool `json:"required"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&policyReq); err != nil || policyReq.Required == nil {
+			errRes := ErrorResponse{Error: "required is a mandatory boolean"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		principal, _ := r.Context().Value("principal").(model.Principal)
+		if err := ac.twoFactor.SetTwoFactorRequired(role, *policyReq.Required, principal.UserID); err != nil {
+			errRes := ErrorResponse{Error: "Failed to update two-factor policy"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		logger.LogAudit(principal.Username, "two_factor.policy", "role", role, "required", *policyReq.Required)
+		WriteJSONResponse(w, map[string]interface{}{"role": role, "required": *policyReq.Required}, http.StatusOK)
+	case len(segments) > 1:
+		errRes := ErrorResponse{Error: "Not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminAuditHandler serves GET /admin/audit, the changes of users, animals, sightings and images newest first.
+// It can be filtered by actor_id, entity and entity_id, and by a time range from (inclusive) to (exclusive) in RFC 3339.
+func (ac *AdminController) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := auditFilter(r)
+	if err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	entries, err := ac.audit.ListAuditLog(filter)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve audit log"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, entries, http.StatusOK)
+}
+
+func auditFilter(r *http.Request) (model.AuditFilter, error) {
+	var filter model.AuditFilter
+	var err error
+	queryParams := r.URL.Query()
+	if actorID := queryParams.Get("actor_id"); actorID != "" {
+		if filter.ActorID, err = strconv.ParseInt(actorID, 10, 64); err != nil {
+			return filter, errors.New("actor_id should be of bigint value")
+		}
+	}
+	filter.Entity = queryParams.Get("entity")
+	if filter.Entity != "" && !database.ValidAuditEntity(filter.Entity) {
+		return filter, errors.New("entity should be one of user, animal, animal_identifier, litter, sighting, image or taxon")
+	}
+	if entityID := queryParams.Get("entity_id"); entityID != "" {
+		if filter.Entity == "" {
+			return filter, errors.New("entity_id requires entity")
+		}
+		if filter.EntityID, err = strconv.ParseInt(entityID, 10, 64); err != nil {
+			return filter, errors.New("entity_id should be of bigint value")
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := queryParams.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s should be an RFC 3339 timestamp", name)
+		}
+		*dst = &t
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, errors.New("from should be before to")
+	}
+	filter.Limit, filter.Offset, err = pageParams(r)
+	return filter, err
+}
+
+// pageParams reads the optional limit and offset query parameters of admin listings.
+func pageParams(r *http.Request) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+	queryParams := r.URL.Query()
+	if value := queryParams.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("limit should be between 1 and %d", maxPageSize)
+		}
+		limit = n
+	}
+	if value := queryParams.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset should be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
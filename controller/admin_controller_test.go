@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+type fakeAdminUserDB struct {
+	database.IUser
+	disabled   map[int64]bool
+	logouts    []int64
+	lastFilter model.UserFilter
+}
+
+func (f *fakeAdminUserDB) SetUserDisabled(userId int64, disabled bool, actor model.Actor, reason string) (*model.AdminUser, error) {
+	if userId > 10 {
+		return nil, database.ErrNotFound
+	}
+	f.disabled[userId] = disabled
+	return &model.AdminUser{Profile: model.Profile{ID: userId}, Disabled: disabled}, nil
+}
+
+func (f *fakeAdminUserDB) ForceLogout(userId int64, actor model.Actor, reason string) error {
+	f.logouts = append(f.logouts, userId)
+	return nil
+}
+
+func (f *fakeAdminUserDB) ListUsers(filter model.UserFilter) ([]model.AdminUser, int64, error) {
+	f.lastFilter = filter
+	return []model.AdminUser{}, 42, nil
+}
+
+func adminRequest(ac *AdminController, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	admin := model.Principal{UserID: 1, Username: "admin", Role: model.RoleAdmin}
+	req = req.WithContext(context.WithValue(req.Context(), "principal", admin))
+	rr := httptest.NewRecorder()
+	ac.AdminUserHandler(rr, req)
+	return rr
+}
+
+func TestAdminUserModeration(t *testing.T) {
+	userDB := &fakeAdminUserDB{disabled: make(map[int64]bool)}
+	ac := NewAdminController(userDB, &fakeLoginAttemptDB{}, newFakeTwoFactorDB(), nil)
+
+	rr := adminRequest(ac, http.MethodPost, "/admin/user/2/disable", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Disabling without a reason should be rejected")
+
+	rr = adminRequest(ac, http.MethodPost, "/admin/user/1/disable", `{"reason":"testing"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Admins should not disable themselves")
+
+	rr = adminRequest(ac, http.MethodPost, "/admin/user/2/disable", `{"reason":"spam reports"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, "Disabling should succeed")
+	assert.True(t, userDB.disabled[2], "User should be disabled")
+
+	rr = adminRequest(ac, http.MethodPost, "/admin/user/2/enable", `{"reason":"appeal accepted"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, "Enabling should succeed")
+	assert.False(t, userDB.disabled[2], "User should be enabled")
+
+	rr = adminRequest(ac, http.MethodPost, "/admin/user/2/logout", `{"reason":"lost phone"}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Force logout should succeed")
+	assert.Equal(t, []int64{2}, userDB.logouts, "User should be logged out")
+
+	rr = adminRequest(ac, http.MethodPost, "/admin/user/99/disable", `{"reason":"spam reports"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Unknown users should not be found")
+}
+
+func TestAdminListUsers(t *testing.T) {
+	userDB := &fakeAdminUserDB{disabled: make(map[int64]bool)}
+	ac := NewAdminController(userDB, &fakeLoginAttemptDB{}, newFakeTwoFactorDB(), nil)
+
+	rr := adminRequest(ac, http.MethodGet, "/admin/user?q=tig&role=ranger&disabled=true&limit=5&offset=10", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Listing should succeed")
+	assert.Equal(t, "42", rr.Header().Get("X-Total-Count"), "Total count header mismatch")
+	assert.Equal(t, "tig", userDB.lastFilter.Query, "Query mismatch")
+	assert.Equal(t, model.RoleRanger, userDB.lastFilter.Role, "Role mismatch")
+	assert.True(t, *userDB.lastFilter.Disabled, "Disabled filter mismatch")
+	assert.Equal(t, 5, userDB.lastFilter.Limit, "Limit mismatch")
+	assert.Equal(t, 10, userDB.lastFilter.Offset, "Offset mismatch")
+
+	rr = adminRequest(ac, http.MethodGet, "/admin/user?limit=1000", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Limits above the maximum should be rejected")
+
+	rr = adminRequest(ac, http.MethodGet, "/admin/user?role=superuser", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Unknown roles should be rejected")
+}
+
+type fakeAuditDB struct {
+	lastFilter model.AuditFilter
+}
+
+func (f *fakeAuditDB) ListAuditLog(filter model.AuditFilter) ([]model.AuditEntry, error) {
+	f.lastFilter = filter
+	return []model.AuditEntry{}, nil
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	auditDB := &fakeAuditDB{}
+	ac := NewAdminController(&fakeAdminUserDB{}, &fakeLoginAttemptDB{}, newFakeTwoFactorDB(), auditDB)
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", http.StatusOK},
+		{"?actor_id=3&entity=animal&entity_id=7&from=2023-01-01T00:00:00Z&to=2023-02-01T00:00:00Z", http.StatusOK},
+		{"?entity=notification", http.StatusBadRequest},
+		{"?entity_id=7", http.StatusBadRequest},
+		{"?actor_id=abc", http.StatusBadRequest},
+		{"?from=yesterday", http.StatusBadRequest},
+		{"?from=2023-02-01T00:00:00Z&to=2023-01-01T00:00:00Z", http.StatusBadRequest},
+		{"?limit=0", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit"+test.query, nil)
+		rr := httptest.NewRecorder()
+		ac.AdminAuditHandler(rr, req)
+		assert.Equal(t, test.expected, rr.Code, "Status mismatch for %q", test.query)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?actor_id=3&entity=animal&entity_id=7&from=2023-01-01T00:00:00Z", nil)
+	ac.AdminAuditHandler(httptest.NewRecorder(), req)
+	assert.Equal(t, int64(3), auditDB.lastFilter.ActorID, "Actor mismatch")
+	assert.Equal(t, "animal", auditDB.lastFilter.Entity, "Entity mismatch")
+	assert.Equal(t, int64(7), auditDB.lastFilter.EntityID, "Entity id mismatch")
+	assert.Equal(t, "2023-01-01T00:00:00Z", auditDB.lastFilter.From.Format(time.RFC3339), "From mismatch")
+	assert.Nil(t, auditDB.lastFilter.To, "To should be open")
+	assert.Equal(t, defaultPageSize, auditDB.lastFilter.Limit, "Default limit expected")
+}
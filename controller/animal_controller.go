@@ -2,13 +2,27 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"tigerhall-kittens/database"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/model"
+	"time"
+	"unicode/utf8"
 )
 
+// Column sizes of the animal table.
+const (
+	maxAnimalNameLength        = 30
+	maxAnimalDescriptionLength = 100
+)
+
+const maxSearchQueryLength = 100
+
 type AnimalController struct {
 	animal database.IAnimal
 }
@@ -19,47 +33,452 @@ func NewAnimalController(a database.IAnimal) *AnimalController {
 	}
 }
 
+// AnimalHandler serves GET and POST /animal, GET /animal/search, GET /animal/lookup, GET, PATCH and DELETE
+// /animal/{id}, GET /animal/{id}/family, PUT /animal/{id}/parents, POST /animal/{id}/litter, PUT
+// /animal/{id}/identifiers, POST /animal/{id}/merge, PUT /animal/{id}/status and GET /animal/{id}/status/history.
+// GETs of an animal that was merged into another are redirected to it.
 func (ac *AnimalController) AnimalHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		queryParams := r.URL.Query()
-		name := queryParams.Get("name")
-		animalType := queryParams.Get("type")
-		limit := queryParams.Get("limit")
-		offset := queryParams.Get("offset")
-		if limit == "" || offset == "" {
-			errRes := ErrorResponse{Error: "limit or offset query parameter(s) missing"}
-			WriteJSONResponse(w, errRes, http.StatusBadRequest)
-			return
+	segments := pathSegments(r, "/animal")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		ac.listAnimals(w, r)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		ac.createAnimal(w, r)
+	case len(segments) == 1 && segments[0] == "search" && r.Method == http.MethodGet:
+		ac.searchAnimals(w, r)
+	case len(segments) == 1 && segments[0] == "lookup" && r.Method == http.MethodGet:
+		ac.lookupAnimal(w, r)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		ac.getAnimal(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		ac.updateAnimal(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		ac.deleteAnimal(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "family" && r.Method == http.MethodGet:
+		ac.getFamilyTree(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "parents" && r.Method == http.MethodPut:
+		ac.setParents(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "litter" && r.Method == http.MethodPost:
+		ac.createLitter(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "identifiers" && r.Method == http.MethodPut:
+		ac.setIdentifiers(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "merge" && r.Method == http.MethodPost:
+		ac.mergeAnimals(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "status" && r.Method == http.MethodPut:
+		ac.setAnimalStatus(w, r, segments[0])
+	case len(segments) == 3 && segments[1] == "status" && segments[2] == "history" && r.Method == http.MethodGet:
+		ac.listStatusHistory(w, r, segments[0])
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminAnimalHandler serves POST /admin/animal/{id}/restore.
+func (ac *AnimalController) AdminAnimalHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r, "/admin/animal/")
+	switch {
+	case len(segments) == 2 && segments[1] == "restore" && r.Method == http.MethodPost:
+		ac.restoreAnimal(w, r, segments[0])
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+// listAnimals pages through the animals matching the query parameters, see animalFilter.
+func (ac *AnimalController) listAnimals(w http.ResponseWriter, r *http.Request) {
+	filter, err := animalFilter(r)
+	if err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	animals, err := ac.animal.ListAnimalInfo(filter)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve animal information"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animals, http.StatusOK)
+}
+
+// animalFilter reads the query parameters of animalFilterParams, the sort and order query parameters and those of
+// cursorParams.
+func animalFilter(r *http.Request) (model.AnimalFilter, error) {
+	filter, err := animalFilterParams(r)
+	if err != nil {
+		return filter, err
+	}
+	queryParams := r.URL.Query()
+	filter.Sort = queryParams.Get("sort")
+	switch filter.Sort {
+	case "":
+		filter.Sort = model.AnimalSortLastSeen
+	case model.AnimalSortLastSeen, model.AnimalSortName, model.AnimalSortAge:
+	default:
+		return filter, errors.New("sort should be one of last_seen, name or age")
+	}
+	switch queryParams.Get("order") {
+	case "":
+		filter.Descending = filter.Sort == model.AnimalSortLastSeen
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("order should be asc or desc")
+	}
+	filter.Limit, filter.Cursor, filter.WithTotal, err = cursorParams(r, filter.Sort, filter.Descending)
+	return filter, err
+}
+
+// animalFilterParams reads the name, name_prefix, type, variant, status, min_age, max_age, seen_after, seen_before
+// and bbox query parameters. Statuses are comma separated and the bounding box is given as
+// min_lon,min_lat,max_lon,max_lat.
+func animalFilterParams(r *http.Request) (model.AnimalFilter, error) {
+	queryParams := r.URL.Query()
+	filter := model.AnimalFilter{
+		Name:       queryParams.Get("name"),
+		NamePrefix: queryParams.Get("name_prefix"),
+		Type:       queryParams.Get("type"),
+		Variant:    queryParams.Get("variant"),
+	}
+	if statuses := queryParams.Get("status"); statuses != "" {
+		for _, value := range strings.Split(statuses, ",") {
+			status := model.AnimalStatus(strings.TrimSpace(value))
+			if !status.Valid() {
+				return filter, errors.New("status should be a comma separated list of active, missing, rehabilitation, relocated and deceased")
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	for name, dst := range map[string]**int{"min_age": &filter.MinAge, "max_age": &filter.MaxAge} {
+		value := queryParams.Get(name)
+		if value == "" {
+			continue
+		}
+		age, err := strconv.Atoi(value)
+		if err != nil || age < 0 {
+			return filter, fmt.Errorf("%s should be a non-negative integer", name)
+		}
+		*dst = &age
+	}
+	if filter.MinAge != nil && filter.MaxAge != nil && *filter.MinAge > *filter.MaxAge {
+		return filter, errors.New("min_age should not be greater than max_age")
+	}
+	for name, dst := range map[string]**time.Time{"seen_after": &filter.SeenAfter, "seen_before": &filter.SeenBefore} {
+		value := queryParams.Get(name)
+		if value == "" {
+			continue
 		}
-		animals, err := ac.animal.ListAnimalInfo(name, animalType, limit, offset)
+		t, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			errRes := ErrorResponse{Error: "Failed to retrieve animal information"}
-			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
-			return
+			return filter, fmt.Errorf("%s should be an RFC 3339 timestamp", name)
 		}
-		WriteJSONResponse(w, animals, http.StatusOK)
-	case http.MethodPost:
-		decoder := json.NewDecoder(r.Body)
-		var animalReq model.AnimalReqResp
-		err := decoder.Decode(&animalReq)
+		*dst = &t
+	}
+	if filter.SeenAfter != nil && filter.SeenBefore != nil && !filter.SeenAfter.Before(*filter.SeenBefore) {
+		return filter, errors.New("seen_after should be before seen_before")
+	}
+	if bbox := queryParams.Get("bbox"); bbox != "" {
+		box, err := parseBoundingBox(bbox)
 		if err != nil {
-			errRes := ErrorResponse{Error: "Invalid request payload"}
+			return filter, err
+		}
+		filter.BoundingBox = box
+	}
+	return filter, nil
+}
+
+// cursorParams reads the optional limit, cursor and total query parameters of the lists paged by cursors. The
+// cursor has to come from a page of the list in the same order.
+func cursorParams(r *http.Request, sort string, descending bool) (int, *model.Cursor, bool, error) {
+	limit := defaultPageSize
+	var cursor *model.Cursor
+	var withTotal bool
+	queryParams := r.URL.Query()
+	if value := queryParams.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, nil, false, fmt.Errorf("limit should be between 1 and %d", maxPageSize)
+		}
+		limit = n
+	}
+	if value := queryParams.Get("cursor"); value != "" {
+		var err error
+		if cursor, err = database.DecodeCursor(value, sort, descending); err != nil {
+			return 0, nil, false, errors.New("cursor is invalid or belongs to another order")
+		}
+	}
+	if value := queryParams.Get("total"); value != "" {
+		var err error
+		if withTotal, err = strconv.ParseBool(value); err != nil {
+			return 0, nil, false, errors.New("total should be a boolean")
+		}
+	}
+	return limit, cursor, withTotal, nil
+}
+
+func parseBoundingBox(value string) (*model.BoundingBox, error) {
+	invalid := errors.New("bbox should be min_lon,min_lat,max_lon,max_lat")
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, invalid
+	}
+	var coordinates [4]float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(n) {
+			return nil, invalid
+		}
+		coordinates[i] = n
+	}
+	box := &model.BoundingBox{
+		MinLongitude: coordinates[0],
+		MinLatitude:  coordinates[1],
+		MaxLongitude: coordinates[2],
+		MaxLatitude:  coordinates[3],
+	}
+	if box.MinLongitude < -180 || box.MaxLongitude > 180 || box.MinLatitude < -90 || box.MaxLatitude > 90 ||
+		box.MinLongitude > box.MaxLongitude || box.MinLatitude > box.MaxLatitude {
+		return nil, invalid
+	}
+	return box, nil
+}
+
+// searchAnimals finds the animals matching the q query parameter, narrowed by the filters of the animal list.
+// Matches are highlighted when highlight is true.
+func (ac *AnimalController) searchAnimals(w http.ResponseWriter, r *http.Request) {
+	filter, err := animalFilterParams(r)
+	if err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	queryParams := r.URL.Query()
+	search := model.AnimalSearch{AnimalFilter: filter, Query: strings.TrimSpace(queryParams.Get("q"))}
+	if search.Query == "" || utf8.RuneCountInString(search.Query) > maxSearchQueryLength {
+		errRes := ErrorResponse{Error: fmt.Sprintf("q should be 1 to %d characters", maxSearchQueryLength)}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if highlight := queryParams.Get("highlight"); highlight != "" {
+		if search.Highlight, err = strconv.ParseBool(highlight); err != nil {
+			errRes := ErrorResponse{Error: "highlight should be a boolean"}
 			WriteJSONResponse(w, errRes, http.StatusBadRequest)
 			return
 		}
-		userID, _ := r.Context().Value("user_id").(int64)
-		animalReq.Reporter.ID = userID
-		createdAnimal, err := ac.animal.CreateAnimal(&animalReq.Animal, &animalReq.Sighting)
+	}
+	search.Limit, search.Cursor, search.WithTotal, err = cursorParams(r, database.SearchSortRelevance, true)
+	if err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	results, err := ac.animal.SearchAnimals(search)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to search animals"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, results, http.StatusOK)
+}
+
+func (ac *AnimalController) createAnimal(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var animalReq model.AnimalReqResp
+	err := decoder.Decode(&animalReq)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateIdentifiers(animalReq.Identifiers); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	animalReq.Reporter.ID = userID
+	// Cubs only join a litter when it is registered, see createLitter.
+	animalReq.LitterID = nil
+	createdAnimal, err := ac.animal.CreateAnimal(&animalReq.Animal, &animalReq.Sighting, auditActor(r, userID))
+	if errors.Is(err, database.ErrUnknownTaxon) {
+		errRes := ErrorResponse{Error: "type should be a species of the taxonomy and variant one of its subspecies"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "An animal with same name, type and variant already exists"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	var takenErr *database.IdentifierTakenError
+	if errors.As(err, &takenErr) {
+		errRes := ErrorResponse{Error: takenErr.Error()}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	var lineageErr *database.LineageError
+	if errors.As(err, &lineageErr) {
+		errRes := ErrorResponse{Error: lineageErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: fmt.Sprintf("Failed to create animal : %v", err)}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, createdAnimal, http.StatusCreated)
+}
+
+func (ac *AnimalController) getAnimal(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	detail, err := ac.animal.GetAnimalDetail(animalID)
+	if errors.Is(err, database.ErrNotFound) {
+		ac.animalNotFound(w, r, animalID)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve animal information"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, detail, http.StatusOK)
+}
+
+func (ac *AnimalController) updateAnimal(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	var update model.AnimalUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateAnimalUpdate(&update); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	animal, err := ac.animal.UpdateAnimal(animalID, &update, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "An animal with same name, type and variant already exists"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrUnknownTaxon) {
+		errRes := ErrorResponse{Error: "variant should be a subspecies of the animal's type in the taxonomy"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	var lineageErr *database.LineageError
+	if errors.As(err, &lineageErr) {
+		errRes := ErrorResponse{Error: lineageErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update animal"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animal, http.StatusOK)
+}
+
+// validateAnimalUpdate trims the name and variant and returns why the update is invalid, if it is.
+func validateAnimalUpdate(update *model.AnimalUpdate) string {
+	if update.Name == nil && update.Variant == nil && update.DateOfBirth == nil && update.Description == nil {
+		return "At least one of name, variant, date_of_birth and description is required"
+	}
+	if update.Name != nil {
+		*update.Name = strings.TrimSpace(*update.Name)
+		if *update.Name == "" || utf8.RuneCountInString(*update.Name) > maxAnimalNameLength {
+			return fmt.Sprintf("name should be 1 to %d characters", maxAnimalNameLength)
+		}
+	}
+	if update.Variant != nil {
+		*update.Variant = strings.TrimSpace(*update.Variant)
+		if *update.Variant == "" || utf8.RuneCountInString(*update.Variant) > maxAnimalNameLength {
+			return fmt.Sprintf("variant should be 1 to %d characters", maxAnimalNameLength)
+		}
+	}
+	if update.DateOfBirth != nil {
+		dateOfBirth, err := time.Parse("2006-01-02", *update.DateOfBirth)
 		if err != nil {
-			logger.LogError(err)
-			errRes := ErrorResponse{Error: fmt.Sprintf("Failed to create animal : %v", err)}
-			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
-			return
+			return "date_of_birth should be in YYYY-MM-DD format"
 		}
-		WriteJSONResponse(w, createdAnimal, http.StatusCreated)
-	default:
-		errRes := ErrorResponse{Error: "Method not allowed"}
-		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		if dateOfBirth.After(time.Now()) {
+			return "date_of_birth cannot be in the future"
+		}
+	}
+	if update.Description != nil && utf8.RuneCountInString(*update.Description) > maxAnimalDescriptionLength {
+		return fmt.Sprintf("description should be at most %d characters", maxAnimalDescriptionLength)
+	}
+	return ""
+}
+
+// deleteAnimal hides the animal and its sightings until an admin restores it.
+func (ac *AnimalController) deleteAnimal(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	err := ac.animal.DeleteAnimal(animalID, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to delete animal"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ac *AnimalController) restoreAnimal(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	animal, err := ac.animal.RestoreAnimal(animalID, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Deleted animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to restore animal"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animal, http.StatusOK)
+}
+
+// parseAnimalID writes the error response when the id is not a number.
+func parseAnimalID(w http.ResponseWriter, id string) (int64, bool) {
+	animalID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Animal id should be of bigint value"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
 	}
+	return animalID, true
 }
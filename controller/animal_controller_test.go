@@ -0,0 +1,640 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+)
+
+type fakeAnimalDB struct {
+	database.IAnimal
+	animals map[int64]*model.AnimalDetail
+	deleted map[int64]*model.AnimalDetail
+	// lastFilter is the filter of the last ListAnimalInfo call.
+	lastFilter model.AnimalFilter
+	lastSearch model.AnimalSearch
+	// lastGenerations and lastLitter are what GetFamilyTree and CreateLitter were last called with.
+	lastGenerations int
+	lastLitter      *model.LitterRequest
+	statusHistory   []model.AnimalStatusChange
+	// merged maps the animals that were merged to the animal they were merged into.
+	merged map[int64]int64
+}
+
+func (f *fakeAnimalDB) SearchAnimals(search model.AnimalSearch) (*model.Page[model.AnimalSearchResult], error) {
+	f.lastSearch = search
+	return &model.Page[model.AnimalSearchResult]{Items: []model.AnimalSearchResult{}}, nil
+}
+
+func (f *fakeAnimalDB) ListAnimalInfo(filter model.AnimalFilter) (*model.Page[model.AnimalReqResp], error) {
+	f.lastFilter = filter
+	return &model.Page[model.AnimalReqResp]{Items: []model.AnimalReqResp{}}, nil
+}
+
+func (f *fakeAnimalDB) GetAnimalDetail(animalId int64) (*model.AnimalDetail, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return detail, nil
+}
+
+func (f *fakeAnimalDB) UpdateAnimal(animalId int64, update *model.AnimalUpdate, actor model.Actor) (*model.Animal, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	if update.Name != nil {
+		for id, other := range f.animals {
+			if id != animalId && other.Name == *update.Name && other.Type == detail.Type && other.Variant == detail.Variant {
+				return nil, database.ErrConflict
+			}
+		}
+		detail.Name = *update.Name
+	}
+	if update.Variant != nil {
+		if *update.Variant != "bengal tiger" {
+			return nil, database.ErrUnknownTaxon
+		}
+		detail.Variant = *update.Variant
+	}
+	if update.DateOfBirth != nil {
+		detail.DateOfBirth = *update.DateOfBirth
+	}
+	return &detail.Animal, nil
+}
+
+func (f *fakeAnimalDB) DeleteAnimal(animalId int64, actor model.Actor) error {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return database.ErrNotFound
+	}
+	delete(f.animals, animalId)
+	f.deleted[animalId] = detail
+	return nil
+}
+
+func (f *fakeAnimalDB) RestoreAnimal(animalId int64, actor model.Actor) (*model.Animal, error) {
+	detail, ok := f.deleted[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	delete(f.deleted, animalId)
+	f.animals[animalId] = detail
+	return &detail.Animal, nil
+}
+
+func (f *fakeAnimalDB) GetFamilyTree(animalId int64, generations int) (*model.FamilyTree, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	f.lastGenerations = generations
+	return &model.FamilyTree{Animal: detail.Animal}, nil
+}
+
+func (f *fakeAnimalDB) SetParents(animalId int64, parents model.AnimalParents, actor model.Actor) (*model.Animal, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	if parents.MotherID != nil && *parents.MotherID == animalId {
+		return nil, &database.LineageError{Reason: "An animal cannot be its own mother"}
+	}
+	detail.MotherID = parents.MotherID
+	detail.FatherID = parents.FatherID
+	return &detail.Animal, nil
+}
+
+func (f *fakeAnimalDB) CreateLitter(motherId int64, litter *model.LitterRequest, actor model.Actor) (*model.Litter, error) {
+	mother, ok := f.animals[motherId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	f.lastLitter = litter
+	created := &model.Litter{ID: 1, MotherID: motherId, FatherID: litter.FatherID, DateOfBirth: litter.DateOfBirth}
+	for i, cub := range litter.Cubs {
+		cub.ID = int64(10 + i)
+		cub.Type = mother.Type
+		cub.MotherID = &motherId
+		cub.LitterID = &created.ID
+		created.Cubs = append(created.Cubs, model.AnimalReqResp{AnimalID: cub.ID, Animal: cub, Sighting: litter.Sighting})
+	}
+	return created, nil
+}
+
+func (f *fakeAnimalDB) SetAnimalStatus(animalId int64, update *model.AnimalStatusUpdate, actor model.Actor) (*model.AnimalStatusChange, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	from := detail.Status
+	if from == "" {
+		from = model.AnimalStatusActive
+	}
+	if !from.CanBecome(update.Status) {
+		return nil, &database.StatusTransitionError{From: from, To: update.Status}
+	}
+	if update.SightingID != nil && *update.SightingID != 5 {
+		return nil, database.ErrUnknownSighting
+	}
+	detail.Status = update.Status
+	change := model.AnimalStatusChange{ID: 1, AnimalID: animalId, From: from, To: update.Status, Reason: update.Reason,
+		SightingID: update.SightingID, ActorID: actor.UserID}
+	f.statusHistory = append([]model.AnimalStatusChange{change}, f.statusHistory...)
+	return &change, nil
+}
+
+func (f *fakeAnimalDB) ListStatusHistory(animalId int64) ([]model.AnimalStatusChange, error) {
+	if _, ok := f.animals[animalId]; !ok {
+		return nil, database.ErrNotFound
+	}
+	return f.statusHistory, nil
+}
+
+func (f *fakeAnimalDB) MergeAnimals(animalId int64, duplicateId int64, actor model.Actor) (*model.AnimalMerge, error) {
+	animal, ok := f.animals[animalId]
+	duplicate, found := f.animals[duplicateId]
+	if !ok || !found {
+		return nil, database.ErrNotFound
+	}
+	if duplicate.MotherID != nil && *duplicate.MotherID == animalId {
+		return nil, &database.LineageError{Reason: fmt.Sprintf("Animal %d is a descendant of animal %d", duplicateId, animalId)}
+	}
+	delete(f.animals, duplicateId)
+	f.merged[duplicateId] = animalId
+	animal.TotalSightings += duplicate.TotalSightings
+	return &model.AnimalMerge{
+		Animal:         animal.Animal,
+		DuplicateID:    duplicateId,
+		MovedSightings: int(duplicate.TotalSightings),
+		Conflicts:      []model.SightingConflict{{SightingID: 4, PreviousSightingID: 3, DistanceKm: 1.2}},
+	}, nil
+}
+
+func (f *fakeAnimalDB) MergedInto(animalId int64) (int64, error) {
+	mergedIntoId, ok := f.merged[animalId]
+	if !ok {
+		return 0, database.ErrNotFound
+	}
+	return mergedIntoId, nil
+}
+
+func (f *fakeAnimalDB) SetIdentifiers(animalId int64, identifiers []model.AnimalIdentifier, actor model.Actor) (*model.Animal, error) {
+	detail, ok := f.animals[animalId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	for id, other := range f.animals {
+		for _, taken := range other.Identifiers {
+			for _, identifier := range identifiers {
+				if id != animalId && taken.Scheme == identifier.Scheme && strings.EqualFold(taken.Value, identifier.Value) {
+					return nil, &database.IdentifierTakenError{Identifier: identifier}
+				}
+			}
+		}
+	}
+	detail.Identifiers = identifiers
+	return &detail.Animal, nil
+}
+
+func (f *fakeAnimalDB) LookupAnimal(scheme model.IdentifierScheme, value string) (*model.Animal, error) {
+	var found []model.Animal
+	for _, detail := range f.animals {
+		for _, identifier := range detail.Identifiers {
+			if (scheme == "" || identifier.Scheme == scheme) && strings.EqualFold(identifier.Value, value) {
+				found = append(found, detail.Animal)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, database.ErrNotFound
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, database.ErrAmbiguousIdentifier
+	}
+}
+
+func newTestAnimalDB() *fakeAnimalDB {
+	return &fakeAnimalDB{
+		animals: map[int64]*model.AnimalDetail{
+			1: {Animal: model.Animal{ID: 1, Name: "Sheru", Type: "tiger", Variant: "bengal tiger"}},
+			2: {Animal: model.Animal{ID: 2, Name: "Raja", Type: "tiger", Variant: "bengal tiger"}},
+		},
+		deleted: make(map[int64]*model.AnimalDetail),
+		merged:  make(map[int64]int64),
+	}
+}
+
+func TestGetAnimal(t *testing.T) {
+	animalDB := &fakeAnimalDB{animals: map[int64]*model.AnimalDetail{
+		7: {
+			Animal:            model.Animal{ID: 7, Name: "Sheru", Type: "tiger"},
+			FirstSeen:         &model.SeenAt{SpottingTimestamp: "2023-01-01T10:00:00Z", Location: model.Point{Latitude: 12.5, Longitude: 77.1}},
+			LastSeen:          &model.SeenAt{SpottingTimestamp: "2023-06-01T10:00:00Z", Location: model.Point{Latitude: 12.7, Longitude: 77.3}},
+			TotalSightings:    4,
+			DistinctReporters: 2,
+			LatestImage:       &model.Image{ID: 3, FileName: "sheru.jpg", Type: "image/jpeg"},
+		},
+	}}
+	ac := NewAnimalController(animalDB)
+
+	rr := httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/7", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var detail model.AnimalDetail
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &detail))
+	assert.Equal(t, "Sheru", detail.Name)
+	assert.Equal(t, int64(4), detail.TotalSightings)
+	assert.Equal(t, "2023-06-01T10:00:00Z", detail.LastSeen.SpottingTimestamp)
+	assert.Equal(t, "sheru.jpg", detail.LatestImage.FileName)
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/8", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Unknown animal should not be found")
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/sheru", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Animal id should be numeric")
+}
+
+func TestUpdateAnimal(t *testing.T) {
+	ac := NewAnimalController(newTestAnimalDB())
+	patch := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := patch("/animal/1", `{"name":" Sherru ","date_of_birth":"2019-04-02"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"Sherru"`, "Name should be trimmed")
+
+	assert.Equal(t, http.StatusConflict, patch("/animal/1", `{"name":"Raja"}`).Code, "Taken name should conflict")
+	assert.Equal(t, http.StatusNotFound, patch("/animal/9", `{"name":"Bagh"}`).Code)
+	assert.Equal(t, http.StatusOK, patch("/animal/2", `{"variant":"bengal tiger"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/2", `{"variant":"bengal"}`).Code, "Variants outside the taxonomy should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{}`).Code, "Empty update should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{"name":"  "}`).Code, "Blank name should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{"variant":"`+strings.Repeat("x", 31)+`"}`).Code, "Long variant should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{"date_of_birth":"02-04-2019"}`).Code, "Malformed date should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{"date_of_birth":"2999-01-01"}`).Code, "Future date should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/animal/1", `{"description":"`+strings.Repeat("x", 101)+`"}`).Code, "Long description should be rejected")
+}
+
+func TestDeleteAndRestoreAnimal(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+
+	rr := httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodDelete, "/animal/1", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/1", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted animal should be hidden")
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodDelete, "/animal/1", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted animal cannot be deleted again")
+
+	rr = httptest.NewRecorder()
+	ac.AdminAnimalHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/animal/1/restore", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Sheru")
+
+	rr = httptest.NewRecorder()
+	ac.AdminAnimalHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/animal/2/restore", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Only deleted animals can be restored")
+}
+
+func TestListAnimals(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	list := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal"+query, nil))
+		return rr
+	}
+
+	rr := list("")
+	assert.Equal(t, http.StatusOK, rr.Code, "Filters and paging should be optional")
+	assert.JSONEq(t, `{"items":[],"has_more":false}`, rr.Body.String())
+	assert.Equal(t, model.AnimalFilter{Sort: model.AnimalSortLastSeen, Descending: true, Limit: defaultPageSize},
+		animalDB.lastFilter, "Latest sightings should come first by default without forcing a type")
+
+	cursor := database.EncodeCursor(model.Cursor{Sort: model.AnimalSortAge, Key: "2019-04-02", ID: 7})
+	rr = list("?name_prefix=sh&type=tiger&variant=bengal+tiger&min_age=2&max_age=5&seen_after=2023-01-01T00:00:00Z" +
+		"&seen_before=2023-02-01T00:00:00Z&bbox=77,12,78.5,13&sort=age&limit=10&total=true&cursor=" + cursor)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	filter := animalDB.lastFilter
+	assert.Equal(t, "sh", filter.NamePrefix)
+	assert.Equal(t, "tiger", filter.Type)
+	assert.Equal(t, "bengal tiger", filter.Variant)
+	assert.Equal(t, 2, *filter.MinAge)
+	assert.Equal(t, 5, *filter.MaxAge)
+	assert.Equal(t, 1, filter.SeenAfter.Day())
+	assert.Equal(t, &model.BoundingBox{MinLongitude: 77, MinLatitude: 12, MaxLongitude: 78.5, MaxLatitude: 13}, filter.BoundingBox)
+	assert.Equal(t, model.AnimalSortAge, filter.Sort)
+	assert.False(t, filter.Descending, "Ages should be ascending by default")
+	assert.Equal(t, 10, filter.Limit)
+	assert.Equal(t, &model.Cursor{Sort: model.AnimalSortAge, Key: "2019-04-02", ID: 7}, filter.Cursor)
+	assert.True(t, filter.WithTotal)
+
+	list("?sort=name&order=desc")
+	assert.True(t, animalDB.lastFilter.Descending)
+
+	list("?status=missing,+deceased")
+	assert.Equal(t, []model.AnimalStatus{model.AnimalStatusMissing, model.AnimalStatusDeceased}, animalDB.lastFilter.Statuses)
+
+	for _, query := range []string{
+		"?min_age=-1",
+		"?max_age=five",
+		"?min_age=6&max_age=5",
+		"?seen_after=yesterday",
+		"?seen_after=2023-02-01T00:00:00Z&seen_before=2023-01-01T00:00:00Z",
+		"?status=asleep",
+		"?status=active,",
+		"?bbox=77,12,78",
+		"?bbox=78.5,12,77,13",
+		"?bbox=77,12,78,NaN",
+		"?bbox=-181,12,78,13",
+		"?sort=weight",
+		"?order=up",
+		"?limit=0",
+		"?limit=101",
+		"?total=maybe",
+		"?cursor=not-a-cursor",
+		"?sort=name&cursor=" + cursor,
+		"?sort=age&order=desc&cursor=" + cursor,
+	} {
+		assert.Equal(t, http.StatusBadRequest, list(query).Code, "%s should be rejected", query)
+	}
+}
+
+func TestSearchAnimals(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	search := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/search"+query, nil))
+		return rr
+	}
+
+	cursor := database.EncodeCursor(model.Cursor{Sort: database.SearchSortRelevance, Descending: true, Key: "0.75", ID: 2})
+	rr := search("?q=+torn+ear+&type=tiger&max_age=4&highlight=true&limit=5&cursor=" + cursor)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"items":[],"has_more":false}`, rr.Body.String())
+	assert.Equal(t, "torn ear", animalDB.lastSearch.Query, "Query should be trimmed")
+	assert.Equal(t, "tiger", animalDB.lastSearch.Type)
+	assert.Equal(t, 4, *animalDB.lastSearch.MaxAge)
+	assert.True(t, animalDB.lastSearch.Highlight)
+	assert.Equal(t, 5, animalDB.lastSearch.Limit)
+	assert.Equal(t, int64(2), animalDB.lastSearch.Cursor.ID)
+
+	for _, query := range []string{
+		"",
+		"?q=++",
+		"?q=" + strings.Repeat("x", 101),
+		"?q=sheru&highlight=yes",
+		"?q=sheru&min_age=old",
+		"?q=sheru&cursor=" + database.EncodeCursor(model.Cursor{Sort: model.AnimalSortName, Key: "sheru", ID: 1}),
+	} {
+		assert.Equal(t, http.StatusBadRequest, search(query).Code, "%s should be rejected", query)
+	}
+}
+
+func TestFamilyTree(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	family := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := family("/animal/1/family")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"Sheru"`)
+	assert.Equal(t, defaultFamilyGenerations, animalDB.lastGenerations)
+
+	assert.Equal(t, http.StatusOK, family("/animal/1/family?generations=5").Code)
+	assert.Equal(t, 5, animalDB.lastGenerations)
+	assert.Equal(t, http.StatusNotFound, family("/animal/9/family").Code)
+	assert.Equal(t, http.StatusBadRequest, family("/animal/1/family?generations=0").Code, "Generations should be positive")
+	assert.Equal(t, http.StatusBadRequest, family("/animal/1/family?generations=6").Code, "Generations should be limited")
+}
+
+func TestSetParents(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	put := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := put("/animal/1/parents", `{"mother_id":2,"father_id":null}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"mother_id":2`)
+	assert.NotContains(t, rr.Body.String(), "father_id")
+
+	rr = put("/animal/1/parents", `{"mother_id":1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Lineage errors should be explained")
+	assert.Contains(t, rr.Body.String(), "its own mother")
+	assert.Equal(t, http.StatusNotFound, put("/animal/9/parents", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/animal/1/parents", `{"mother_id":"Raja"}`).Code)
+}
+
+func TestCreateLitter(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rr
+	}
+	litter := func(cubs string, dateOfBirth string, spottedAt string) string {
+		return `{"father_id":2,"date_of_birth":"` + dateOfBirth + `","cubs":` + cubs +
+			`,"sighting":{"location":{"latitude":12.5,"longitude":77.1},"spotting_timestamp":"` + spottedAt + `"}}`
+	}
+
+	rr := post("/animal/1/litter", litter(`[{"name":" Chotu "},{"name":"Mini","variant":"bengal tiger"}]`, "2023-03-01", "2023-06-01T10:00:00Z"))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created model.Litter
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Len(t, created.Cubs, 2)
+	assert.Equal(t, "Chotu", created.Cubs[0].Name, "Cub names should be trimmed")
+	assert.Equal(t, int64(1), *created.Cubs[0].MotherID)
+	assert.Equal(t, int64(2), *animalDB.lastLitter.FatherID)
+
+	assert.Equal(t, http.StatusNotFound, post("/animal/9/litter", litter(`[{"name":"Chotu"}]`, "2023-03-01", "2023-06-01T10:00:00Z")).Code)
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"name":"x"},`, maxLitterSize+1), ",") + "]"
+	for message, body := range map[string]string{
+		"Litter without cubs should be rejected":       litter(`[]`, "2023-03-01", "2023-06-01T10:00:00Z"),
+		"Litter with too many cubs should be rejected": litter(tooMany, "2023-03-01", "2023-06-01T10:00:00Z"),
+		"Blank cub name should be rejected":            litter(`[{"name":" "}]`, "2023-03-01", "2023-06-01T10:00:00Z"),
+		"Twins should not share a name":                litter(`[{"name":"Chotu"},{"name":"Chotu "}]`, "2023-03-01", "2023-06-01T10:00:00Z"),
+		"Malformed date of birth should be rejected":   litter(`[{"name":"Chotu"}]`, "01-03-2023", "2023-06-01T10:00:00Z"),
+		"Future date of birth should be rejected":      litter(`[{"name":"Chotu"}]`, "2999-01-01", "2999-06-01T10:00:00Z"),
+		"Sighting before the birth should be rejected": litter(`[{"name":"Chotu"}]`, "2023-03-01", "2023-02-01T10:00:00Z"),
+		"Malformed spotting time should be rejected":   litter(`[{"name":"Chotu"}]`, "2023-03-01", "yesterday"),
+		"Invalid payload should be rejected":           `{"cubs":"Chotu"}`,
+		"Cub identifiers should be validated":          litter(`[{"name":"Chotu","identifiers":[{"scheme":"tattoo","value":"C1"}]}]`, "2023-03-01", "2023-06-01T10:00:00Z"),
+	} {
+		assert.Equal(t, http.StatusBadRequest, post("/animal/1/litter", body).Code, message)
+	}
+}
+
+func TestAnimalStatus(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	put := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := put("/animal/1/status", `{"status":"missing","reason":" Not seen at the waterhole since March "}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"from":"active","to":"missing"`)
+	assert.Contains(t, rr.Body.String(), `"reason":"Not seen at the waterhole since March"`, "Reason should be trimmed")
+
+	rr = put("/animal/1/status", `{"status":"deceased","reason":"Carcass found","sighting_id":5}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"sighting_id":5`)
+
+	rr = put("/animal/1/status", `{"status":"active","reason":"Seen again"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code, "Deceased should be final")
+	assert.Contains(t, rr.Body.String(), "deceased cannot become active")
+
+	assert.Equal(t, http.StatusBadRequest, put("/animal/2/status", `{"status":"deceased","reason":"Carcass found","sighting_id":6}`).Code,
+		"Evidence should be a sighting of the animal")
+	assert.Equal(t, http.StatusBadRequest, put("/animal/2/status", `{"status":"asleep","reason":"Napping"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/animal/2/status", `{"status":"missing","reason":"  "}`).Code, "Reason should be mandatory")
+	assert.Equal(t, http.StatusBadRequest, put("/animal/2/status", `{"status":"missing","reason":"`+strings.Repeat("x", 256)+`"}`).Code)
+	assert.Equal(t, http.StatusNotFound, put("/animal/9/status", `{"status":"missing","reason":"Not seen"}`).Code)
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/1/status/history", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var history []model.AnimalStatusChange
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	assert.Len(t, history, 2)
+	assert.Equal(t, model.AnimalStatusDeceased, history[0].To, "Latest change should come first")
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/9/status/history", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestMergeAnimals(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	animalDB.animals[1].TotalSightings = 3
+	animalDB.animals[2].TotalSightings = 2
+	motherID := int64(1)
+	animalDB.animals[3] = &model.AnimalDetail{Animal: model.Animal{ID: 3, Name: "Chotu", Type: "tiger", MotherID: &motherID}}
+	ac := NewAnimalController(animalDB)
+	merge := func(role model.Role, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "principal", model.Principal{UserID: 5, Role: role}))
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusForbidden, merge(model.RoleRanger, "/animal/1/merge", `{"duplicate_id":2}`).Code,
+		"Rangers should not merge animals")
+	assert.Equal(t, http.StatusBadRequest, merge(model.RoleModerator, "/animal/1/merge", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, merge(model.RoleModerator, "/animal/1/merge", `{"duplicate_id":1}`).Code,
+		"An animal should not be merged into itself")
+	assert.Equal(t, http.StatusNotFound, merge(model.RoleModerator, "/animal/1/merge", `{"duplicate_id":9}`).Code)
+	rr := merge(model.RoleModerator, "/animal/1/merge", `{"duplicate_id":3}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "descendant")
+
+	rr = merge(model.RoleModerator, "/animal/1/merge", `{"duplicate_id":2}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var merged model.AnimalMerge
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &merged))
+	assert.Equal(t, int64(1), merged.Animal.ID)
+	assert.Equal(t, int64(2), merged.DuplicateID)
+	assert.Equal(t, 2, merged.MovedSightings)
+	assert.Len(t, merged.Conflicts, 1)
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/2", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code, "Lookups of the duplicate should be redirected")
+	assert.Equal(t, "/animal/1", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/2/family?generations=3", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/animal/1/family?generations=3", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/2/status/history", nil))
+	assert.Equal(t, "/animal/1/status/history", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	ac.animalNotFound(rr, httptest.NewRequest(http.MethodPut, "/animal/2/status", nil), 2)
+	assert.Equal(t, http.StatusPermanentRedirect, rr.Code, "Writes to the duplicate should keep their method")
+	assert.Equal(t, "/animal/1/status", rr.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusNotFound, merge(model.RoleModerator, "/animal/1/merge", `{"duplicate_id":2}`).Code,
+		"A merged animal should not be merged again")
+}
+
+func TestAnimalIdentifiers(t *testing.T) {
+	animalDB := newTestAnimalDB()
+	ac := NewAnimalController(animalDB)
+	put := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return rr
+	}
+	lookup := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ac.AnimalHandler(rr, httptest.NewRequest(http.MethodGet, "/animal/lookup?"+query, nil))
+		return rr
+	}
+
+	rr := put("/animal/1/identifiers", `[{"scheme":"stripe_id","value":" T-23 "},{"scheme":"nickname","value":"Machli"}]`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `{"scheme":"stripe_id","value":"T-23"}`, "Values should be trimmed")
+	assert.Equal(t, http.StatusOK, put("/animal/2/identifiers", `[{"scheme":"collar","value":"machli"}]`).Code,
+		"Values should be unique per scheme only")
+
+	rr = put("/animal/2/identifiers", `[{"scheme":"stripe_id","value":"t-23"}]`)
+	assert.Equal(t, http.StatusConflict, rr.Code, "Values should be unique per scheme ignoring case")
+	assert.Contains(t, rr.Body.String(), "already given to another animal")
+	assert.Equal(t, http.StatusBadRequest, put("/animal/1/identifiers", `[{"scheme":"tattoo","value":"T-23"}]`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/animal/1/identifiers", `[{"scheme":"collar","value":"  "}]`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/animal/1/identifiers", `[{"scheme":"collar","value":"C-1"},{"scheme":"collar","value":"c-1"}]`).Code,
+		"An identifier should not be given twice")
+	assert.Equal(t, http.StatusNotFound, put("/animal/9/identifiers", `[]`).Code)
+
+	rr = lookup("scheme=stripe_id&value=t-23")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var animal model.Animal
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &animal))
+	assert.Equal(t, int64(1), animal.ID)
+	assert.Len(t, animal.Identifiers, 2)
+
+	assert.Equal(t, http.StatusOK, lookup("value=T-23").Code, "Any scheme should be looked up without one")
+	assert.Equal(t, http.StatusConflict, lookup("value=Machli").Code, "Values under several schemes should be ambiguous")
+	assert.Equal(t, http.StatusOK, lookup("scheme=nickname&value=Machli").Code)
+	assert.Equal(t, http.StatusNotFound, lookup("scheme=collar&value=T-23").Code)
+	assert.Equal(t, http.StatusBadRequest, lookup("scheme=tattoo&value=T-23").Code)
+	assert.Equal(t, http.StatusBadRequest, lookup("scheme=collar").Code, "value should be mandatory")
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"unicode/utf8"
+)
+
+// Identifiers are limited so that an animal cannot hoard nicknames. Values fit the column of the identifier table.
+const (
+	maxAnimalIdentifiers     = 20
+	maxIdentifierValueLength = 50
+)
+
+// setIdentifiers replaces the identifiers of the animal with the ones of the request body.
+func (ac *AnimalController) setIdentifiers(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	var identifiers []model.AnimalIdentifier
+	if err := json.NewDecoder(r.Body).Decode(&identifiers); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateIdentifiers(identifiers); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	animal, err := ac.animal.SetIdentifiers(animalID, identifiers, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	var takenErr *database.IdentifierTakenError
+	if errors.As(err, &takenErr) {
+		errRes := ErrorResponse{Error: takenErr.Error()}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update identifiers"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animal, http.StatusOK)
+}
+
+// lookupAnimal resolves the value query parameter to the animal it identifies, under the scheme query parameter
+// when it is given.
+func (ac *AnimalController) lookupAnimal(w http.ResponseWriter, r *http.Request) {
+	scheme := model.IdentifierScheme(r.URL.Query().Get("scheme"))
+	if scheme != "" && !scheme.Valid() {
+		errRes := ErrorResponse{Error: "scheme should be one of collar, stripe_id, external_registry and nickname"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	value := strings.TrimSpace(r.URL.Query().Get("value"))
+	if value == "" || utf8.RuneCountInString(value) > maxIdentifierValueLength {
+		errRes := ErrorResponse{Error: fmt.Sprintf("value is mandatory and at most %d characters", maxIdentifierValueLength)}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	animal, err := ac.animal.LookupAnimal(scheme, value)
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "No animal has the identifier"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrAmbiguousIdentifier) {
+		errRes := ErrorResponse{Error: "The identifier is given to several animals under different schemes, pass the scheme"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to look up animal"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animal, http.StatusOK)
+}
+
+// validateIdentifiers trims the values of the identifiers and returns why they are invalid, if they are.
+func validateIdentifiers(identifiers []model.AnimalIdentifier) string {
+	if len(identifiers) > maxAnimalIdentifiers {
+		return fmt.Sprintf("An animal can have at most %d identifiers", maxAnimalIdentifiers)
+	}
+	seen := make(map[model.AnimalIdentifier]bool, len(identifiers))
+	for i := range identifiers {
+		identifier := &identifiers[i]
+		if !identifier.Scheme.Valid() {
+			return "The scheme of every identifier should be one of collar, stripe_id, external_registry and nickname"
+		}
+		identifier.Value = strings.TrimSpace(identifier.Value)
+		if identifier.Value == "" || utf8.RuneCountInString(identifier.Value) > maxIdentifierValueLength {
+			return fmt.Sprintf("The value of every identifier should be 1 to %d characters", maxIdentifierValueLength)
+		}
+		key := model.AnimalIdentifier{Scheme: identifier.Scheme, Value: strings.ToLower(identifier.Value)}
+		if seen[key] {
+			return fmt.Sprintf("The %s %s is given twice", identifier.Scheme, identifier.Value)
+		}
+		seen[key] = true
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// mergeAnimals merges the duplicate of the request body into the animal in the path. Only moderators may merge, on
+// top of the permission to create animals that every POST to /animal needs.
+func (ac *AnimalController) mergeAnimals(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	if !principal.Can(model.PermissionMergeAnimals) {
+		errRes := ErrorResponse{Error: "You are not allowed to perform this action"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	var mergeReq model.AnimalMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&mergeReq); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if mergeReq.DuplicateID <= 0 {
+		errRes := ErrorResponse{Error: "duplicate_id is mandatory"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if mergeReq.DuplicateID == animalID {
+		errRes := ErrorResponse{Error: "An animal cannot be merged into itself"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	merge, err := ac.animal.MergeAnimals(animalID, mergeReq.DuplicateID, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal or duplicate not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	var lineageErr *database.LineageError
+	if errors.As(err, &lineageErr) {
+		errRes := ErrorResponse{Error: lineageErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to merge animals"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	logger.LogAudit(principal.Username, "animal.merge", "animal", animalID, "duplicate", mergeReq.DuplicateID,
+		"conflicts", len(merge.Conflicts))
+	WriteJSONResponse(w, merge, http.StatusOK)
+}
+
+// animalNotFound redirects lookups of an animal that was merged into another to the same path of that animal, and
+// writes the not found response otherwise. Only GET requests are redirected permanently, other methods are
+// redirected with 308 so that clients repeat them with the same method and body instead of turning them into a GET.
+func (ac *AnimalController) animalNotFound(w http.ResponseWriter, r *http.Request, animalID int64) {
+	mergedIntoID, err := ac.animal.MergedInto(animalID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	segments := pathSegments(r, "/animal")
+	segments[0] = strconv.FormatInt(mergedIntoID, 10)
+	location := "/animal/" + strings.Join(segments, "/")
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	status := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, location, status)
+}
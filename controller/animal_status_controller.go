@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"unicode/utf8"
+)
+
+// setAnimalStatus moves the animal to another status, with a reason and optionally a sighting as evidence.
+func (ac *AnimalController) setAnimalStatus(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	var update model.AnimalStatusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if !update.Status.Valid() {
+		errRes := ErrorResponse{Error: "status should be one of active, missing, rehabilitation, relocated and deceased"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	update.Reason = strings.TrimSpace(update.Reason)
+	if update.Reason == "" || utf8.RuneCountInString(update.Reason) > maxReasonLength {
+		errRes := ErrorResponse{Error: fmt.Sprintf("reason is mandatory and at most %d characters", maxReasonLength)}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	change, err := ac.animal.SetAnimalStatus(animalID, &update, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrUnknownSighting) {
+		errRes := ErrorResponse{Error: "sighting_id should be a sighting of the animal"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	var transitionErr *database.StatusTransitionError
+	if errors.As(err, &transitionErr) {
+		errRes := ErrorResponse{Error: transitionErr.Error()}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to change animal status"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, change, http.StatusOK)
+}
+
+func (ac *AnimalController) listStatusHistory(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	changes, err := ac.animal.ListStatusHistory(animalID)
+	if errors.Is(err, database.ErrNotFound) {
+		ac.animalNotFound(w, r, animalID)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve animal status history"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, changes, http.StatusOK)
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+const (
+	// APIKeyHeader is the request header machine clients send their API key in.
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyPrefix       = "thk_"
+	apiKeyPrefixLength = len(apiKeyPrefix) + 8
+	maxAPIKeyNameLen   = 100
+)
+
+type APIKeyController struct {
+	apiKey database.IAPIKey
+}
+
+func NewAPIKeyController(apiKey database.IAPIKey) *APIKeyController {
+	return &APIKeyController{
+		apiKey: apiKey,
+	}
+}
+
+type apiKeyRequest struct {
+	Name      string             `json:"name"`
+	Scopes    []model.Permission `json:"scopes"`
+	ExpiresAt *time.Time         `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once when a key is created, it is the only time the key itself is shown.
+type CreatedAPIKey struct {
+	model.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyHandler serves /user/api-key for the logged in user: GET lists their keys, POST creates one
+// and DELETE /user/api-key/{id} revokes one.
+func (ac *APIKeyController) APIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(int64)
+	segments := pathSegments(r, "/user/api-key")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		keys, err := ac.apiKey.ListAPIKeys(userID)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve API keys"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, keys, http.StatusOK)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		ac.createAPIKey(w, r, userID)
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		keyID, err := strconv.ParseInt(segments[0], 10, 64)
+		if err != nil {
+			errRes := ErrorResponse{Error: "API key id should be of bigint value"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		err = ac.apiKey.RevokeAPIKey(keyID, userID)
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "API key not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to revoke API key"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+func (ac *APIKeyController) createAPIKey(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Name) > maxAPIKeyNameLen {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	if err := validateScopes(principal, req.Scopes); err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		errRes := ErrorResponse{Error: "expires_at should be in the future"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	secret, err := newOpaqueToken(32)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to create API key"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	key := apiKeyPrefix + secret
+	created := CreatedAPIKey{
+		APIKey: model.APIKey{
+			UserID:    userID,
+			Name:      req.Name,
+			Prefix:    key[:apiKeyPrefixLength],
+			KeyHash:   HashAPIKey(key),
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+		},
+		Key: key,
+	}
+	if err = ac.apiKey.CreateAPIKey(&created.APIKey); err != nil {
+		errRes := ErrorResponse{Error: "Failed to create API key"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, created, http.StatusCreated)
+}
+
+// validateScopes checks that the scopes are known permissions the principal's role grants.
+func validateScopes(principal model.Principal, scopes []model.Permission) error {
+	if len(scopes) == 0 {
+		return errors.New("scopes should list at least one permission")
+	}
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return errors.New("unknown scope " + string(scope))
+		}
+		if !principal.Role.Can(scope) {
+			return errors.New("your role does not grant scope " + string(scope))
+		}
+	}
+	return nil
+}
+
+// HashAPIKey returns the hash API keys are stored and looked up by.
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
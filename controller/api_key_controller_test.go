@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/model"
+)
+
+func TestValidateScopes(t *testing.T) {
+	ranger := model.Principal{UserID: 1, Role: model.RoleRanger}
+	assert.NoError(t, validateScopes(ranger, []model.Permission{model.PermissionCreateSighting, model.PermissionCreateAnimal}), "Rangers may delegate their permissions")
+	assert.Error(t, validateScopes(ranger, nil), "At least one scope should be required")
+	assert.Error(t, validateScopes(ranger, []model.Permission{"sighting:delete"}), "Unknown scopes should be rejected")
+	assert.Error(t, validateScopes(ranger, []model.Permission{model.PermissionManageUsers}), "Scopes should not exceed the role")
+}
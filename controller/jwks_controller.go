@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"net/http"
+	"tigerhall-kittens/keystore"
+)
+
+type JWKSController struct {
+	keys *keystore.KeySet
+}
+
+func NewJWKSController(keys *keystore.KeySet) *JWKSController {
+	return &JWKSController{
+		keys: keys,
+	}
+}
+
+// JWKSHandler publishes the public keys tokens are signed with so that other services can verify them.
+func (jc *JWKSController) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	WriteJSONResponse(w, jc.keys.JWKS(), http.StatusOK)
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"time"
+	"unicode/utf8"
+)
+
+// Family trees are limited so that a single request cannot walk the whole lineage.
+const (
+	defaultFamilyGenerations = 2
+	maxFamilyGenerations     = 5
+)
+
+const maxLitterSize = 10
+
+// getFamilyTree returns the ancestors and descendants of the animal up to the generations query parameter.
+func (ac *AnimalController) getFamilyTree(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	generations := defaultFamilyGenerations
+	if value := r.URL.Query().Get("generations"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxFamilyGenerations {
+			errRes := ErrorResponse{Error: fmt.Sprintf("generations should be between 1 and %d", maxFamilyGenerations)}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		generations = parsed
+	}
+	tree, err := ac.animal.GetFamilyTree(animalID, generations)
+	if errors.Is(err, database.ErrNotFound) {
+		ac.animalNotFound(w, r, animalID)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve family tree"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, tree, http.StatusOK)
+}
+
+// setParents replaces the mother and father of the animal, null for an unknown parent.
+func (ac *AnimalController) setParents(w http.ResponseWriter, r *http.Request, id string) {
+	animalID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	var parents model.AnimalParents
+	if err := json.NewDecoder(r.Body).Decode(&parents); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	animal, err := ac.animal.SetParents(animalID, parents, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	var lineageErr *database.LineageError
+	if errors.As(err, &lineageErr) {
+		errRes := ErrorResponse{Error: lineageErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update parents"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, animal, http.StatusOK)
+}
+
+// createLitter registers the cubs of the mother in the path along with where they were seen.
+func (ac *AnimalController) createLitter(w http.ResponseWriter, r *http.Request, id string) {
+	motherID, ok := parseAnimalID(w, id)
+	if !ok {
+		return
+	}
+	var litter model.LitterRequest
+	if err := json.NewDecoder(r.Body).Decode(&litter); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateLitter(&litter); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	litter.Sighting.Reporter.ID = userID
+	litter.Sighting.Image = model.Image{}
+	created, err := ac.animal.CreateLitter(motherID, &litter, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Animal not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrUnknownTaxon) {
+		errRes := ErrorResponse{Error: "variant should be a subspecies of the mother's type in the taxonomy"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "An animal with same name, type and variant already exists"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	var takenErr *database.IdentifierTakenError
+	if errors.As(err, &takenErr) {
+		errRes := ErrorResponse{Error: takenErr.Error()}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	var lineageErr *database.LineageError
+	if errors.As(err, &lineageErr) {
+		errRes := ErrorResponse{Error: lineageErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to register litter"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, created, http.StatusCreated)
+}
+
+// validateLitter trims the names and variants of the cubs and returns why the litter is invalid, if it is.
+func validateLitter(litter *model.LitterRequest) string {
+	dateOfBirth, err := time.Parse("2006-01-02", litter.DateOfBirth)
+	if err != nil {
+		return "date_of_birth should be in YYYY-MM-DD format"
+	}
+	if dateOfBirth.After(time.Now()) {
+		return "date_of_birth cannot be in the future"
+	}
+	if len(litter.Cubs) == 0 || len(litter.Cubs) > maxLitterSize {
+		return fmt.Sprintf("A litter should have 1 to %d cubs", maxLitterSize)
+	}
+	names := make(map[string]bool, len(litter.Cubs))
+	for i := range litter.Cubs {
+		cub := &litter.Cubs[i]
+		cub.Name = strings.TrimSpace(cub.Name)
+		if cub.Name == "" || utf8.RuneCountInString(cub.Name) > maxAnimalNameLength {
+			return fmt.Sprintf("The name of every cub should be 1 to %d characters", maxAnimalNameLength)
+		}
+		if names[cub.Name] {
+			return fmt.Sprintf("Cubs of a litter should have different names, %s is given twice", cub.Name)
+		}
+		names[cub.Name] = true
+		cub.Variant = strings.TrimSpace(cub.Variant)
+		if utf8.RuneCountInString(cub.Variant) > maxAnimalNameLength {
+			return fmt.Sprintf("The variant of a cub should be at most %d characters", maxAnimalNameLength)
+		}
+		if utf8.RuneCountInString(cub.Description) > maxAnimalDescriptionLength {
+			return fmt.Sprintf("The description of a cub should be at most %d characters", maxAnimalDescriptionLength)
+		}
+		if message := validateIdentifiers(cub.Identifiers); message != "" {
+			return message
+		}
+	}
+	spottedAt, err := time.Parse(time.RFC3339, litter.Sighting.SpottingTimestamp)
+	if err != nil {
+		return "sighting.spotting_timestamp should be an RFC 3339 timestamp"
+	}
+	if spottedAt.Before(dateOfBirth) {
+		return "A litter cannot be seen before it is born"
+	}
+	location := litter.Sighting.Location
+	if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+		return "sighting.location should have a latitude between -90 and 90 and a longitude between -180 and 180"
+	}
+	return ""
+}
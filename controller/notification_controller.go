@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+)
+
+type NotificationController struct {
+	notification database.INotification
+}
+
+func NewNotificationController(n database.INotification) *NotificationController {
+	return &NotificationController{
+		notification: n,
+	}
+}
+
+func (nc *NotificationController) NotificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(int64)
+	segments := pathSegments(r, "/notification")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		nc.listNotifications(w, r, userID)
+	case len(segments) == 1 && segments[0] == "unread-count" && r.Method == http.MethodGet:
+		count, err := nc.notification.UnreadNotificationCount(userID)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve unread notification count"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, map[string]int64{"unread_count": count}, http.StatusOK)
+	case len(segments) == 1 && segments[0] == "read-all" && r.Method == http.MethodPost:
+		updated, err := nc.notification.MarkAllNotificationsRead(userID)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to mark notifications as read"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, map[string]int64{"updated": updated}, http.StatusOK)
+	case len(segments) == 2 && segments[1] == "read" && r.Method == http.MethodPost:
+		notificationID, err := strconv.ParseInt(segments[0], 10, 64)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Notification id should be of bigint value"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+		err = nc.notification.MarkNotificationRead(userID, notificationID)
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "Notification not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.LogError(err)
+			errRes := ErrorResponse{Error: "Failed to mark notification as read"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+func (nc *NotificationController) listNotifications(w http.ResponseWriter, r *http.Request, userID int64) {
+	queryParams := r.URL.Query()
+	limit := queryParams.Get("limit")
+	offset := queryParams.Get("offset")
+	if limit == "" || offset == "" {
+		errRes := ErrorResponse{Error: "limit and offset are mandatory query parameter(s)"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	unreadOnly := false
+	if unread := queryParams.Get("unread"); unread != "" {
+		var err error
+		unreadOnly, err = strconv.ParseBool(unread)
+		if err != nil {
+			errRes := ErrorResponse{Error: "unread query parameter should be a boolean"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+	}
+	notifications, err := nc.notification.ListNotifications(userID, unreadOnly, limit, offset)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve notifications"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, notifications, http.StatusOK)
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/oidc"
+	"time"
+)
+
+const oidcStateCookie = "oidc_state"
+
+type OIDCController struct {
+	users     *UserController
+	identity  database.IIdentity
+	providers map[string]*oidc.Provider
+}
+
+func NewOIDCController(users *UserController, identity database.IIdentity, providers map[string]*oidc.Provider) *OIDCController {
+	return &OIDCController{
+		users:     users,
+		identity:  identity,
+		providers: providers,
+	}
+}
+
+// OIDCHandler serves /user/oidc/{provider}/login, which redirects to the identity provider,
+// and /user/oidc/{provider}/callback, which links the identity to a local user and issues our own tokens.
+func (oc *OIDCController) OIDCHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r, "/user/oidc")
+	if len(segments) != 2 {
+		errRes := ErrorResponse{Error: "Not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	provider, ok := oc.providers[segments[0]]
+	if !ok {
+		errRes := ErrorResponse{Error: "Unknown identity provider"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	switch segments[1] {
+	case "login":
+		oc.login(w, r, provider)
+	case "callback":
+		oc.callback(w, r, provider)
+	default:
+		errRes := ErrorResponse{Error: "Not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+	}
+}
+
+func (oc *OIDCController) login(w http.ResponseWriter, r *http.Request, provider *oidc.Provider) {
+	state, err := newOpaqueToken(16)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to start login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	nonce, err := newOpaqueToken(16)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to start login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "." + nonce,
+		Path:     "/user/oidc/" + provider.Name,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+func (oc *OIDCController) callback(w http.ResponseWriter, r *http.Request, provider *oidc.Provider) {
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/user/oidc/" + provider.Name, MaxAge: -1})
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Login session expired, please try again"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	state, nonce, _ := strings.Cut(cookie.Value, ".")
+	queryParams := r.URL.Query()
+	if errParam := queryParams.Get("error"); errParam != "" {
+		errRes := ErrorResponse{Error: "Identity provider returned " + errParam}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	if state == "" || queryParams.Get("state") != state || queryParams.Get("code") == "" {
+		errRes := ErrorResponse{Error: "Invalid login callback"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	identity, err := provider.Exchange(queryParams.Get("code"), nonce)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Invalid credentials"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	user, err := oc.identity.FindOrCreateUserByIdentity(&model.ExternalIdentity{
+		Provider:          provider.Name,
+		Subject:           identity.Subject,
+		Email:             identity.Email,
+		EmailVerified:     identity.EmailVerified,
+		PreferredUsername: identity.PreferredUsername,
+	}, auditActor(r, 0))
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "An account is already registered with this email address, log in with its password and verify the email to sign in through " + provider.Name}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to link identity"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if user.Disabled {
+		errRes := ErrorResponse{Error: "Account has been disabled"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	challenge, err := oc.users.twoFactorChallenge(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		WriteJSONResponse(w, challenge, http.StatusAccepted)
+		return
+	}
+	tokens, err := oc.users.issueTokens(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, tokens, http.StatusOK)
+	logger.LogInfo(user.Username, " successfully logged in through", provider.Name)
+}
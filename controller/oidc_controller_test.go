@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/oidc"
+	"tigerhall-kittens/oidc/oidctest"
+)
+
+type fakeIdentityDB struct {
+	users map[string]*model.User
+	// takenEmails are the emails of local users the identities cannot be linked to.
+	takenEmails map[string]bool
+}
+
+func (f *fakeIdentityDB) FindOrCreateUserByIdentity(identity *model.ExternalIdentity, actor model.Actor) (*model.User, error) {
+	key := identity.Provider + "/" + identity.Subject
+	if user, ok := f.users[key]; ok {
+		return user, nil
+	}
+	if f.takenEmails[identity.Email] {
+		return nil, database.ErrConflict
+	}
+	user := &model.User{ID: int64(len(f.users) + 1), Username: identity.PreferredUsername, Email: identity.Email,
+		EmailVerified: identity.EmailVerified, Role: model.RoleReporter}
+	f.users[key] = user
+	return user, nil
+}
+
+type fakeTokenDB struct {
+	database.IToken
+	created       []*model.RefreshToken
+	accountTokens map[string]int64
+}
+
+func (f *fakeTokenDB) CreateRefreshToken(token *model.RefreshToken) error {
+	f.created = append(f.created, token)
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := oidctest.NewProvider(oidctest.User{Subject: "partner-42", Email: "ranger@partner.org", EmailVerified: true, PreferredUsername: "ranger"})
+	defer idp.Close()
+	provider, err := oidc.NewProvider("partner", idp.URL, oidctest.ClientID, oidctest.ClientSecret, "http://localhost:9001/user/oidc/partner/callback")
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err)
+	}
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	identities := &fakeIdentityDB{users: make(map[string]*model.User)}
+	tokens := &fakeTokenDB{}
+	oc := NewOIDCController(NewUserController(nil, tokens, nil, &fakeTwoFactorDB{}, keys, nil), identities, map[string]*oidc.Provider{"partner": provider})
+
+	for attempt := 0; attempt < 2; attempt++ {
+		rr := oidcLogin(t, oc)
+		assert.Equal(t, http.StatusOK, rr.Code, "Callback should issue tokens")
+
+		var response TokenResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Unexpected error decoding token response")
+		claims := &model.Claims{}
+		_, err = jwt.ParseWithClaims(response.Token, claims, keys.Keyfunc)
+		assert.NoError(t, err, "Issued token should verify with our own keys")
+		assert.Equal(t, int64(1), claims.UserID, "Identity should be linked to the same user on every login")
+	}
+	assert.Len(t, identities.users, 1, "User should only be created on first login")
+	assert.True(t, identities.users["partner/partner-42"].EmailVerified, "Email verified by the provider should be verified")
+	assert.Len(t, tokens.created, 2, "Every login should start a refresh token family")
+}
+
+func TestOIDCLogin_EmailTaken(t *testing.T) {
+	idp := oidctest.NewProvider(oidctest.User{Subject: "partner-42", Email: "ranger@partner.org", PreferredUsername: "ranger"})
+	defer idp.Close()
+	provider, err := oidc.NewProvider("partner", idp.URL, oidctest.ClientID, oidctest.ClientSecret, "http://localhost:9001/user/oidc/partner/callback")
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err)
+	}
+	identities := &fakeIdentityDB{users: make(map[string]*model.User), takenEmails: map[string]bool{"ranger@partner.org": true}}
+	oc := NewOIDCController(nil, identities, map[string]*oidc.Provider{"partner": provider})
+
+	rr := oidcLogin(t, oc)
+	assert.Equal(t, http.StatusConflict, rr.Code, "Email of a local account should not be taken over")
+	assert.Contains(t, rr.Body.String(), "log in with its password", "User should be told how to sign in")
+	assert.Empty(t, identities.users, "No user should be created")
+}
+
+// oidcLogin starts a login at the partner provider, follows it through the stand-in identity provider and returns
+// the response of the callback.
+func oidcLogin(t *testing.T, oc *OIDCController) *httptest.ResponseRecorder {
+	// Start the login, which redirects to the identity provider
+	rr := httptest.NewRecorder()
+	oc.OIDCHandler(rr, httptest.NewRequest(http.MethodGet, "/user/oidc/partner/login", nil))
+	assert.Equal(t, http.StatusFound, rr.Code, "Login should redirect to the identity provider")
+	stateCookie := rr.Result().Cookies()[0]
+
+	// The stand-in provider authenticates immediately and redirects back with a code
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Error calling authorization endpoint: %s", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Error parsing callback: %s", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(stateCookie)
+	rr = httptest.NewRecorder()
+	oc.OIDCHandler(rr, req)
+	return rr
+}
+
+func TestOIDCCallback_StateMismatch(t *testing.T) {
+	oc := NewOIDCController(nil, nil, map[string]*oidc.Provider{"partner": {Name: "partner"}})
+	req := httptest.NewRequest(http.MethodGet, "/user/oidc/partner/callback?code=abc&state=forged", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "expected.nonce"})
+	rr := httptest.NewRecorder()
+	oc.OIDCHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Forged state should be rejected")
+
+	rr = httptest.NewRecorder()
+	oc.OIDCHandler(rr, httptest.NewRequest(http.MethodGet, "/user/oidc/unknown/login", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Unknown provider should be rejected")
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+const maxUsernameLength = 30
+
+type profileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	Password        string `json:"password"`
+}
+
+// ProfileHandler serves the account of the logged in user: GET and PATCH /user/me, POST /user/me/password,
+// DELETE /user/me and GET /user/me/export.
+func (uc *UserController) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(int64)
+	segments := pathSegments(r, "/user/me")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		profile, err := uc.user.GetProfile(userID)
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "User not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to retrieve profile"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		WriteJSONResponse(w, profile, http.StatusOK)
+	case len(segments) == 0 && r.Method == http.MethodPatch:
+		uc.updateProfile(w, r, userID)
+	case len(segments) == 0 && r.Method == http.MethodDelete:
+		uc.deleteAccount(w, r, userID)
+	case len(segments) == 1 && segments[0] == "password" && r.Method == http.MethodPost:
+		uc.changePassword(w, r, userID)
+	case len(segments) == 1 && segments[0] == "export" && r.Method == http.MethodGet:
+		export, err := uc.user.ExportUserData(userID)
+		if err != nil {
+			errRes := ErrorResponse{Error: "Failed to export user data"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tigerhall-kittens-user-%d.json"`, userID))
+		WriteJSONResponse(w, export, http.StatusOK)
+	case len(segments) > 1 || (len(segments) == 1 && segments[0] != "password" && segments[0] != "export"):
+		errRes := ErrorResponse{Error: "Not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+func (uc *UserController) updateProfile(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req profileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Username == nil && req.Email == nil) {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if req.Username != nil && (*req.Username == "" || len(*req.Username) > maxUsernameLength) {
+		errRes := ErrorResponse{Error: fmt.Sprintf("username should be between 1 and %d characters", maxUsernameLength)}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if req.Email != nil && !validEmail(*req.Email) {
+		errRes := ErrorResponse{Error: "Invalid email address"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	current, err := uc.user.GetProfile(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	profile, err := uc.user.UpdateProfile(userID, req.Username, req.Email, auditActor(r, userID))
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "Username or email is already taken"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update profile"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if !strings.EqualFold(current.Email, profile.Email) {
+		user := &model.User{ID: profile.ID, Username: profile.Username, Email: profile.Email}
+		if err = uc.sendEmailVerification(user); err != nil {
+			logger.LogError(err)
+		}
+	}
+	WriteJSONResponse(w, profile, http.StatusOK)
+}
+
+// changePassword requires the current password. Every other session is logged out and the response
+// carries fresh tokens for the current one.
+func (uc *UserController) changePassword(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+		errRes := ErrorResponse{Error: "current_password and new_password are mandatory"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err := uc.user.CheckPasswordPolicy(req.NewPassword); err != nil {
+		errRes := ErrorResponse{Error: err.Error()}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	user, ok := uc.confirmPassword(w, r, userID, req.CurrentPassword)
+	if !ok {
+		return
+	}
+	if err := uc.user.UpdatePassword(userID, req.NewPassword, auditActor(r, userID)); err != nil {
+		errRes := ErrorResponse{Error: "Failed to change password"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if err := uc.token.RevokeUserTokens(userID); err != nil {
+		logger.LogError(err)
+	}
+	uc.revokeRequestToken(r)
+	tokens, err := uc.issueTokens(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, tokens, http.StatusOK)
+}
+
+// deleteAccount requires the password of accounts that have one. Accounts created through an identity
+// provider only need a valid token.
+func (uc *UserController) deleteAccount(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req passwordRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errRes := ErrorResponse{Error: "Invalid request payload"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+	}
+	user, err := uc.user.GetUserByID(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if user.Password != "" {
+		if _, ok := uc.confirmPassword(w, r, userID, req.Password); !ok {
+			return
+		}
+	}
+	if err = uc.user.DeleteUser(userID, auditActor(r, userID)); err != nil {
+		errRes := ErrorResponse{Error: "Failed to delete account"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	uc.revokeRequestToken(r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirmPassword checks the password of the logged in user, counting failures towards the login lockout
+// so that a stolen token cannot be used to guess the password. It writes the error response when it fails.
+func (uc *UserController) confirmPassword(w http.ResponseWriter, r *http.Request, userID int64, password string) (*model.User, bool) {
+	user, err := uc.user.GetUserByID(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return nil, false
+	}
+	key := model.LoginKey{Kind: database.LoginKeyUsername, Key: strings.ToLower(user.Username)}
+	lockedUntil, err := uc.loginAttempt.LockedUntil([]model.LoginKey{key})
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to verify password"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !lockedUntil.IsZero() {
+		writeLockedOut(w, lockedUntil)
+		return nil, false
+	}
+	if user.Password == "" || !uc.user.VerifyPassword(user, password, auditActor(r, userID)) {
+		if _, err := uc.loginAttempt.RecordLoginFailure(key); err != nil {
+			logger.LogError(err)
+		}
+		errRes := ErrorResponse{Error: "Current password is incorrect"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func (uc *UserController) revokeRequestToken(r *http.Request) {
+	jti, ok := r.Context().Value("token_id").(string)
+	if !ok || jti == "" {
+		return
+	}
+	expiresAt, _ := r.Context().Value("token_expires_at").(time.Time)
+	if err := uc.token.RevokeAccessToken(jti, expiresAt); err != nil {
+		logger.LogError(err)
+	}
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/password"
+	"time"
+)
+
+func (f *fakeUserDB) UpdatePassword(userId int64, password string, actor model.Actor) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	f.users[userId].Password = string(hashedPassword)
+	return nil
+}
+
+func (f *fakeUserDB) CheckPasswordPolicy(plain string) error {
+	return password.NewPolicy(12, "password1234").Check(plain)
+}
+
+func (f *fakeUserDB) VerifyPassword(user *model.User, plain string, actor model.Actor) bool {
+	return user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plain)) == nil
+}
+
+func (f *fakeUserDB) DeleteUser(userId int64, actor model.Actor) error {
+	delete(f.users, userId)
+	return nil
+}
+
+func (f *fakeTokenDB) RevokeUserTokens(userId int64) error {
+	return nil
+}
+
+func (f *fakeTokenDB) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return nil
+}
+
+func profileRequestWithUser(method string, path string, body string, userID int64) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	return req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+}
+
+func newPasswordUser(t *testing.T, id int64, password string) *model.User {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %s", err)
+	}
+	return &model.User{ID: id, Username: "ranger", Password: string(hashedPassword), Role: model.RoleRanger}
+}
+
+func TestChangePassword(t *testing.T) {
+	user := newPasswordUser(t, 3, "old-password")
+	uc, _ := newTwoFactorTestController(t, user)
+
+	rr := httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodPost, "/user/me/password", `{"current_password":"wrong","new_password":"new-password"}`, user.ID))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Wrong current password should be rejected")
+
+	rr = httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodPost, "/user/me/password", `{"current_password":"old-password","new_password":"short"}`, user.ID))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Short password should be rejected")
+
+	rr = httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodPost, "/user/me/password", `{"current_password":"old-password","new_password":"password1234"}`, user.ID))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Breached password should be rejected")
+	assert.Contains(t, rr.Body.String(), "breach")
+
+	rr = httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodPost, "/user/me/password", `{"current_password":"old-password","new_password":"new-password"}`, user.ID))
+	assert.Equal(t, http.StatusOK, rr.Code, "Password should be changed")
+	assert.Contains(t, rr.Body.String(), "refresh_token", "Fresh tokens expected")
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new-password")), "New password should be stored")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	user := newPasswordUser(t, 4, "secret-password")
+	uc, _ := newTwoFactorTestController(t, user)
+
+	rr := httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodDelete, "/user/me", `{"password":"wrong"}`, user.ID))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Deletion should require the password")
+
+	rr = httptest.NewRecorder()
+	uc.ProfileHandler(rr, profileRequestWithUser(http.MethodDelete, "/user/me", `{"password":"secret-password"}`, user.ID))
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Account should be deleted")
+	_, err := uc.user.GetUserByID(user.ID)
+	assert.Error(t, err, "Deleted user should be gone")
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/nfnt/resize"
@@ -29,6 +31,10 @@ func NewSightingController(s database.ISighting, p sarama.SyncProducer) *Sightin
 }
 
 func (sc *SightingController) SightingHandler(w http.ResponseWriter, r *http.Request) {
+	if segments := pathSegments(r, "/sighting"); len(segments) > 0 {
+		sc.moderateSighting(w, r, segments)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		queryParams := r.URL.Query()
@@ -38,14 +44,14 @@ func (sc *SightingController) SightingHandler(w http.ResponseWriter, r *http.Req
 			WriteJSONResponse(w, errRes, http.StatusBadRequest)
 			return
 		}
-		limit := queryParams.Get("limit")
-		offset := queryParams.Get("offset")
-		if limit == "" || offset == "" {
-			errRes := ErrorResponse{Error: "limit and offset are mandatory query parameter(s)"}
+		filter := model.SightingFilter{AnimalID: animalId}
+		filter.Limit, filter.Cursor, filter.WithTotal, err = cursorParams(r, database.SightingSortTimestamp, true)
+		if err != nil {
+			errRes := ErrorResponse{Error: err.Error()}
 			WriteJSONResponse(w, errRes, http.StatusBadRequest)
 			return
 		}
-		sightings, err := sc.sighting.ListSightingInfo(animalId, limit, offset)
+		sightings, err := sc.sighting.ListSightingInfo(filter)
 		if err != nil {
 			logger.LogError(err)
 			errRes := ErrorResponse{Error: fmt.Sprintf("Failed to retrieve sightings: %v", err)}
@@ -111,7 +117,20 @@ func (sc *SightingController) SightingHandler(w http.ResponseWriter, r *http.Req
 		userID, _ := r.Context().Value("user_id").(int64)
 		sightingReq.Reporter.ID = userID
 		sightingReq.Image = i
-		createdSighting, err := sc.sighting.CreateSighting(&sightingReq)
+		// Moderators can still record the remains of a deceased animal.
+		principal, _ := r.Context().Value("principal").(model.Principal)
+		moderator := principal.Can(model.PermissionModerateSightings)
+		createdSighting, err := sc.sighting.CreateSighting(&sightingReq, moderator, auditActor(r, userID))
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "Animal not found"}
+			WriteJSONResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrAnimalDeceased) {
+			errRes := ErrorResponse{Error: "The animal is deceased, only moderators can report sightings of it"}
+			WriteJSONResponse(w, errRes, http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.LogError(err)
 			errRes := ErrorResponse{Error: fmt.Sprintf("Failed to create sighting : %v", err)}
@@ -119,11 +138,16 @@ func (sc *SightingController) SightingHandler(w http.ResponseWriter, r *http.Req
 			return
 		}
 		WriteJSONResponse(w, createdSighting, http.StatusCreated)
-		emailIds, err := sc.sighting.SpottedByEmailIds(animalIDInt)
+		recipients, err := sc.sighting.SpottedBy(createdSighting.AnimalID)
 		if err != nil {
 			logger.LogError(err)
 		}
-		go dispatchEmailIntimation(emailIds, sc.producer)
+		event := &model.SightingEvent{
+			AnimalID:   createdSighting.AnimalID,
+			SightingID: createdSighting.ID,
+			Recipients: recipients,
+		}
+		go dispatchSightingEvent(event, sc.producer)
 		return
 	default:
 		errRes := ErrorResponse{Error: "Method not allowed"}
@@ -132,13 +156,17 @@ func (sc *SightingController) SightingHandler(w http.ResponseWriter, r *http.Req
 	}
 }
 
-func dispatchEmailIntimation(emailIds string, producer sarama.SyncProducer) {
+func dispatchSightingEvent(event *model.SightingEvent, producer sarama.SyncProducer) {
 	topic := "email_animal_sighted"
-	message := emailIds
+	message, err := json.Marshal(event)
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
 
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
-		Value: sarama.StringEncoder(message),
+		Value: sarama.ByteEncoder(message),
 	}
 
 	// Send the message
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+// moderateSighting serves PATCH and DELETE /sighting/{id}, which main.go limits to moderators.
+func (sc *SightingController) moderateSighting(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		sc.updateSighting(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		sc.deleteSighting(w, r, segments[0])
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+// updateSighting corrects the location and spotting time of any sighting.
+func (sc *SightingController) updateSighting(w http.ResponseWriter, r *http.Request, id string) {
+	sightingID, ok := parseSightingID(w, id)
+	if !ok {
+		return
+	}
+	var update model.SightingUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateSightingUpdate(&update); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	sighting, err := sc.sighting.UpdateSighting(sightingID, &update, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Sighting not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update sighting"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, sighting, http.StatusOK)
+}
+
+// deleteSighting removes any sighting along with its image.
+func (sc *SightingController) deleteSighting(w http.ResponseWriter, r *http.Request, id string) {
+	sightingID, ok := parseSightingID(w, id)
+	if !ok {
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	err := sc.sighting.DeleteSighting(sightingID, auditActor(r, userID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Sighting not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to delete sighting"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateSightingUpdate returns why the update is invalid, if it is.
+func validateSightingUpdate(update *model.SightingUpdate) string {
+	if update.Location == nil && update.SpottingTimestamp == nil {
+		return "location or spotting_timestamp is mandatory"
+	}
+	if location := update.Location; location != nil &&
+		(location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180) {
+		return "location should have a latitude between -90 and 90 and a longitude between -180 and 180"
+	}
+	if update.SpottingTimestamp != nil {
+		spottedAt, err := time.Parse(time.RFC3339, *update.SpottingTimestamp)
+		if err != nil {
+			return "spotting_timestamp should be an RFC 3339 timestamp"
+		}
+		if spottedAt.After(time.Now()) {
+			return "spotting_timestamp cannot be in the future"
+		}
+	}
+	return ""
+}
+
+// parseSightingID writes the error response when the id is not a number.
+func parseSightingID(w http.ResponseWriter, id string) (int64, bool) {
+	sightingID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Sighting id should be of bigint value"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
+	}
+	return sightingID, true
+}
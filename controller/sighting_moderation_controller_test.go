@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+)
+
+type fakeSightingDB struct {
+	database.ISighting
+	sightings map[int64]*model.SightingReqResp
+}
+
+func (f *fakeSightingDB) UpdateSighting(sightingId int64, update *model.SightingUpdate, actor model.Actor) (*model.SightingReqResp, error) {
+	sighting, ok := f.sightings[sightingId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	if update.Location != nil {
+		sighting.Location = *update.Location
+	}
+	if update.SpottingTimestamp != nil {
+		sighting.SpottingTimestamp = *update.SpottingTimestamp
+	}
+	return sighting, nil
+}
+
+func (f *fakeSightingDB) DeleteSighting(sightingId int64, actor model.Actor) error {
+	if _, ok := f.sightings[sightingId]; !ok {
+		return database.ErrNotFound
+	}
+	delete(f.sightings, sightingId)
+	return nil
+}
+
+func newTestSightingDB() *fakeSightingDB {
+	return &fakeSightingDB{sightings: map[int64]*model.SightingReqResp{
+		1: {AnimalID: 1, Sighting: model.Sighting{ID: 1, Location: model.Point{Latitude: 21.2, Longitude: 79.1},
+			SpottingTimestamp: "2023-11-20T10:00:00Z"}},
+	}}
+}
+
+func TestUpdateSighting(t *testing.T) {
+	sc := NewSightingController(newTestSightingDB(), nil)
+	patch := func(path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		sc.SightingHandler(rr, httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := patch("/sighting/1", `{"location":{"latitude":21.5,"longitude":79.3},"spotting_timestamp":"2023-11-20T09:30:00Z"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"latitude":21.5`, "Location should be corrected")
+	assert.Contains(t, rr.Body.String(), `"spotting_timestamp":"2023-11-20T09:30:00Z"`, "Spotting time should be corrected")
+
+	assert.Equal(t, http.StatusNotFound, patch("/sighting/9", `{"spotting_timestamp":"2023-11-20T09:30:00Z"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, patch("/sighting/x", `{"spotting_timestamp":"2023-11-20T09:30:00Z"}`).Code, "Sighting id should be a number")
+	assert.Equal(t, http.StatusBadRequest, patch("/sighting/1", `{}`).Code, "Empty update should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/sighting/1", `{"location":{"latitude":91,"longitude":79}}`).Code, "Latitude out of range should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/sighting/1", `{"spotting_timestamp":"20-11-2023"}`).Code, "Malformed timestamp should be rejected")
+	assert.Equal(t, http.StatusBadRequest, patch("/sighting/1", `{"spotting_timestamp":"2999-01-01T00:00:00Z"}`).Code, "Future timestamp should be rejected")
+}
+
+func TestDeleteSighting(t *testing.T) {
+	sightingDB := newTestSightingDB()
+	sc := NewSightingController(sightingDB, nil)
+
+	rr := httptest.NewRecorder()
+	sc.SightingHandler(rr, httptest.NewRequest(http.MethodDelete, "/sighting/1", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, sightingDB.sightings, "Sighting should be deleted")
+
+	rr = httptest.NewRecorder()
+	sc.SightingHandler(rr, httptest.NewRequest(http.MethodDelete, "/sighting/1", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted sighting cannot be deleted again")
+
+	rr = httptest.NewRecorder()
+	sc.SightingHandler(rr, httptest.NewRequest(http.MethodPut, "/sighting/1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"unicode/utf8"
+)
+
+// Column sizes of the taxon table.
+const (
+	maxTaxonNameLength          = 30
+	maxScientificNameLength     = 100
+	maxCommonNameLength         = 100
+	maxCommonNameLanguageLength = 35
+)
+
+// languageTag loosely matches BCP 47 tags such as en, hi or pt-BR.
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+type TaxonomyController struct {
+	taxonomy database.ITaxonomy
+}
+
+func NewTaxonomyController(t database.ITaxonomy) *TaxonomyController {
+	return &TaxonomyController{
+		taxonomy: t,
+	}
+}
+
+// AdminTaxonomyHandler serves GET and POST /admin/taxon and GET, PATCH and DELETE /admin/taxon/{id}.
+func (tc *TaxonomyController) AdminTaxonomyHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r, "/admin/taxon")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		tc.listTaxa(w)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		tc.createTaxon(w, r)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		tc.getTaxon(w, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		tc.updateTaxon(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		tc.deleteTaxon(w, r, segments[0])
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+func (tc *TaxonomyController) listTaxa(w http.ResponseWriter) {
+	taxa, err := tc.taxonomy.ListTaxa()
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve taxonomy"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, taxa, http.StatusOK)
+}
+
+func (tc *TaxonomyController) getTaxon(w http.ResponseWriter, id string) {
+	taxonID, ok := parseTaxonID(w, id)
+	if !ok {
+		return
+	}
+	taxon, err := tc.taxonomy.GetTaxon(taxonID)
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Taxon not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to retrieve taxon"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, taxon, http.StatusOK)
+}
+
+// createTaxon adds a species, or a subspecies of the species given as parent_id.
+func (tc *TaxonomyController) createTaxon(w http.ResponseWriter, r *http.Request) {
+	var taxon model.Taxon
+	if err := json.NewDecoder(r.Body).Decode(&taxon); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	update := model.TaxonUpdate{
+		Name:               &taxon.Name,
+		ScientificName:     taxon.ScientificName,
+		ConservationStatus: taxon.ConservationStatus,
+		CommonNames:        taxon.CommonNames,
+	}
+	if message := validateTaxonUpdate(&update); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	created, err := tc.taxonomy.CreateTaxon(&taxon, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrUnknownTaxon) {
+		errRes := ErrorResponse{Error: "parent_id should be the id of a species"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "A taxon with same name or scientific name already exists"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to create taxon"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, created, http.StatusCreated)
+}
+
+func (tc *TaxonomyController) updateTaxon(w http.ResponseWriter, r *http.Request, id string) {
+	taxonID, ok := parseTaxonID(w, id)
+	if !ok {
+		return
+	}
+	var update model.TaxonUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if update.Name == nil && update.ScientificName == nil && update.ConservationStatus == nil && update.CommonNames == nil {
+		errRes := ErrorResponse{Error: "At least one of name, scientific_name, conservation_status and common_names is required"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if message := validateTaxonUpdate(&update); message != "" {
+		errRes := ErrorResponse{Error: message}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	taxon, err := tc.taxonomy.UpdateTaxon(taxonID, &update, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Taxon not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		errRes := ErrorResponse{Error: "A taxon with same name or scientific name already exists"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to update taxon"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, taxon, http.StatusOK)
+}
+
+// deleteTaxon removes a taxon that no animal or subspecies refers to.
+func (tc *TaxonomyController) deleteTaxon(w http.ResponseWriter, r *http.Request, id string) {
+	taxonID, ok := parseTaxonID(w, id)
+	if !ok {
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	err := tc.taxonomy.DeleteTaxon(taxonID, auditActor(r, principal.UserID))
+	if errors.Is(err, database.ErrNotFound) {
+		errRes := ErrorResponse{Error: "Taxon not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrInUse) {
+		errRes := ErrorResponse{Error: "Taxon still has animals or subspecies"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to delete taxon"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateTaxonUpdate trims the fields, upper cases the conservation status and returns why the update is
+// invalid, if it is.
+func validateTaxonUpdate(update *model.TaxonUpdate) string {
+	if update.Name != nil {
+		*update.Name = database.NormalizeTaxonName(*update.Name)
+		if *update.Name == "" || utf8.RuneCountInString(*update.Name) > maxTaxonNameLength {
+			return fmt.Sprintf("name should be 1 to %d characters", maxTaxonNameLength)
+		}
+	}
+	if update.ScientificName != nil {
+		*update.ScientificName = strings.Join(strings.Fields(*update.ScientificName), " ")
+		if utf8.RuneCountInString(*update.ScientificName) > maxScientificNameLength {
+			return fmt.Sprintf("scientific_name should be at most %d characters", maxScientificNameLength)
+		}
+	}
+	if update.ConservationStatus != nil {
+		*update.ConservationStatus = strings.ToUpper(strings.TrimSpace(*update.ConservationStatus))
+		if *update.ConservationStatus != "" && !validConservationStatus(*update.ConservationStatus) {
+			return "conservation_status should be one of " + strings.Join(model.ConservationStatuses, ", ")
+		}
+	}
+	for language, name := range update.CommonNames {
+		if !languageTag.MatchString(language) || len(language) > maxCommonNameLanguageLength {
+			return fmt.Sprintf("common_names should be keyed by language tags such as en or pt-BR, not %q", language)
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || utf8.RuneCountInString(name) > maxCommonNameLength {
+			return fmt.Sprintf("common_names should be 1 to %d characters", maxCommonNameLength)
+		}
+		update.CommonNames[language] = name
+	}
+	return ""
+}
+
+func validConservationStatus(status string) bool {
+	for _, s := range model.ConservationStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTaxonID writes the error response when the id is not a number.
+func parseTaxonID(w http.ResponseWriter, id string) (int64, bool) {
+	taxonID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Taxon id should be of bigint value"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
+	}
+	return taxonID, true
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+)
+
+type fakeTaxonomyDB struct {
+	database.ITaxonomy
+	taxa map[int64]*model.Taxon
+	// inUse holds the taxa animals refer to.
+	inUse map[int64]bool
+}
+
+func (f *fakeTaxonomyDB) GetTaxon(taxonId int64) (*model.Taxon, error) {
+	taxon, ok := f.taxa[taxonId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return taxon, nil
+}
+
+func (f *fakeTaxonomyDB) CreateTaxon(taxon *model.Taxon, actor model.Actor) (*model.Taxon, error) {
+	if taxon.ParentID != nil {
+		parent, ok := f.taxa[*taxon.ParentID]
+		if !ok || parent.ParentID != nil {
+			return nil, database.ErrUnknownTaxon
+		}
+	}
+	for _, other := range f.taxa {
+		if other.Name == taxon.Name && (other.ParentID == nil) == (taxon.ParentID == nil) {
+			return nil, database.ErrConflict
+		}
+	}
+	created := *taxon
+	created.ID = int64(len(f.taxa) + 1)
+	f.taxa[created.ID] = &created
+	return &created, nil
+}
+
+func (f *fakeTaxonomyDB) UpdateTaxon(taxonId int64, update *model.TaxonUpdate, actor model.Actor) (*model.Taxon, error) {
+	taxon, ok := f.taxa[taxonId]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	if update.Name != nil {
+		taxon.Name = *update.Name
+	}
+	if update.ConservationStatus != nil {
+		taxon.ConservationStatus = update.ConservationStatus
+	}
+	if update.CommonNames != nil {
+		taxon.CommonNames = update.CommonNames
+	}
+	return taxon, nil
+}
+
+func (f *fakeTaxonomyDB) DeleteTaxon(taxonId int64, actor model.Actor) error {
+	if _, ok := f.taxa[taxonId]; !ok {
+		return database.ErrNotFound
+	}
+	if f.inUse[taxonId] {
+		return database.ErrInUse
+	}
+	delete(f.taxa, taxonId)
+	return nil
+}
+
+func TestAdminTaxonomy(t *testing.T) {
+	tigerID := int64(1)
+	taxonomyDB := &fakeTaxonomyDB{
+		taxa: map[int64]*model.Taxon{
+			1: {ID: 1, Rank: model.TaxonRankSpecies, Name: "tiger", CommonNames: map[string]string{"en": "Tiger"}},
+			2: {ID: 2, ParentID: &tigerID, Rank: model.TaxonRankSubspecies, Name: "bengal tiger"},
+		},
+		inUse: map[int64]bool{2: true},
+	}
+	tc := NewTaxonomyController(taxonomyDB)
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		tc.AdminTaxonomyHandler(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/admin/taxon",
+		`{"parent_id":1,"name":"  Siberian   Tiger ","scientific_name":"Panthera tigris altaica","conservation_status":"en","common_names":{"en":" Amur tiger ","ru":"Амурский тигр"}}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created model.Taxon
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "siberian tiger", created.Name, "Names should be normalised")
+	assert.Equal(t, "EN", *created.ConservationStatus, "Status should be upper cased")
+	assert.Equal(t, "Amur tiger", created.CommonNames["en"], "Common names should be trimmed")
+
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/admin/taxon", `{"name":"Tiger"}`).Code, "Taken species name should conflict")
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/taxon", `{"parent_id":2,"name":"x"}`).Code,
+		"Subspecies cannot have subspecies")
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/taxon", `{"name":" "}`).Code, "Blank name should be rejected")
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/taxon", `{"name":"lion","conservation_status":"rare"}`).Code,
+		"Unknown status should be rejected")
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/taxon", `{"name":"lion","common_names":{"English":"Lion"}}`).Code,
+		"Common names should be keyed by language tags")
+
+	rr = serve(http.MethodPatch, "/admin/taxon/1", `{"conservation_status":"VU","common_names":{"en":"Tiger","hi":"बाघ"}}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"conservation_status":"VU"`)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPatch, "/admin/taxon/1", `{}`).Code, "Empty update should be rejected")
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPatch, "/admin/taxon/9", `{"name":"lion"}`).Code)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/taxon/2", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/admin/taxon/tiger", "").Code)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodDelete, "/admin/taxon/2", "").Code, "Taxa in use cannot be deleted")
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/admin/taxon/3", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/admin/taxon/3", "").Code, "Deleted taxon should be gone")
+}
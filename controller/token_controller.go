@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// TokenResponse is returned by login and refresh. The refresh token is only ever sent to the client,
+// the database stores its SHA-256 hash.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new access token and a rotated refresh token.
+func (uc *UserController) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req refreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	jti, err := newOpaqueToken(16)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := newOpaqueToken(32)
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	next := &model.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	current, err := uc.token.RotateRefreshToken(hashToken(req.RefreshToken), next)
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrTokenExpired) || errors.Is(err, database.ErrTokenReused) {
+		errRes := ErrorResponse{Error: "Invalid refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	user, err := uc.user.GetUserByID(current.UserID)
+	if err != nil || user.Disabled {
+		logger.LogError(fmt.Errorf("refresh token of missing or disabled user %d", current.UserID))
+		errRes := ErrorResponse{Error: "Invalid refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	// Sessions started before the user's role required two-factor authentication end here.
+	challenge, err := uc.twoFactorChallenge(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to refresh token"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil && challenge.EnrolmentRequired {
+		errRes := ErrorResponse{Error: "Two-factor authentication is required, please log in again"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	token, err := generateJWT(uc.keys, user, jti)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, http.StatusOK)
+}
+
+// LogoutHandler revokes the access token of the request and, when supplied, the family of the refresh token.
+func (uc *UserController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(int64)
+	var req refreshTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errRes := ErrorResponse{Error: "Invalid request payload"}
+			WriteJSONResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+	}
+	if jti, ok := r.Context().Value("token_id").(string); ok && jti != "" {
+		expiresAt, _ := r.Context().Value("token_expires_at").(time.Time)
+		if err := uc.token.RevokeAccessToken(jti, expiresAt); err != nil {
+			errRes := ErrorResponse{Error: "Failed to logout"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.RefreshToken != "" {
+		err := uc.token.RevokeRefreshToken(hashToken(req.RefreshToken), userID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			errRes := ErrorResponse{Error: "Failed to logout"}
+			WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.LogInfo("User with id", userID, "logged out")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueTokens starts a new refresh token family for the user.
+func (uc *UserController) issueTokens(user *model.User) (*TokenResponse, error) {
+	jti, err := newOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+	token, err := generateJWT(uc.keys, user, jti)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	familyID, err := newOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+	err = uc.token.CreateRefreshToken(&model.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func generateJWT(keys *keystore.KeySet, user *model.User, jti string) (string, error) {
+	claims := model.Claims{
+		Principal: model.Principal{
+			UserID:        user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+		},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			ExpiresAt: time.Now().Add(accessTokenTTL).Unix(),
+		},
+	}
+	signedToken, err := keys.Sign(claims)
+	if err != nil {
+		logger.LogError(err)
+		return "", err
+	}
+
+	return signedToken, nil
+}
+
+// newOpaqueToken returns size random bytes encoded as URL safe base64.
+func newOpaqueToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/keystore"
+	"tigerhall-kittens/model"
+)
+
+func TestGenerateJWT(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	user := &model.User{ID: 1, Username: "testuser", Email: "testuser@example.com"}
+	signedToken, err := generateJWT(keys, user, "testjti")
+	assert.NoError(t, err, "Unexpected error while generating JWT")
+
+	claims := &model.Claims{}
+	_, err = jwt.ParseWithClaims(signedToken, claims, keys.Keyfunc)
+	assert.NoError(t, err, "Generated JWT should be valid")
+	assert.Equal(t, "testjti", claims.Id, "jti mismatch")
+	assert.Equal(t, user.ID, claims.UserID, "User ID mismatch")
+}
+
+func TestNewOpaqueToken(t *testing.T) {
+	first, err := newOpaqueToken(32)
+	assert.NoError(t, err, "Unexpected error while generating token")
+	second, err := newOpaqueToken(32)
+	assert.NoError(t, err, "Unexpected error while generating token")
+	assert.NotEqual(t, first, second, "Tokens should be random")
+}
+
+func TestHashToken(t *testing.T) {
+	assert.Equal(t, hashToken("testtoken"), hashToken("testtoken"), "Hash should be deterministic")
+	assert.NotEqual(t, hashToken("testtoken"), hashToken("othertoken"), "Different tokens should not share a hash")
+	assert.Len(t, hashToken("testtoken"), 64, "Hash should be hex encoded SHA-256")
+}
@@ -0,0 +1,430 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/totp"
+	"time"
+)
+
+const (
+	purposeTwoFactorLogin     = "two_factor_login"
+	purposeTwoFactorEnrolment = "two_factor_enrolment"
+
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+var errInvalidSecondFactor = errors.New("invalid one-time code")
+
+// TwoFactorChallenge is returned instead of tokens when the password was correct but a second factor is needed.
+// When EnrolmentRequired is set the user's role requires two-factor authentication and the user has to enrol first.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	EnrolmentRequired bool   `json:"enrolment_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// TOTPEnrolment holds the secret to add to an authenticator app, either typed in or scanned from the URI.
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type twoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type twoFactorLoginResponse struct {
+	*TokenResponse
+	// RecoveryCodes is only set when the login completed an enrolment.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// TwoFactorLoginHandler completes a login with the challenge token of the first step and a one-time code
+// or recovery code. For enrolment challenges the code confirms the enrolment started through
+// TwoFactorEnrolmentHandler and the response carries the new recovery codes.
+func (uc *UserController) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	enrolment := false
+	userID, err := uc.parseChallengeToken(req.ChallengeToken, purposeTwoFactorLogin)
+	if err != nil {
+		userID, err = uc.parseChallengeToken(req.ChallengeToken, purposeTwoFactorEnrolment)
+		enrolment = true
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid or expired challenge token"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	user, err := uc.user.GetUserByID(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid or expired challenge token"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	if user.Disabled {
+		errRes := ErrorResponse{Error: "Account has been disabled"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	loginKeys := []model.LoginKey{
+		{Kind: database.LoginKeyUsername, Key: strings.ToLower(user.Username)},
+		{Kind: database.LoginKeyIP, Key: ClientIP(r)},
+	}
+	lockedUntil, err := uc.loginAttempt.LockedUntil(loginKeys)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLockedOut(w, lockedUntil)
+		return
+	}
+	var recoveryCodes []string
+	if enrolment {
+		recoveryCodes, err = uc.confirmTOTP(userID, req.Code)
+	} else {
+		err = uc.verifySecondFactor(userID, req.Code, req.RecoveryCode)
+	}
+	if errors.Is(err, errInvalidSecondFactor) {
+		for _, key := range loginKeys {
+			if _, err := uc.loginAttempt.RecordLoginFailure(key); err != nil {
+				logger.LogError(err)
+			}
+		}
+		errRes := ErrorResponse{Error: "Invalid one-time code"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if err = uc.loginAttempt.ClearLoginFailures(loginKeys[0]); err != nil && !errors.Is(err, database.ErrNotFound) {
+		logger.LogError(err)
+	}
+	tokens, err := uc.issueTokens(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, twoFactorLoginResponse{TokenResponse: tokens, RecoveryCodes: recoveryCodes}, http.StatusOK)
+	logger.LogInfo(user.Username, " successfully logged in with a second factor")
+}
+
+// TwoFactorEnrolmentHandler starts the enrolment of a user who has to enrol before the login can complete.
+func (uc *UserController) TwoFactorEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+		return
+	}
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userID, err := uc.parseChallengeToken(req.ChallengeToken, purposeTwoFactorEnrolment)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Invalid or expired challenge token"}
+		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+		return
+	}
+	uc.startTOTPEnrolment(w, userID)
+}
+
+// TwoFactorHandler serves /user/2fa/... for the logged in user:
+// POST totp starts an enrolment, POST totp/confirm enables it, DELETE totp disables it
+// and POST recovery-codes replaces the recovery codes.
+func (uc *UserController) TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(int64)
+	segments := pathSegments(r, "/user/2fa")
+	switch {
+	case len(segments) == 1 && segments[0] == "totp" && r.Method == http.MethodPost:
+		uc.startTOTPEnrolment(w, userID)
+	case len(segments) == 2 && segments[0] == "totp" && segments[1] == "confirm" && r.Method == http.MethodPost:
+		uc.confirmTOTPHandler(w, r, userID)
+	case len(segments) == 1 && segments[0] == "totp" && r.Method == http.MethodDelete:
+		uc.disableTOTP(w, r, userID)
+	case len(segments) == 1 && segments[0] == "recovery-codes" && r.Method == http.MethodPost:
+		uc.regenerateRecoveryCodes(w, r, userID)
+	case len(segments) == 0 || (segments[0] != "totp" && segments[0] != "recovery-codes"):
+		errRes := ErrorResponse{Error: "Not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+	default:
+		errRes := ErrorResponse{Error: "Method not allowed"}
+		WriteJSONResponse(w, errRes, http.StatusMethodNotAllowed)
+	}
+}
+
+func (uc *UserController) startTOTPEnrolment(w http.ResponseWriter, userID int64) {
+	user, err := uc.user.GetUserByID(userID)
+	if err != nil {
+		errRes := ErrorResponse{Error: "User not found"}
+		WriteJSONResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.LogError(err)
+		errRes := ErrorResponse{Error: "Failed to start enrolment"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	err = uc.twoFactor.StartTOTPEnrolment(userID, secret)
+	if errors.Is(err, database.ErrTwoFactorEnabled) {
+		errRes := ErrorResponse{Error: "Two-factor authentication is already enabled"}
+		WriteJSONResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to start enrolment"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, TOTPEnrolment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer(), user.Username, secret),
+	}, http.StatusCreated)
+}
+
+func (uc *UserController) confirmTOTPHandler(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	recoveryCodes, err := uc.confirmTOTP(userID, req.Code)
+	if errors.Is(err, errInvalidSecondFactor) {
+		errRes := ErrorResponse{Error: "Invalid one-time code"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to enable two-factor authentication"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, map[string][]string{"recovery_codes": recoveryCodes}, http.StatusOK)
+}
+
+func (uc *UserController) disableTOTP(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	principal, _ := r.Context().Value("principal").(model.Principal)
+	required, err := uc.twoFactor.IsTwoFactorRequired(principal.Role)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to disable two-factor authentication"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if required {
+		errRes := ErrorResponse{Error: "Two-factor authentication is required for your role"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	err = uc.verifySecondFactor(userID, req.Code, req.RecoveryCode)
+	if errors.Is(err, errInvalidSecondFactor) {
+		errRes := ErrorResponse{Error: "Invalid one-time code"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if err == nil {
+		err = uc.twoFactor.DisableTOTP(userID)
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to disable two-factor authentication"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (uc *UserController) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		errRes := ErrorResponse{Error: "Invalid request payload"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	err := uc.verifySecondFactor(userID, req.Code, "")
+	if errors.Is(err, errInvalidSecondFactor) {
+		errRes := ErrorResponse{Error: "Invalid one-time code"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	var codes, hashes []string
+	if err == nil {
+		codes, hashes, err = newRecoveryCodes()
+	}
+	if err == nil {
+		err = uc.twoFactor.ReplaceRecoveryCodes(userID, hashes)
+	}
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate recovery codes"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	WriteJSONResponse(w, map[string][]string{"recovery_codes": codes}, http.StatusOK)
+}
+
+// twoFactorChallenge returns the challenge the user has to answer before tokens are issued, or nil when the
+// user has not enabled two-factor authentication and their role does not require it.
+func (uc *UserController) twoFactorChallenge(user *model.User) (*TwoFactorChallenge, error) {
+	purpose := ""
+	enrolment, err := uc.twoFactor.GetTOTP(user.ID)
+	switch {
+	case err == nil && enrolment.Confirmed:
+		purpose = purposeTwoFactorLogin
+	case err != nil && !errors.Is(err, database.ErrNotFound):
+		return nil, err
+	default:
+		required, err := uc.twoFactor.IsTwoFactorRequired(user.Role)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			purpose = purposeTwoFactorEnrolment
+		}
+	}
+	if purpose == "" {
+		return nil, nil
+	}
+	token, err := uc.keys.Sign(&accountClaims{jwt.StandardClaims{
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Audience:  purpose,
+		ExpiresAt: time.Now().Add(twoFactorChallengeTTL).Unix(),
+	}})
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	return &TwoFactorChallenge{
+		TwoFactorRequired: true,
+		EnrolmentRequired: purpose == purposeTwoFactorEnrolment,
+		ChallengeToken:    token,
+		ExpiresIn:         int64(twoFactorChallengeTTL.Seconds()),
+	}, nil
+}
+
+func (uc *UserController) parseChallengeToken(token string, purpose string) (int64, error) {
+	claims, err := uc.parseAccountToken(token, purpose)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(claims.Subject, 10, 64)
+}
+
+// verifySecondFactor accepts either a current one-time code or an unused recovery code.
+func (uc *UserController) verifySecondFactor(userID int64, code string, recoveryCode string) error {
+	if code == "" {
+		err := uc.twoFactor.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if errors.Is(err, database.ErrNotFound) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+	enrolment, err := uc.twoFactor.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !enrolment.Confirmed) {
+		return errInvalidSecondFactor
+	}
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(enrolment.Secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	err = uc.twoFactor.UseTOTPStep(userID, step)
+	if errors.Is(err, database.ErrCodeReused) {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+// confirmTOTP enables a pending enrolment with its first code and returns the user's recovery codes.
+func (uc *UserController) confirmTOTP(userID int64, code string) ([]string, error) {
+	enrolment, err := uc.twoFactor.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && enrolment.Confirmed) {
+		return nil, errInvalidSecondFactor
+	}
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(enrolment.Secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidSecondFactor
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = uc.twoFactor.ConfirmTOTP(userID, step, hashes)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, errInvalidSecondFactor
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCodes returns recovery codes formatted for the user, e.g. "k3xq7-pm2vd", along with the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Tigerhall Kittens"
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/totp"
+	"time"
+)
+
+type fakeUserDB struct {
+	database.IUser
+	users map[int64]*model.User
+}
+
+func (f *fakeUserDB) GetUserByID(userId int64) (*model.User, error) {
+	if user, ok := f.users[userId]; ok {
+		return user, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+type fakeTwoFactorDB struct {
+	enrolments    map[int64]*model.TOTP
+	recoveryCodes map[int64]map[string]bool
+	requiredRoles map[model.Role]bool
+}
+
+func newFakeTwoFactorDB() *fakeTwoFactorDB {
+	return &fakeTwoFactorDB{
+		enrolments:    make(map[int64]*model.TOTP),
+		recoveryCodes: make(map[int64]map[string]bool),
+		requiredRoles: make(map[model.Role]bool),
+	}
+}
+
+func (f *fakeTwoFactorDB) StartTOTPEnrolment(userId int64, secret string) error {
+	if enrolment, ok := f.enrolments[userId]; ok && enrolment.Confirmed {
+		return database.ErrTwoFactorEnabled
+	}
+	f.enrolments[userId] = &model.TOTP{UserID: userId, Secret: secret}
+	return nil
+}
+
+func (f *fakeTwoFactorDB) GetTOTP(userId int64) (*model.TOTP, error) {
+	if enrolment, ok := f.enrolments[userId]; ok {
+		return enrolment, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+func (f *fakeTwoFactorDB) ConfirmTOTP(userId int64, step int64, recoveryCodeHashes []string) error {
+	enrolment, ok := f.enrolments[userId]
+	if !ok || enrolment.Confirmed || enrolment.LastUsedStep >= step {
+		return database.ErrNotFound
+	}
+	enrolment.Confirmed = true
+	enrolment.LastUsedStep = step
+	return f.ReplaceRecoveryCodes(userId, recoveryCodeHashes)
+}
+
+func (f *fakeTwoFactorDB) UseTOTPStep(userId int64, step int64) error {
+	enrolment := f.enrolments[userId]
+	if enrolment.LastUsedStep >= step {
+		return database.ErrCodeReused
+	}
+	enrolment.LastUsedStep = step
+	return nil
+}
+
+func (f *fakeTwoFactorDB) DisableTOTP(userId int64) error {
+	delete(f.enrolments, userId)
+	delete(f.recoveryCodes, userId)
+	return nil
+}
+
+func (f *fakeTwoFactorDB) UseRecoveryCode(userId int64, codeHash string) error {
+	if unused, ok := f.recoveryCodes[userId][codeHash]; !ok || !unused {
+		return database.ErrNotFound
+	}
+	f.recoveryCodes[userId][codeHash] = false
+	return nil
+}
+
+func (f *fakeTwoFactorDB) ReplaceRecoveryCodes(userId int64, codeHashes []string) error {
+	f.recoveryCodes[userId] = make(map[string]bool)
+	for _, codeHash := range codeHashes {
+		f.recoveryCodes[userId][codeHash] = true
+	}
+	return nil
+}
+
+func (f *fakeTwoFactorDB) IsTwoFactorRequired(role model.Role) (bool, error) {
+	return f.requiredRoles[role], nil
+}
+
+func (f *fakeTwoFactorDB) TwoFactorRequiredRoles() ([]model.Role, error) {
+	roles := make([]model.Role, 0)
+	for role := range f.requiredRoles {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (f *fakeTwoFactorDB) SetTwoFactorRequired(role model.Role, required bool, updatedBy int64) error {
+	if required {
+		f.requiredRoles[role] = true
+	} else {
+		delete(f.requiredRoles, role)
+	}
+	return nil
+}
+
+func newTwoFactorTestController(t *testing.T, users ...*model.User) (*UserController, *fakeTwoFactorDB) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	userDB := &fakeUserDB{users: make(map[int64]*model.User)}
+	for _, user := range users {
+		userDB.users[user.ID] = user
+	}
+	twoFactor := newFakeTwoFactorDB()
+	return NewUserController(userDB, &fakeTokenDB{}, &fakeLoginAttemptDB{}, twoFactor, keys, nil), twoFactor
+}
+
+func postJSON(handler http.HandlerFunc, path string, body interface{}, userID int64) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(payload)))
+	if userID != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestTwoFactorOptionalEnrolment(t *testing.T) {
+	user := &model.User{ID: 1, Username: "moderator", Role: model.RoleModerator}
+	uc, _ := newTwoFactorTestController(t, user)
+
+	challenge, err := uc.twoFactorChallenge(user)
+	assert.NoError(t, err, "Unexpected error while checking for a challenge")
+	assert.Nil(t, challenge, "Users without two-factor authentication should not be challenged")
+
+	rr := postJSON(uc.TwoFactorHandler, "/user/2fa/totp", nil, user.ID)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Enrolment should start")
+	var enrolment TOTPEnrolment
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&enrolment), "Failed to decode enrolment")
+	assert.True(t, strings.HasPrefix(enrolment.URI, "otpauth://totp/"), "otpauth URI expected")
+
+	rr = postJSON(uc.TwoFactorHandler, "/user/2fa/totp/confirm", twoFactorRequest{Code: "000000"}, user.ID)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Wrong code should not confirm the enrolment")
+
+	code, _ := totp.Code(enrolment.Secret, totp.Step(time.Now()))
+	rr = postJSON(uc.TwoFactorHandler, "/user/2fa/totp/confirm", twoFactorRequest{Code: code}, user.ID)
+	assert.Equal(t, http.StatusOK, rr.Code, "Enrolment should be confirmed")
+	var confirmed map[string][]string
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&confirmed), "Failed to decode recovery codes")
+	assert.Len(t, confirmed["recovery_codes"], recoveryCodeCount, "Recovery codes expected")
+
+	challenge, err = uc.twoFactorChallenge(user)
+	assert.NoError(t, err, "Unexpected error while checking for a challenge")
+	assert.False(t, challenge.EnrolmentRequired, "Enrolled users should get a login challenge")
+
+	rr = postJSON(uc.TwoFactorLoginHandler, "/user/login/2fa", twoFactorRequest{ChallengeToken: challenge.ChallengeToken, Code: code}, 0)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "A used code should not be accepted again")
+
+	recoveryCode := strings.ToUpper(confirmed["recovery_codes"][0])
+	rr = postJSON(uc.TwoFactorLoginHandler, "/user/login/2fa", twoFactorRequest{ChallengeToken: challenge.ChallengeToken, RecoveryCode: recoveryCode}, 0)
+	assert.Equal(t, http.StatusOK, rr.Code, "Recovery code should complete the login")
+	var tokens TokenResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode tokens")
+	assert.NotEmpty(t, tokens.Token, "Access token expected")
+
+	rr = postJSON(uc.TwoFactorLoginHandler, "/user/login/2fa", twoFactorRequest{ChallengeToken: challenge.ChallengeToken, RecoveryCode: recoveryCode}, 0)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Recovery codes should only be usable once")
+}
+
+func TestTwoFactorRequiredForRole(t *testing.T) {
+	user := &model.User{ID: 2, Username: "admin", Role: model.RoleAdmin}
+	uc, twoFactor := newTwoFactorTestController(t, user)
+	twoFactor.requiredRoles[model.RoleAdmin] = true
+
+	challenge, err := uc.twoFactorChallenge(user)
+	assert.NoError(t, err, "Unexpected error while checking for a challenge")
+	assert.True(t, challenge.EnrolmentRequired, "Users of a required role should have to enrol")
+
+	rr := postJSON(uc.TwoFactorLoginHandler, "/user/login/2fa", twoFactorRequest{ChallengeToken: challenge.ChallengeToken, Code: "123456"}, 0)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Login should not complete before enrolling")
+
+	rr = postJSON(uc.TwoFactorEnrolmentHandler, "/user/login/2fa/enrol", twoFactorRequest{ChallengeToken: challenge.ChallengeToken}, 0)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Enrolment should start with the challenge token")
+	var enrolment TOTPEnrolment
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&enrolment), "Failed to decode enrolment")
+
+	code, _ := totp.Code(enrolment.Secret, totp.Step(time.Now()))
+	rr = postJSON(uc.TwoFactorLoginHandler, "/user/login/2fa", twoFactorRequest{ChallengeToken: challenge.ChallengeToken, Code: code}, 0)
+	assert.Equal(t, http.StatusOK, rr.Code, "Confirming the enrolment should complete the login")
+	var response struct {
+		Token         string   `json:"token"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode response")
+	assert.NotEmpty(t, response.Token, "Access token expected")
+	assert.Len(t, response.RecoveryCodes, recoveryCodeCount, "Recovery codes expected")
+
+	req := httptest.NewRequest(http.MethodDelete, "/user/2fa/totp", strings.NewReader(`{"recovery_code":"`+response.RecoveryCodes[0]+`"}`))
+	req = req.WithContext(context.WithValue(context.WithValue(req.Context(), "user_id", user.ID), "principal", model.Principal{UserID: user.ID, Role: user.Role}))
+	rr = httptest.NewRecorder()
+	uc.TwoFactorHandler(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Required two-factor authentication should not be disabled")
+}
@@ -4,23 +4,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/IBM/sarama"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/model"
+	"tigerhall-kittens/password"
 	"time"
 )
 
 type UserController struct {
-	user database.IUser
+	user         database.IUser
+	token        database.IToken
+	loginAttempt database.ILoginAttempt
+	twoFactor    database.ITwoFactor
+	keys         *keystore.KeySet
+	producer     sarama.SyncProducer
 }
 
-func NewUserController(repo database.IUser) *UserController {
+func NewUserController(repo database.IUser, token database.IToken, loginAttempt database.ILoginAttempt, twoFactor database.ITwoFactor, keys *keystore.KeySet, p sarama.SyncProducer) *UserController {
 	return &UserController{
-		user: repo,
+		user:         repo,
+		token:        token,
+		loginAttempt: loginAttempt,
+		twoFactor:    twoFactor,
+		keys:         keys,
+		producer:     p,
 	}
 }
 
@@ -43,13 +57,28 @@ func (uc *UserController) CreateUserHandler(w http.ResponseWriter, r *http.Reque
 		WriteJSONResponse(w, errRes, http.StatusBadRequest)
 		return
 	}
-	userResponse, err := uc.user.CreateUser(&user)
+	if !validEmail(user.Email) {
+		errRes := ErrorResponse{Error: "Invalid email address"}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	userResponse, err := uc.user.CreateUser(&user, auditActor(r, 0))
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		errRes := ErrorResponse{Error: policyErr.Reason}
+		WriteJSONResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		logger.LogError(err)
 		errRes := ErrorResponse{Error: fmt.Sprintf("Failed to create user")}
 		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
 		return
 	}
+	userResponse.Email = user.Email
+	if err = uc.sendEmailVerification(userResponse); err != nil {
+		logger.LogError(err)
+	}
 	response := map[string]interface{}{
 		"username": userResponse.Username,
 		"userId":   userResponse.ID,
@@ -69,60 +98,103 @@ func (uc *UserController) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.LogInfo("Username : ", loginReq.Username, " tried to login")
-	user, err := authenticateUser(loginReq.Username, loginReq.Password, uc)
+	loginKeys := []model.LoginKey{
+		{Kind: database.LoginKeyUsername, Key: strings.ToLower(loginReq.Username)},
+		{Kind: database.LoginKeyIP, Key: ClientIP(r)},
+	}
+	lockedUntil, err := uc.loginAttempt.LockedUntil(loginKeys)
 	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to login"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLockedOut(w, lockedUntil)
+		return
+	}
+	user, err := authenticateUser(r, loginReq.Username, loginReq.Password, uc)
+	if err != nil {
+		for _, key := range loginKeys {
+			if _, err := uc.loginAttempt.RecordLoginFailure(key); err != nil {
+				logger.LogError(err)
+			}
+		}
 		errRes := ErrorResponse{Error: "Invalid credentials"}
 		WriteJSONResponse(w, errRes, http.StatusUnauthorized)
 		return
 	}
-	token, err := generateJWT(user)
+	if user.Disabled {
+		errRes := ErrorResponse{Error: "Account has been disabled"}
+		WriteJSONResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	challenge, err := uc.twoFactorChallenge(user)
 	if err != nil {
-		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		errRes := ErrorResponse{Error: "Failed to login"}
 		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"token": token,
+	if challenge != nil {
+		// Failures are only cleared once the second factor succeeds, otherwise the password alone would
+		// reset the lockout that protects the one-time codes.
+		WriteJSONResponse(w, challenge, http.StatusAccepted)
+		logger.LogInfo(loginReq.Username, " passed the first login step")
+		return
+	}
+	if err = uc.loginAttempt.ClearLoginFailures(loginKeys[0]); err != nil && !errors.Is(err, database.ErrNotFound) {
+		logger.LogError(err)
+	}
+	tokens, err := uc.issueTokens(user)
+	if err != nil {
+		errRes := ErrorResponse{Error: "Failed to generate JWT"}
+		WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+		return
 	}
-	json.NewEncoder(w).Encode(response)
+	WriteJSONResponse(w, tokens, http.StatusOK)
 	logger.LogInfo(loginReq.Username, " successfully logged in")
 }
 
-func authenticateUser(username, password string, uc *UserController) (*model.User, error) {
+// authenticateUser takes the same time whether or not the user exists, unknown users and users without
+// a password are compared against a dummy hash so that response times do not reveal valid usernames.
+// Passwords hashed with outdated parameters are rehashed on success.
+func authenticateUser(r *http.Request, username, password string, uc *UserController) (*model.User, error) {
 	user, err := uc.user.GetUserByUsername(username)
-	if err != nil {
+	if err != nil || user.Username == "" || user.Password == "" {
+		uc.user.VerifyPassword(&model.User{}, password, auditActor(r, 0))
 		return nil, fmt.Errorf("user not found")
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
+	if !uc.user.VerifyPassword(user, password, auditActor(r, user.ID)) {
 		return nil, fmt.Errorf("invalid password")
 	}
 	return user, nil
 }
 
-func generateJWT(user *model.User) (string, error) {
-	claims := model.Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 1).Unix(),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		logger.LogError(errors.New("JWT_SECRET missing from secret/env file"))
-		return "", errors.New("JWT_SECRET missing from secret/env file")
-	}
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+func writeLockedOut(w http.ResponseWriter, lockedUntil time.Time) {
+	retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	errRes := ErrorResponse{Error: "Too many failed login attempts, please try again later"}
+	WriteJSONResponse(w, errRes, http.StatusTooManyRequests)
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is only trusted when TRUST_PROXY_HEADERS
+// is enabled, i.e. when the service runs behind a reverse proxy that sets it.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		logger.LogError(err)
-		return "", err
+		return r.RemoteAddr
 	}
+	return host
+}
 
-	return signedToken, nil
+// auditActor attributes the changes made while handling the request to the user in the audit log.
+func auditActor(r *http.Request, userID int64) model.Actor {
+	requestID, _ := r.Context().Value("request_id").(string)
+	return model.Actor{UserID: userID, RequestID: requestID}
 }
 
 func WriteJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
@@ -133,3 +205,15 @@ func WriteJSONResponse(w http.ResponseWriter, data interface{}, statusCode int)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// pathSegments returns the non-empty path segments that follow prefix,
+// e.g. "/notification/12/read" with prefix "/notification/" yields ["12", "read"].
+func pathSegments(r *http.Request, prefix string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+type fakeLoginAttemptDB struct {
+	database.ILoginAttempt
+	lockedUntil time.Time
+}
+
+func (f *fakeLoginAttemptDB) LockedUntil(keys []model.LoginKey) (time.Time, error) {
+	return f.lockedUntil, nil
+}
+
+func TestLoginHandler_LockedOut(t *testing.T) {
+	loginAttempt := &fakeLoginAttemptDB{lockedUntil: time.Now().Add(time.Minute)}
+	uc := NewUserController(nil, nil, loginAttempt, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/user/login", strings.NewReader(`{"username":"ranger","password":"secret"}`))
+	rr := httptest.NewRecorder()
+	uc.LoginHandler(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Locked out login should be rejected")
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"), "Retry-After header should be set")
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+	req.RemoteAddr = "10.0.0.1:52000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	t.Setenv("TRUST_PROXY_HEADERS", "false")
+	assert.Equal(t, "10.0.0.1", ClientIP(req), "X-Forwarded-For should be ignored by default")
+
+	t.Setenv("TRUST_PROXY_HEADERS", "true")
+	assert.Equal(t, "203.0.113.7", ClientIP(req), "X-Forwarded-For should be used behind a proxy")
+}
+
+func (f *fakeLoginAttemptDB) RecordLoginFailure(key model.LoginKey) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (f *fakeLoginAttemptDB) ClearLoginFailures(key model.LoginKey) error {
+	return nil
+}
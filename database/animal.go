@@ -7,13 +7,52 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"strconv"
+	"strings"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/model"
 )
 
 type IAnimal interface {
-	CreateAnimal(animal *model.Animal, sighting *model.Sighting) (*model.AnimalReqResp, error)
-	ListAnimalInfo(name string, animalType string, limit string, offset string) ([]model.AnimalReqResp, error)
+	CreateAnimal(animal *model.Animal, sighting *model.Sighting, actor model.Actor) (*model.AnimalReqResp, error)
+	ListAnimalInfo(filter model.AnimalFilter) (*model.Page[model.AnimalReqResp], error)
+	SearchAnimals(search model.AnimalSearch) (*model.Page[model.AnimalSearchResult], error)
+	GetAnimalDetail(animalId int64) (*model.AnimalDetail, error)
+	GetFamilyTree(animalId int64, generations int) (*model.FamilyTree, error)
+	UpdateAnimal(animalId int64, update *model.AnimalUpdate, actor model.Actor) (*model.Animal, error)
+	DeleteAnimal(animalId int64, actor model.Actor) error
+	RestoreAnimal(animalId int64, actor model.Actor) (*model.Animal, error)
+	SetParents(animalId int64, parents model.AnimalParents, actor model.Actor) (*model.Animal, error)
+	CreateLitter(motherId int64, litter *model.LitterRequest, actor model.Actor) (*model.Litter, error)
+	SetAnimalStatus(animalId int64, update *model.AnimalStatusUpdate, actor model.Actor) (*model.AnimalStatusChange, error)
+	ListStatusHistory(animalId int64) ([]model.AnimalStatusChange, error)
+	MergeAnimals(animalId int64, duplicateId int64, actor model.Actor) (*model.AnimalMerge, error)
+	MergedInto(animalId int64) (int64, error)
+	SetIdentifiers(animalId int64, identifiers []model.AnimalIdentifier, actor model.Actor) (*model.Animal, error)
+	LookupAnimal(scheme model.IdentifierScheme, value string) (*model.Animal, error)
+}
+
+// animalColumns are selected from an animal a joined to its taxa by animalTaxonJoins.
+const animalColumns = `a.id, a.name, sp.name, COALESCE(ss.name, ''), TO_CHAR(a.date_of_birth, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+	COALESCE(a.description, ''), a.species_id, a.subspecies_id, a.mother_id, a.father_id, a.litter_id,
+	a.status, ` + animalIdentifiers
+
+// animalIdentifiers selects the identifiers of an animal a as a JSON array, ordered by scheme and value.
+const animalIdentifiers = `COALESCE((
+		SELECT jsonb_agg(jsonb_build_object('scheme', ai.scheme, 'value', ai.value) ORDER BY ai.scheme, ai.value)
+		FROM animal_identifier ai
+		WHERE ai.animal_id = a.id
+	), '[]')`
+
+// animalTaxonJoins joins the species sp and subspecies ss of an animal a, which are its type and variant.
+const animalTaxonJoins = `
+		JOIN taxon sp ON sp.id = a.species_id
+		LEFT JOIN taxon ss ON ss.id = a.subspecies_id
+	`
+
+func scanAnimal(row pgx.Row, animal *model.Animal) error {
+	return row.Scan(&animal.ID, &animal.Name, &animal.Type, &animal.Variant, &animal.DateOfBirth, &animal.Description,
+		&animal.SpeciesID, &animal.SubspeciesID, &animal.MotherID, &animal.FatherID, &animal.LitterID,
+		&animal.Status, &animal.Identifiers)
 }
 
 type AnimalDB struct {
@@ -26,14 +65,20 @@ func NewAnimalDB(pool *pgxpool.Pool) *AnimalDB {
 	}
 }
 
-func (db *AnimalDB) CreateAnimal(animal *model.Animal, sighting *model.Sighting) (*model.AnimalReqResp, error) {
+func (db *AnimalDB) CreateAnimal(animal *model.Animal, sighting *model.Sighting, actor model.Actor) (*model.AnimalReqResp, error) {
 	ctx := context.Background()
-	tx, err := db.pool.Begin(ctx)
+	tx, err := beginAudited(ctx, db.pool, actor)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to begin transaction")
+		return nil, err
 	}
 
-	if err = createAnimalWithTransaction(ctx, tx, animal); err != nil {
+	if animal.MotherID != nil || animal.FatherID != nil {
+		err = checkParents(ctx, tx, 0, animal.DateOfBirth, animal.MotherID, animal.FatherID)
+	}
+	if err == nil {
+		err = createAnimalWithTransaction(ctx, tx, animal)
+	}
+	if err != nil {
 		rollbackErr := tx.Rollback(ctx)
 		if rollbackErr != nil {
 			logger.LogError(errors.New("Error rolling back database transaction"))
@@ -58,18 +103,35 @@ func (db *AnimalDB) CreateAnimal(animal *model.Animal, sighting *model.Sighting)
 	}, nil
 }
 
+// createAnimalWithTransaction fails with ErrUnknownTaxon when the type and variant are not in the taxonomy, they
+// are set to the names stored there. Taking the name of another animal of the same type and variant fails with
+// ErrConflict. Parents are inserted as they are, see checkParents, and identifiers given to another animal fail with
+// an IdentifierTakenError.
 func createAnimalWithTransaction(ctx context.Context, tx pgx.Tx, animal *model.Animal) error {
+	speciesID, subspeciesID, err := resolveTaxa(ctx, tx, animal.Type, animal.Variant)
+	if err != nil {
+		return err
+	}
 	var id int64
-	err := tx.QueryRow(ctx,
-		`INSERT INTO animal (name, type, variant, date_of_birth, description)
-         VALUES($1, $2, $3, TO_DATE($4, 'YYYY-MM-DD'), $5) RETURNING id`,
-		animal.Name, animal.Type, animal.Variant, animal.DateOfBirth, animal.Description).Scan(&id)
+	err = tx.QueryRow(ctx,
+		`INSERT INTO animal (name, species_id, subspecies_id, date_of_birth, description, mother_id, father_id, litter_id)
+         VALUES($1, $2, $3, TO_DATE($4, 'YYYY-MM-DD'), $5, $6, $7, $8) RETURNING id`,
+		animal.Name, speciesID, subspeciesID, animal.DateOfBirth, animal.Description,
+		animal.MotherID, animal.FatherID, animal.LitterID).Scan(&id)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
 	if err != nil {
 		logger.LogError(err)
 		return errors.New("An animal with same name, type or variant already exists")
 	}
 	animal.ID = id
-	return nil
+	animal.Type = NormalizeTaxonName(animal.Type)
+	animal.Variant = NormalizeTaxonName(animal.Variant)
+	animal.SpeciesID = speciesID
+	animal.SubspeciesID = subspeciesID
+	animal.Status = model.AnimalStatusActive
+	return insertIdentifiers(ctx, tx, animal.ID, animal.Identifiers)
 }
 
 func CreateSightingWithTransaction(ctx context.Context, tx pgx.Tx, animalId int64, sighting *model.Sighting) error {
@@ -97,42 +159,221 @@ func CreateSightingWithTransaction(ctx context.Context, tx pgx.Tx, animalId int6
 	return nil
 }
 
-func (db *AnimalDB) ListAnimalInfo(name string, animalType string, limit string, offset string) ([]model.AnimalReqResp, error) {
-	sqlQuery := `
-		SELECT a.id AS animal_id, a.name, a.type, a.variant, TO_CHAR(a.date_of_birth, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS date_of_birth, a.description,
-       	s.location[0] AS longitude, s.location[1] AS latitude, TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS spotting_timestamp
-		FROM animal a
-		LEFT JOIN sighting s ON a.id = s.animal_id
-		WHERE s.spotting_timestamp = (
-  			SELECT MAX(spotting_timestamp)
-  			FROM sighting
-  			WHERE animal_id = a.id
+// GetAnimalDetail returns the animal with the first and last place it was seen, how often and by how many
+// reporters, and its latest image.
+func (db *AnimalDB) GetAnimalDetail(animalId int64) (*model.AnimalDetail, error) {
+	var detail model.AnimalDetail
+	var firstTimestamp, lastTimestamp, imageFileName, imageType *string
+	var firstLongitude, firstLatitude, lastLongitude, lastLatitude *float64
+	var imageId *int64
+	err := db.pool.QueryRow(context.Background(), `
+		SELECT `+animalColumns+`,
+		(SELECT COUNT(*) FROM sighting WHERE animal_id = a.id),
+		(SELECT COUNT(DISTINCT reporter) FROM sighting WHERE animal_id = a.id),
+		TO_CHAR(f.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), f.location[0], f.location[1],
+		TO_CHAR(l.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), l.location[0], l.location[1],
+		i.id, i.filename, i.type
+		FROM animal a`+animalTaxonJoins+`
+		LEFT JOIN LATERAL (
+			SELECT spotting_timestamp, location FROM sighting
+			WHERE animal_id = a.id ORDER BY spotting_timestamp, id LIMIT 1
+		) f ON true
+		LEFT JOIN LATERAL (
+			SELECT spotting_timestamp, location FROM sighting
+			WHERE animal_id = a.id ORDER BY spotting_timestamp DESC, id DESC LIMIT 1
+		) l ON true
+		LEFT JOIN LATERAL (
+			SELECT im.id, im.filename, im.type FROM sighting s
+			JOIN image im ON s.image_id = im.id
+			WHERE s.animal_id = a.id ORDER BY s.spotting_timestamp DESC, s.id DESC LIMIT 1
+		) i ON true
+		WHERE a.id = $1 AND a.deleted_at IS NULL`,
+		animalId).Scan(&detail.ID, &detail.Name, &detail.Type, &detail.Variant, &detail.DateOfBirth, &detail.Description,
+		&detail.SpeciesID, &detail.SubspeciesID, &detail.MotherID, &detail.FatherID, &detail.LitterID, &detail.Status, &detail.Identifiers, &detail.TotalSightings, &detail.DistinctReporters,
+		&firstTimestamp, &firstLongitude, &firstLatitude,
+		&lastTimestamp, &lastLongitude, &lastLatitude,
+		&imageId, &imageFileName, &imageType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if firstTimestamp != nil {
+		detail.FirstSeen = &model.SeenAt{
+			SpottingTimestamp: *firstTimestamp,
+			Location:          model.Point{Longitude: *firstLongitude, Latitude: *firstLatitude},
+		}
+		detail.LastSeen = &model.SeenAt{
+			SpottingTimestamp: *lastTimestamp,
+			Location:          model.Point{Longitude: *lastLongitude, Latitude: *lastLatitude},
+		}
+	}
+	if imageId != nil {
+		detail.LatestImage = &model.Image{ID: *imageId, FileName: *imageFileName, Type: *imageType}
+	}
+	return &detail, nil
+}
+
+// UpdateAnimal changes the fields of the update that are not nil. Deleted animals cannot be updated and yield
+// ErrNotFound, taking the name and variant of another animal of the same type fails with ErrConflict and variants
+// that are not a subspecies of the animal's type fail with ErrUnknownTaxon. A date of birth that is not after the
+// parents' and before the offspring's fails with a LineageError.
+func (db *AnimalDB) UpdateAnimal(animalId int64, update *model.AnimalUpdate, actor model.Actor) (*model.Animal, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	if update.DateOfBirth != nil {
+		if err = checkDateOfBirth(ctx, tx, animalId, *update.DateOfBirth); err != nil {
+			return nil, err
+		}
+	}
+	var subspeciesID *int64
+	if update.Variant != nil {
+		err = tx.QueryRow(ctx, `
+			SELECT ss.id
+			FROM animal a
+			LEFT JOIN taxon ss ON ss.parent_id = a.species_id AND ss.name = $2
+			WHERE a.id = $1 AND a.deleted_at IS NULL`,
+			animalId, NormalizeTaxonName(*update.Variant)).Scan(&subspeciesID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		if subspeciesID == nil {
+			return nil, ErrUnknownTaxon
+		}
+	}
+	var animal model.Animal
+	err = scanAnimal(tx.QueryRow(ctx,
+		`WITH a AS (
+			UPDATE animal SET
+				name = COALESCE($2, name),
+				subspecies_id = COALESCE($3, subspecies_id),
+				date_of_birth = COALESCE(TO_DATE($4, 'YYYY-MM-DD'), date_of_birth),
+				description = COALESCE($5, description),
+				updated_at = now()
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING *
 		)
-	`
-	params := make([]interface{}, 0)
-	if name != "" {
-		sqlQuery += " AND a.name = $" + strconv.Itoa(len(params)+1)
-		params = append(params, name)
+		SELECT `+animalColumns+` FROM a`+animalTaxonJoins,
+		animalId, update.Name, subspeciesID, update.DateOfBirth, update.Description), &animal)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
 	}
-	if name == "" {
-		sqlQuery += " AND a.type = $" + strconv.Itoa(len(params)+1)
-		params = append(params, "tiger")
-	} else {
-		sqlQuery += " AND a.type = $" + strconv.Itoa(len(params)+2)
-		params = append(params, animalType)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Animal with id", animalId, "updated")
+	return &animal, nil
+}
+
+// DeleteAnimal hides the animal and its sightings. Nothing is removed, so that the animal can be restored.
+func (db *AnimalDB) DeleteAnimal(animalId int64, actor model.Actor) error {
+	tag, err := execAudited(context.Background(), db.pool, actor,
+		`UPDATE animal SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`,
+		animalId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("Animal with id", animalId, "deleted")
+	return nil
+}
+
+// RestoreAnimal brings a deleted animal back along with its sightings. Animals that are not deleted or were merged
+// into another yield ErrNotFound.
+func (db *AnimalDB) RestoreAnimal(animalId int64, actor model.Actor) (*model.Animal, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var animal model.Animal
+	err = scanAnimal(tx.QueryRow(ctx,
+		`WITH a AS (
+			UPDATE animal SET deleted_at = NULL
+			WHERE id = $1 AND deleted_at IS NOT NULL AND merged_into_id IS NULL
+			RETURNING *
+		)
+		SELECT `+animalColumns+` FROM a`+animalTaxonJoins,
+		animalId), &animal)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
 	}
-	sqlQuery += " ORDER BY s.spotting_timestamp DESC"
-	sqlQuery += " LIMIT $" + strconv.Itoa(len(params)+1) + " OFFSET $" + strconv.Itoa(len(params)+2)
-	params = append(params, limit, offset)
-	rows, err := db.pool.Query(context.Background(), sqlQuery, params...)
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Animal with id", animalId, "restored")
+	return &animal, nil
+}
+
+// animalKeysets orders the animal list. Age is ordered by the date of birth, youngest first when ascending.
+var animalKeysets = map[string]keyset{
+	model.AnimalSortLastSeen: {
+		column:  "s.spotting_timestamp",
+		key:     fmt.Sprintf(cursorTimestampKey, "s.spotting_timestamp"),
+		keyType: "timestamptz",
+		id:      "a.id",
+	},
+	model.AnimalSortName: {column: "lower(a.name)", key: "lower(a.name)", keyType: "text", id: "a.id"},
+	model.AnimalSortAge: {
+		column:  "a.date_of_birth",
+		key:     fmt.Sprintf(cursorDateKey, "a.date_of_birth"),
+		keyType: "date",
+		id:      "a.id",
+	},
+}
+
+const animalListFrom = `
+		FROM animal a` + animalTaxonJoins + `
+		JOIN LATERAL (
+			SELECT location, spotting_timestamp
+			FROM sighting
+			WHERE animal_id = a.id
+			ORDER BY spotting_timestamp DESC, id DESC
+			LIMIT 1
+		) s ON true
+	`
+
+// ListAnimalInfo returns a page of the animals matching the filter, each with its latest sighting.
+func (db *AnimalDB) ListAnimalInfo(filter model.AnimalFilter) (*model.Page[model.AnimalReqResp], error) {
+	ctx := context.Background()
+	sort := animalSort(filter.Sort)
+	sqlQuery, params := animalListQuery(filter)
+	rows, err := db.pool.Query(ctx, sqlQuery, params...)
 	if err != nil {
 		logger.LogError(err)
 		return nil, err
 	}
 	defer rows.Close()
 	var responseArray []model.AnimalReqResp
+	var keys []model.Cursor
 	for rows.Next() {
 		var response model.AnimalReqResp
+		key := model.Cursor{Sort: sort, Descending: filter.Descending}
 		err = rows.Scan(
 			&response.AnimalID,
 			&response.Animal.Name,
@@ -140,16 +381,114 @@ func (db *AnimalDB) ListAnimalInfo(name string, animalType string, limit string,
 			&response.Animal.Variant,
 			&response.Animal.DateOfBirth,
 			&response.Animal.Description,
+			&response.Animal.SpeciesID,
+			&response.Animal.SubspeciesID,
+			&response.Animal.Status,
+			&response.Animal.Identifiers,
 			&response.Sighting.Location.Longitude,
 			&response.Sighting.Location.Latitude,
 			&response.Sighting.SpottingTimestamp,
+			&key.Key,
 		)
 		if err != nil {
 			logger.LogError(err)
 			return nil, err
 		}
+		key.ID = response.AnimalID
 		responseArray = append(responseArray, response)
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	page := pageOf(responseArray, keys, filter.Cursor, filter.Limit)
+	if filter.WithTotal {
+		where, params := animalListWhere(filter)
+		var total int64
+		if err = db.pool.QueryRow(ctx, "SELECT COUNT(*)"+animalListFrom+where, params...).Scan(&total); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		page.Total = &total
 	}
 	logger.LogInfo("Retrieved animal list info")
-	return responseArray, nil
+	return page, nil
+}
+
+// animalSort returns the sort key of the animal list, last seen unless another known key is given.
+func animalSort(sort string) string {
+	if _, ok := animalKeysets[sort]; ok {
+		return sort
+	}
+	return model.AnimalSortLastSeen
+}
+
+// animalListQuery builds the query of ListAnimalInfo. Every filter value is passed as a parameter.
+func animalListQuery(filter model.AnimalFilter) (string, []interface{}) {
+	sort := animalSort(filter.Sort)
+	order := animalKeysets[sort]
+	where, params := animalListWhere(filter)
+	sqlQuery := `
+		SELECT a.id AS animal_id, a.name, sp.name AS type, COALESCE(ss.name, '') AS variant, TO_CHAR(a.date_of_birth, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS date_of_birth, a.description,
+       	a.species_id, a.subspecies_id, a.status, ` + animalIdentifiers + ` AS identifiers,
+       	s.location[0] AS longitude, s.location[1] AS latitude, TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS spotting_timestamp,
+       	` + order.key + ` AS cursor_key` + animalListFrom + where
+	descending := filter.Descending != (sort == model.AnimalSortAge)
+	return order.page(sqlQuery, params, filter.Cursor, descending, filter.Limit)
+}
+
+// animalListWhere builds the WHERE clause of the animal list, which can refer to the species as sp, the subspecies
+// as ss and the latest sighting as s. Deceased animals are left out unless statuses are given.
+func animalListWhere(filter model.AnimalFilter) (string, []interface{}) {
+	where := " WHERE a.deleted_at IS NULL"
+	params := make([]interface{}, 0)
+	if filter.Name != "" {
+		params = append(params, filter.Name)
+		where += " AND a.name = $" + strconv.Itoa(len(params))
+	}
+	if filter.NamePrefix != "" {
+		params = append(params, escapeLike(strings.ToLower(filter.NamePrefix))+"%")
+		where += " AND lower(a.name) LIKE $" + strconv.Itoa(len(params))
+	}
+	if filter.Type != "" {
+		params = append(params, NormalizeTaxonName(filter.Type))
+		where += " AND sp.name = $" + strconv.Itoa(len(params))
+	}
+	if filter.Variant != "" {
+		params = append(params, NormalizeTaxonName(filter.Variant))
+		where += " AND ss.name = $" + strconv.Itoa(len(params))
+	}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		params = append(params, statuses)
+		where += " AND a.status = ANY($" + strconv.Itoa(len(params)) + ")"
+	} else {
+		where += " AND a.status <> '" + string(model.AnimalStatusDeceased) + "'"
+	}
+	if filter.MinAge != nil {
+		params = append(params, *filter.MinAge)
+		where += " AND a.date_of_birth <= current_date - make_interval(years => $" + strconv.Itoa(len(params)) + ")"
+	}
+	if filter.MaxAge != nil {
+		params = append(params, *filter.MaxAge+1)
+		where += " AND a.date_of_birth > current_date - make_interval(years => $" + strconv.Itoa(len(params)) + ")"
+	}
+	if filter.SeenAfter != nil {
+		params = append(params, *filter.SeenAfter)
+		where += " AND s.spotting_timestamp >= $" + strconv.Itoa(len(params))
+	}
+	if filter.SeenBefore != nil {
+		params = append(params, *filter.SeenBefore)
+		where += " AND s.spotting_timestamp < $" + strconv.Itoa(len(params))
+	}
+	if box := filter.BoundingBox; box != nil {
+		params = append(params, box.MinLongitude, box.MinLatitude, box.MaxLongitude, box.MaxLatitude)
+		where += " AND s.location <@ box(point($" + strconv.Itoa(len(params)-3) + ", $" + strconv.Itoa(len(params)-2) +
+			"), point($" + strconv.Itoa(len(params)-1) + ", $" + strconv.Itoa(len(params)) + "))"
+	}
+	return where, params
 }
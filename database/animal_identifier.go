@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+var ErrAmbiguousIdentifier = errors.New("Identifier is given to animals under several schemes")
+
+// IdentifierTakenError is an identifier that is already given to another animal under the same scheme.
+type IdentifierTakenError struct {
+	Identifier model.AnimalIdentifier
+}
+
+func (e *IdentifierTakenError) Error() string {
+	return fmt.Sprintf("The %s %s is already given to another animal", e.Identifier.Scheme, e.Identifier.Value)
+}
+
+// insertIdentifiers gives the identifiers to the animal. Identifiers given to another animal, deleted or not, fail
+// with an IdentifierTakenError.
+func insertIdentifiers(ctx context.Context, tx pgx.Tx, animalId int64, identifiers []model.AnimalIdentifier) error {
+	for _, identifier := range identifiers {
+		_, err := tx.Exec(ctx, `INSERT INTO animal_identifier (animal_id, scheme, value) VALUES ($1, $2, $3)`,
+			animalId, identifier.Scheme, identifier.Value)
+		if isUniqueViolation(err) {
+			return &IdentifierTakenError{Identifier: identifier}
+		}
+		if err != nil {
+			logger.LogError(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// SetIdentifiers replaces the identifiers of the animal. Identifiers it already has are kept as they are, so that
+// the audit log only records the ones that were added or removed. Deleted animals yield ErrNotFound.
+func (db *AnimalDB) SetIdentifiers(animalId int64, identifiers []model.AnimalIdentifier, actor model.Actor) (*model.Animal, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT true FROM animal WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		animalId).Scan(&exists)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `SELECT id, scheme, value FROM animal_identifier WHERE animal_id = $1`, animalId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	existing := make(map[model.AnimalIdentifier]int64)
+	for rows.Next() {
+		var id int64
+		var identifier model.AnimalIdentifier
+		if err = rows.Scan(&id, &identifier.Scheme, &identifier.Value); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		existing[identifier] = id
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	added := make([]model.AnimalIdentifier, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		if _, ok := existing[identifier]; ok {
+			delete(existing, identifier)
+		} else {
+			added = append(added, identifier)
+		}
+	}
+	removed := make([]int64, 0, len(existing))
+	for _, id := range existing {
+		removed = append(removed, id)
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM animal_identifier WHERE id = ANY($1)`, removed); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = insertIdentifiers(ctx, tx, animalId, added); err != nil {
+		return nil, err
+	}
+	var animal model.Animal
+	err = scanAnimal(tx.QueryRow(ctx, `SELECT `+animalColumns+` FROM animal a`+animalTaxonJoins+` WHERE a.id = $1`,
+		animalId), &animal)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Identifiers of animal with id", animalId, "updated")
+	return &animal, nil
+}
+
+// LookupAnimal returns the animal with the identifier, ignoring case. Without a scheme the value is looked up under
+// every scheme, and values given to different animals under different schemes yield ErrAmbiguousIdentifier.
+// Identifiers of deleted animals yield ErrNotFound.
+func (db *AnimalDB) LookupAnimal(scheme model.IdentifierScheme, value string) (*model.Animal, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT DISTINCT `+animalColumns+`
+		FROM animal a`+animalTaxonJoins+`
+		JOIN animal_identifier ident ON ident.animal_id = a.id
+		WHERE lower(ident.value) = lower($2) AND ($1 = '' OR ident.scheme = $1) AND a.deleted_at IS NULL
+		LIMIT 2`,
+		scheme, value)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var animals []model.Animal
+	for rows.Next() {
+		var animal model.Animal
+		if err = scanAnimal(rows, &animal); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		animals = append(animals, animal)
+	}
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	switch len(animals) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		return &animals[0], nil
+	default:
+		return nil, ErrAmbiguousIdentifier
+	}
+}
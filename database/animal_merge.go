@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"math"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// mergedSighting is a sighting on the timeline of an animal a duplicate was merged into.
+type mergedSighting struct {
+	id       int64
+	location model.Point
+	moved    bool
+}
+
+// MergeAnimals moves the sightings, identifiers, offspring and litters of the duplicate to the animal in one
+// transaction and leaves the duplicate behind as a deleted alias of it, see MergedInto. Sightings are not rejected
+// by the distance check, they are reported as conflicts instead. Animals that are related or the parents of the same
+// offspring fail with a LineageError, as do offspring of the duplicate that are not born after the animal. Deleted
+// animals yield ErrNotFound.
+func (db *AnimalDB) MergeAnimals(animalId int64, duplicateId int64, actor model.Actor) (*model.AnimalMerge, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	if err = lockLineage(ctx, tx); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `SELECT id FROM animal WHERE id IN ($1, $2) AND deleted_at IS NULL ORDER BY id FOR UPDATE`,
+		animalId, duplicateId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	found := 0
+	for rows.Next() {
+		found++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if found != 2 {
+		return nil, ErrNotFound
+	}
+	if err = checkMerge(ctx, tx, animalId, duplicateId); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `SET CONSTRAINTS animal_litter_id_mother_id_fkey DEFERRED`); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	for _, query := range []string{
+		`UPDATE litter SET mother_id = $1 WHERE mother_id = $2`,
+		`UPDATE litter SET father_id = $1 WHERE father_id = $2`,
+		`UPDATE animal SET mother_id = $1, updated_at = now() WHERE mother_id = $2`,
+		`UPDATE animal SET father_id = $1, updated_at = now() WHERE father_id = $2`,
+		`UPDATE animal_identifier SET animal_id = $1 WHERE animal_id = $2`,
+		`UPDATE animal SET merged_into_id = $1 WHERE merged_into_id = $2`,
+		`UPDATE animal SET merged_into_id = $1, deleted_at = now(), updated_at = now() WHERE id = $2`,
+	} {
+		if _, err = tx.Exec(ctx, query, animalId, duplicateId); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+	}
+	rows, err = tx.Query(ctx, `UPDATE sighting SET animal_id = $1 WHERE animal_id = $2 RETURNING id`, animalId, duplicateId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	moved := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		moved[id] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	rows, err = tx.Query(ctx, `
+		SELECT id, location[0], location[1]
+		FROM sighting
+		WHERE animal_id = $1
+		ORDER BY spotting_timestamp, id`,
+		animalId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	var timeline []mergedSighting
+	for rows.Next() {
+		var sighting mergedSighting
+		if err = rows.Scan(&sighting.id, &sighting.location.Longitude, &sighting.location.Latitude); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		sighting.moved = moved[sighting.id]
+		timeline = append(timeline, sighting)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	merge := model.AnimalMerge{
+		DuplicateID:    duplicateId,
+		MovedSightings: len(moved),
+		Conflicts:      sightingConflicts(timeline),
+	}
+	err = scanAnimal(tx.QueryRow(ctx, `SELECT `+animalColumns+` FROM animal a`+animalTaxonJoins+` WHERE a.id = $1`,
+		animalId), &merge.Animal)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Animal with id", duplicateId, "merged into animal with id", animalId)
+	return &merge, nil
+}
+
+// checkMerge fails with a LineageError when the duplicate cannot be the same animal as the one it is merged into.
+// Deleted relatives count too, as they can be restored.
+func checkMerge(ctx context.Context, tx pgx.Tx, animalId int64, duplicateId int64) error {
+	for _, pair := range [][2]int64{{animalId, duplicateId}, {duplicateId, animalId}} {
+		var descendant bool
+		err := tx.QueryRow(ctx, `
+			WITH RECURSIVE descendant AS (
+				SELECT id FROM animal WHERE mother_id = $1 OR father_id = $1
+				UNION
+				SELECT a.id FROM animal a JOIN descendant d ON a.mother_id = d.id OR a.father_id = d.id
+			)
+			SELECT EXISTS (SELECT 1 FROM descendant WHERE id = $2)`,
+			pair[0], pair[1]).Scan(&descendant)
+		if err != nil {
+			logger.LogError(err)
+			return err
+		}
+		if descendant {
+			return &LineageError{Reason: fmt.Sprintf("Animal %d is a descendant of animal %d", pair[1], pair[0])}
+		}
+	}
+	var parentsTogether, bornBeforeOffspring bool
+	err := tx.QueryRow(ctx, `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM animal WHERE (mother_id = $1 AND father_id = $2) OR (mother_id = $2 AND father_id = $1)
+				UNION ALL
+				SELECT 1 FROM litter WHERE (mother_id = $1 AND father_id = $2) OR (mother_id = $2 AND father_id = $1)
+			),
+			NOT EXISTS (
+				SELECT 1 FROM animal c JOIN animal p ON p.id = $1
+				WHERE (c.mother_id = $2 OR c.father_id = $2) AND c.date_of_birth <= p.date_of_birth
+			)`,
+		animalId, duplicateId).Scan(&parentsTogether, &bornBeforeOffspring)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if parentsTogether {
+		return &LineageError{Reason: "The animals are the parents of the same offspring"}
+	}
+	if !bornBeforeOffspring {
+		return &LineageError{Reason: "The offspring of the duplicate should be born after the animal"}
+	}
+	return nil
+}
+
+// sightingConflicts returns the sightings of the timeline, ordered by when they were spotted, that fail the distance
+// check of CreateSighting against the sighting before them. Neighbours that came from the same animal passed or
+// skipped that check before the merge and are left out.
+func sightingConflicts(timeline []mergedSighting) []model.SightingConflict {
+	conflicts := make([]model.SightingConflict, 0)
+	for i := 1; i < len(timeline); i++ {
+		previous, current := timeline[i-1], timeline[i]
+		if previous.moved == current.moved {
+			continue
+		}
+		distance := getDistance(&previous.location, &current.location)
+		if distance <= thresholdDistanceInKms {
+			conflicts = append(conflicts, model.SightingConflict{
+				SightingID:         current.id,
+				PreviousSightingID: previous.id,
+				DistanceKm:         math.Round(distance*1000) / 1000,
+			})
+		}
+	}
+	return conflicts
+}
+
+// MergedInto returns the animal the given one was merged into, or ErrNotFound when it was not merged.
+func (db *AnimalDB) MergedInto(animalId int64) (int64, error) {
+	var mergedIntoId int64
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT merged_into_id FROM animal WHERE id = $1 AND merged_into_id IS NOT NULL`,
+		animalId).Scan(&mergedIntoId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	return mergedIntoId, nil
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/model"
+)
+
+func TestSightingConflicts(t *testing.T) {
+	waterhole := model.Point{Latitude: 21.5, Longitude: 78.9}
+	nearby := model.Point{Latitude: 21.51, Longitude: 78.9}
+	faraway := model.Point{Latitude: 22.5, Longitude: 78.9}
+	timeline := []mergedSighting{
+		{id: 1, location: waterhole},
+		{id: 2, location: faraway},
+		{id: 7, location: nearby, moved: true},
+		{id: 3, location: faraway},
+		{id: 4, location: nearby},
+		{id: 8, location: waterhole, moved: true},
+		{id: 9, location: nearby, moved: true},
+	}
+
+	conflicts := sightingConflicts(timeline)
+	assert.Len(t, conflicts, 1, "Only neighbours from different animals within the threshold should conflict")
+	assert.Equal(t, int64(8), conflicts[0].SightingID)
+	assert.Equal(t, int64(4), conflicts[0].PreviousSightingID)
+	assert.InDelta(t, 1.112, conflicts[0].DistanceKm, 0.001)
+
+	assert.Empty(t, sightingConflicts(timeline[:1]))
+	assert.NotNil(t, sightingConflicts(nil), "No conflicts should be an empty list")
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"html"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// SearchSortRelevance is the only order of animal searches, best match first.
+const SearchSortRelevance = "relevance"
+
+// animalSearchVector has to stay the expression of animal_search_idx for the index to be used. The variant is in
+// the taxon table, it is matched through matchingSubspecies and weighted by variantSearchVector.
+const (
+	animalSearchVector = `(
+			setweight(to_tsvector('english', a.name), 'A') ||
+			setweight(to_tsvector('english', coalesce(a.description, '')), 'C')
+		)`
+	variantSearchVector = `setweight(to_tsvector('english', coalesce(ss.name, '')), 'B')`
+)
+
+// Markers of the matches in ts_headline output. Private use characters are not expected in animal descriptions
+// and survive HTML escaping, so that only the markers become <mark> tags.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+var animalSearchKeyset = keyset{column: "r.rank", key: "r.rank::text", keyType: "float8", id: "r.animal_id"}
+
+// SearchAnimals returns a page of the animals matching the search, by relevance. Words match the full-text index
+// with stemming, so that "limping" finds "limp", and names and variants also match by trigram similarity, so that
+// "Sherru" finds "Sheru".
+func (db *AnimalDB) SearchAnimals(search model.AnimalSearch) (*model.Page[model.AnimalSearchResult], error) {
+	ctx := context.Background()
+	sqlQuery, params := animalSearchQuery(search)
+	rows, err := db.pool.Query(ctx, sqlQuery, params...)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var results []model.AnimalSearchResult
+	var keys []model.Cursor
+	for rows.Next() {
+		var result model.AnimalSearchResult
+		var highlights [3]*string
+		key := model.Cursor{Sort: SearchSortRelevance, Descending: true}
+		err = rows.Scan(
+			&result.Animal.ID,
+			&result.Animal.Name,
+			&result.Animal.Type,
+			&result.Animal.Variant,
+			&result.Animal.DateOfBirth,
+			&result.Animal.Description,
+			&result.Animal.SpeciesID,
+			&result.Animal.SubspeciesID,
+			&result.Animal.Status,
+			&result.Animal.Identifiers,
+			&result.Location.Longitude,
+			&result.Location.Latitude,
+			&result.SpottingTimestamp,
+			&result.Rank,
+			&key.Key,
+			&highlights[0],
+			&highlights[1],
+			&highlights[2],
+		)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		if search.Highlight {
+			result.Highlights = &model.AnimalHighlights{
+				Name:        markHighlights(highlights[0]),
+				Variant:     markHighlights(highlights[1]),
+				Description: markHighlights(highlights[2]),
+			}
+		}
+		key.ID = result.Animal.ID
+		results = append(results, result)
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	page := pageOf(results, keys, search.Cursor, search.Limit)
+	if search.WithTotal {
+		where, params := animalSearchWhere(search)
+		var total int64
+		if err = db.pool.QueryRow(ctx, "SELECT COUNT(*)"+animalListFrom+where, params...).Scan(&total); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		page.Total = &total
+	}
+	logger.LogInfo("Searched animals")
+	return page, nil
+}
+
+// animalSearchQuery builds the query of SearchAnimals. Matches are ranked in a subquery so that the cursor can
+// refer to the rank, and highlights are only made for the rows of the page.
+func animalSearchQuery(search model.AnimalSearch) (string, []interface{}) {
+	where, params := animalSearchWhere(search)
+	query := "$" + strconv.Itoa(len(params))
+	highlights := "NULL, NULL, NULL"
+	if search.Highlight {
+		params = append(params, `StartSel="`+highlightStart+`", StopSel="`+highlightStop+`", HighlightAll=true`,
+			`StartSel="`+highlightStart+`", StopSel="`+highlightStop+`", MaxWords=20, MinWords=5, MaxFragments=2`)
+		all, fragments := "$"+strconv.Itoa(len(params)-1), "$"+strconv.Itoa(len(params))
+		highlights = `ts_headline('english', r.name, websearch_to_tsquery('english', ` + query + `), ` + all + `),
+		ts_headline('english', r.variant, websearch_to_tsquery('english', ` + query + `), ` + all + `),
+		ts_headline('english', r.description, websearch_to_tsquery('english', ` + query + `), ` + fragments + `)`
+	}
+	sqlQuery := `
+		SELECT r.animal_id, r.name, r.type, r.variant, r.date_of_birth, r.description, r.species_id, r.subspecies_id, r.status,
+		r.identifiers, r.longitude, r.latitude, r.spotting_timestamp,
+		r.rank, ` + animalSearchKeyset.key + `,
+		` + highlights + `
+		FROM (
+			SELECT a.id AS animal_id, a.name, sp.name AS type, COALESCE(ss.name, '') AS variant, TO_CHAR(a.date_of_birth, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS date_of_birth, a.description,
+			a.species_id, a.subspecies_id, a.status, ` + animalIdentifiers + ` AS identifiers, s.location[0] AS longitude, s.location[1] AS latitude, TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS spotting_timestamp,
+			(ts_rank(` + animalSearchVector + ` || ` + variantSearchVector + `, websearch_to_tsquery('english', ` + query + `)) +
+				greatest(similarity(a.name, ` + query + `), similarity(COALESCE(ss.name, ''), ` + query + `)))::float8 AS rank` +
+		animalListFrom + where + `
+		) r
+		WHERE true`
+	return animalSearchKeyset.page(sqlQuery, params, search.Cursor, true, search.Limit)
+}
+
+// animalSearchWhere adds the match of the search to the WHERE clause of the animal list. The query is the last parameter.
+func animalSearchWhere(search model.AnimalSearch) (string, []interface{}) {
+	where, params := animalListWhere(search.AnimalFilter)
+	params = append(params, search.Query)
+	query := "$" + strconv.Itoa(len(params))
+	matchingSubspecies := "SELECT t.id FROM taxon t WHERE t.parent_id IS NOT NULL AND (t.name % " + query +
+		" OR to_tsvector('english', t.name) @@ websearch_to_tsquery('english', " + query + "))"
+	where += " AND (" + animalSearchVector + " @@ websearch_to_tsquery('english', " + query + ")" +
+		" OR a.name % " + query + " OR a.subspecies_id IN (" + matchingSubspecies + "))"
+	return where, params
+}
+
+// markHighlights escapes the ts_headline output and turns its markers into <mark> tags.
+func markHighlights(headline *string) string {
+	if headline == nil {
+		return ""
+	}
+	escaped := html.EscapeString(*headline)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(escaped)
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"tigerhall-kittens/model"
+)
+
+func TestAnimalSearchQuery(t *testing.T) {
+	search := model.AnimalSearch{
+		AnimalFilter: model.AnimalFilter{Type: "tiger", Variant: "bengal tiger", Limit: 10},
+		Query:        "torn ear'; DROP TABLE animal; --",
+	}
+	sqlQuery, params := animalSearchQuery(search)
+	assert.NotContains(t, sqlQuery, "DROP TABLE", "The query should only be passed as a parameter")
+	assert.Equal(t, []interface{}{"tiger", "bengal tiger", search.Query, 11}, params)
+	assert.Contains(t, sqlQuery, "websearch_to_tsquery('english', $3)")
+	assert.Contains(t, sqlQuery, "a.name % $3 OR a.subspecies_id IN (SELECT t.id FROM taxon t WHERE t.parent_id IS NOT NULL AND (t.name % $3")
+	assert.Contains(t, sqlQuery, "ORDER BY r.rank DESC, r.animal_id DESC LIMIT $4")
+	assert.NotContains(t, sqlQuery, "ts_headline", "Highlights should only be made when asked for")
+
+	search.Highlight = true
+	search.Cursor = &model.Cursor{Sort: SearchSortRelevance, Descending: true, Key: "0.5", ID: 9}
+	sqlQuery, params = animalSearchQuery(search)
+	assert.Equal(t, 8, len(params))
+	assert.Contains(t, sqlQuery, "ts_headline('english', r.description, websearch_to_tsquery('english', $3), $5)")
+	assert.Contains(t, sqlQuery, "(r.rank, r.animal_id) < ($6::float8, $7)")
+	assert.Contains(t, sqlQuery, "LIMIT $8")
+	for _, placeholder := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(sqlQuery, -1) {
+		n, _ := strconv.Atoi(placeholder[1])
+		assert.LessOrEqual(t, n, len(params), "Placeholder %s should have a parameter", placeholder[0])
+	}
+
+	where, params := animalSearchWhere(search)
+	assert.True(t, strings.HasPrefix(where, " WHERE a.deleted_at IS NULL"))
+	assert.Equal(t, search.Query, params[len(params)-1], "The query should be the last parameter of the count")
+}
+
+func TestMarkHighlights(t *testing.T) {
+	headline := "Walks with a " + highlightStart + "limp" + highlightStop + " <script>"
+	assert.Equal(t, "Walks with a <mark>limp</mark> &lt;script&gt;", markHighlights(&headline))
+	assert.Equal(t, "", markHighlights(nil))
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+var (
+	ErrUnknownSighting = errors.New("Sighting not found for the animal")
+	ErrAnimalDeceased  = errors.New("Animal is deceased")
+)
+
+// StatusTransitionError is a status an animal cannot be given from the one it has.
+type StatusTransitionError struct {
+	From model.AnimalStatus
+	To   model.AnimalStatus
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("An animal that is %s cannot become %s", e.From, e.To)
+}
+
+// SetAnimalStatus gives the animal a new status and records the change. Statuses the current one cannot become
+// yield a StatusTransitionError and sightings of other animals ErrUnknownSighting. Deleted animals yield ErrNotFound.
+func (db *AnimalDB) SetAnimalStatus(animalId int64, update *model.AnimalStatusUpdate, actor model.Actor) (*model.AnimalStatusChange, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	change := model.AnimalStatusChange{
+		AnimalID:   animalId,
+		To:         update.Status,
+		Reason:     update.Reason,
+		SightingID: update.SightingID,
+		ActorID:    actor.UserID,
+	}
+	err = tx.QueryRow(ctx, `SELECT status FROM animal WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		animalId).Scan(&change.From)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if !change.From.CanBecome(change.To) {
+		return nil, &StatusTransitionError{From: change.From, To: change.To}
+	}
+	if update.SightingID != nil {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sighting WHERE id = $1 AND animal_id = $2)`,
+			*update.SightingID, animalId).Scan(&exists)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrUnknownSighting
+		}
+	}
+	if _, err = tx.Exec(ctx, `UPDATE animal SET status = $2, updated_at = now() WHERE id = $1`,
+		animalId, change.To); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	var actorId *int64
+	if actor.UserID != 0 {
+		actorId = &actor.UserID
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO animal_status_history (animal_id, actor_id, from_status, to_status, reason, sighting_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`,
+		animalId, actorId, change.From, change.To, change.Reason, change.SightingID).Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Animal with id", animalId, "went from", change.From, "to", change.To)
+	return &change, nil
+}
+
+// ListStatusHistory returns the status changes of the animal, latest first. Deleted animals yield ErrNotFound.
+func (db *AnimalDB) ListStatusHistory(animalId int64) ([]model.AnimalStatusChange, error) {
+	ctx := context.Background()
+	var exists bool
+	err := db.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM animal WHERE id = $1 AND deleted_at IS NULL)`,
+		animalId).Scan(&exists)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	rows, err := db.pool.Query(ctx,
+		`SELECT h.id, h.animal_id, h.from_status, h.to_status, h.reason, h.sighting_id, COALESCE(h.actor_id, 0),
+		COALESCE(u.username, ''), TO_CHAR(h.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM animal_status_history h
+		LEFT OUTER JOIN "user" u ON h.actor_id = u.id
+		WHERE h.animal_id = $1
+		ORDER BY h.created_at DESC, h.id DESC`,
+		animalId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make([]model.AnimalStatusChange, 0)
+	for rows.Next() {
+		var change model.AnimalStatusChange
+		err = rows.Scan(&change.ID, &change.AnimalID, &change.From, &change.To, &change.Reason, &change.SightingID,
+			&change.ActorID, &change.ActorUsername, &change.CreatedAt)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
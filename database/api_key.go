@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/pii"
+)
+
+type IAPIKey interface {
+	CreateAPIKey(key *model.APIKey) error
+	ListAPIKeys(userId int64) ([]model.APIKey, error)
+	RevokeAPIKey(keyId int64, userId int64) error
+	AuthenticateAPIKey(keyHash string) (*model.Principal, error)
+}
+
+type APIKeyDB struct {
+	pool *pgxpool.Pool
+	keys *pii.Keyring
+}
+
+func NewAPIKeyDB(pool *pgxpool.Pool, keys *pii.Keyring) *APIKeyDB {
+	return &APIKeyDB{
+		pool: pool,
+		keys: keys,
+	}
+}
+
+func (db *APIKeyDB) CreateAPIKey(key *model.APIKey) error {
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+	err := db.pool.QueryRow(context.Background(),
+		`INSERT INTO api_key (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6)
+		RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, scopes, key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		logger.LogError(err)
+		return fmt.Errorf("Failed to store API key: %w", err)
+	}
+	logger.LogInfo("User with id", key.UserID, "created API key", key.ID)
+	return nil
+}
+
+func (db *APIKeyDB) ListAPIKeys(userId int64) ([]model.APIKey, error) {
+	rows, err := db.pool.Query(context.Background(),
+		`SELECT id, name, prefix, scopes, expires_at,
+		COALESCE(TO_CHAR(last_used_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+		COALESCE(TO_CHAR(revoked_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+		TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM api_key WHERE user_id = $1
+		ORDER BY created_at DESC`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]model.APIKey, 0)
+	for rows.Next() {
+		key := model.APIKey{UserID: userId}
+		var scopes []string
+		err = rows.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		key.Scopes = toPermissions(scopes)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (db *APIKeyDB) RevokeAPIKey(keyId int64, userId int64) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`UPDATE api_key SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 AND user_id = $2`,
+		keyId, userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("User with id", userId, "revoked API key", keyId)
+	return nil
+}
+
+// AuthenticateAPIKey returns the principal of the owner of an active key, limited to the key's scopes.
+// Unknown, revoked and expired keys as well as keys of disabled users yield ErrNotFound.
+func (db *APIKeyDB) AuthenticateAPIKey(keyHash string) (*model.Principal, error) {
+	var principal model.Principal
+	var email sealedEmail
+	var scopes []string
+	err := db.pool.QueryRow(context.Background(),
+		`UPDATE api_key k SET last_used_at = now()
+		FROM "user" u
+		WHERE k.user_id = u.id AND k.key_hash = $1 AND k.revoked_at IS NULL AND u.disabled_at IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > now())
+		RETURNING u.id, u.username, `+emailColumns+`, u.role, u.email_verified_at IS NOT NULL, k.scopes`,
+		keyHash).Scan(&principal.UserID, &principal.Username, &email.keyID, &email.ciphertext, &email.legacy, &principal.Role,
+		&principal.EmailVerified, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if principal.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return nil, err
+	}
+	principal.Scopes = toPermissions(scopes)
+	return &principal, nil
+}
+
+func toPermissions(scopes []string) []model.Permission {
+	permissions := make([]model.Permission, len(scopes))
+	for i, scope := range scopes {
+		permissions[i] = model.Permission(scope)
+	}
+	return permissions
+}
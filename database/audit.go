@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strconv"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// Entities recorded in the audit log, named after their tables.
+const (
+	AuditEntityUser             = "user"
+	AuditEntityAnimal           = "animal"
+	AuditEntityAnimalIdentifier = "animal_identifier"
+	AuditEntityLitter           = "litter"
+	AuditEntitySighting         = "sighting"
+	AuditEntityImage            = "image"
+	AuditEntityTaxon            = "taxon"
+)
+
+type IAudit interface {
+	ListAuditLog(filter model.AuditFilter) ([]model.AuditEntry, error)
+}
+
+type AuditDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewAuditDB(pool *pgxpool.Pool) *AuditDB {
+	return &AuditDB{
+		pool: pool,
+	}
+}
+
+// ValidAuditEntity reports whether changes of the entity are recorded in the audit log.
+func ValidAuditEntity(entity string) bool {
+	switch entity {
+	case AuditEntityUser, AuditEntityAnimal, AuditEntityAnimalIdentifier, AuditEntityLitter, AuditEntitySighting,
+		AuditEntityImage, AuditEntityTaxon:
+		return true
+	}
+	return false
+}
+
+// beginAudited begins a transaction whose changes the audit triggers attribute to the actor and request.
+func beginAudited(ctx context.Context, pool *pgxpool.Pool, actor model.Actor) (pgx.Tx, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction")
+	}
+	if err = setAuditActor(ctx, tx, actor); err != nil {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			logger.LogError(rollbackErr)
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+// setAuditActor stores the actor in settings local to the transaction, where the audit triggers read it.
+func setAuditActor(ctx context.Context, tx pgx.Tx, actor model.Actor) error {
+	actorId := ""
+	if actor.UserID != 0 {
+		actorId = strconv.FormatInt(actor.UserID, 10)
+	}
+	_, err := tx.Exec(ctx,
+		`SELECT set_config('audit.actor_id', $1, true), set_config('audit.request_id', $2, true)`,
+		actorId, actor.RequestID)
+	if err != nil {
+		logger.LogError(err)
+		return fmt.Errorf("Failed to set audit actor: %w", err)
+	}
+	return nil
+}
+
+func rollbackAudited(ctx context.Context, tx pgx.Tx) {
+	if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		logger.LogError(err)
+	}
+}
+
+// execAudited runs a single statement in its own transaction attributed to the actor.
+func execAudited(ctx context.Context, pool *pgxpool.Pool, actor model.Actor, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx, err := beginAudited(ctx, pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	return tag, nil
+}
+
+// ListAuditLog returns the matching entries, newest first.
+func (db *AuditDB) ListAuditLog(filter model.AuditFilter) ([]model.AuditEntry, error) {
+	sqlQuery := `
+		SELECT l.id, COALESCE(l.actor_id, 0), COALESCE(u.username, ''), l.action, l.entity, l.entity_id,
+		l.before, l.after, COALESCE(l.request_id, ''), TO_CHAR(l.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM audit_log l
+		LEFT OUTER JOIN "user" u ON l.actor_id = u.id
+		WHERE TRUE`
+	params := make([]interface{}, 0)
+	if filter.ActorID != 0 {
+		params = append(params, filter.ActorID)
+		sqlQuery += " AND l.actor_id = $" + strconv.Itoa(len(params))
+	}
+	if filter.Entity != "" {
+		params = append(params, filter.Entity)
+		sqlQuery += " AND l.entity = $" + strconv.Itoa(len(params))
+	}
+	if filter.EntityID != 0 {
+		params = append(params, filter.EntityID)
+		sqlQuery += " AND l.entity_id = $" + strconv.Itoa(len(params))
+	}
+	if filter.From != nil {
+		params = append(params, *filter.From)
+		sqlQuery += " AND l.created_at >= $" + strconv.Itoa(len(params))
+	}
+	if filter.To != nil {
+		params = append(params, *filter.To)
+		sqlQuery += " AND l.created_at < $" + strconv.Itoa(len(params))
+	}
+	sqlQuery += " ORDER BY l.created_at DESC, l.id DESC"
+	params = append(params, filter.Limit, filter.Offset)
+	sqlQuery += " LIMIT $" + strconv.Itoa(len(params)-1) + " OFFSET $" + strconv.Itoa(len(params))
+	rows, err := db.pool.Query(context.Background(), sqlQuery, params...)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]model.AuditEntry, 0)
+	for rows.Next() {
+		var entry model.AuditEntry
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorUsername, &entry.Action, &entry.Entity, &entry.EntityID,
+			&entry.Before, &entry.After, &entry.RequestID, &entry.CreatedAt)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// SightingSortTimestamp is the only order of the sighting list, latest first.
+const SightingSortTimestamp = "spotting_timestamp"
+
+// Formats of the cursor keys. Timestamps keep the microseconds stored by Postgres so that no row is skipped.
+const (
+	cursorTimestampKey = `TO_CHAR(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')`
+	cursorDateKey      = `TO_CHAR(%s, 'YYYY-MM-DD')`
+)
+
+func EncodeCursor(cursor model.Cursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns ErrInvalidCursor when the cursor is malformed or belongs to another order.
+func DecodeCursor(value string, sort string, descending bool) (*model.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor model.Cursor
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.Descending != descending {
+		return nil, ErrInvalidCursor
+	}
+	switch sort {
+	case model.AnimalSortLastSeen, SightingSortTimestamp:
+		_, err = time.Parse(time.RFC3339Nano, cursor.Key)
+	case model.AnimalSortAge:
+		_, err = time.Parse("2006-01-02", cursor.Key)
+	case SearchSortRelevance:
+		_, err = strconv.ParseFloat(cursor.Key, 64)
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// keyset orders a list by a sort key and then by ID, so that pages can start after a cursor instead of an offset.
+type keyset struct {
+	// column is the sort key and key its text form, which is selected as the key of the cursors.
+	column string
+	key    string
+	// keyType is the type the key of a cursor is cast to.
+	keyType string
+	id      string
+}
+
+// page adds the condition of the cursor, the order and the limit to the query, which has to end with its WHERE
+// clause. One row more than the limit is selected to tell whether there are more. Backward pages are selected in
+// reverse order, pageOf restores it.
+func (k keyset) page(sqlQuery string, params []interface{}, cursor *model.Cursor, descending bool, limit int) (string, []interface{}) {
+	if cursor != nil && cursor.Backward {
+		descending = !descending
+	}
+	operator, direction := ">", "ASC"
+	if descending {
+		operator, direction = "<", "DESC"
+	}
+	if cursor != nil {
+		params = append(params, cursor.Key, cursor.ID)
+		sqlQuery += " AND (" + k.column + ", " + k.id + ") " + operator + " ($" + strconv.Itoa(len(params)-1) + "::" + k.keyType +
+			", $" + strconv.Itoa(len(params)) + ")"
+	}
+	sqlQuery += " ORDER BY " + k.column + " " + direction + ", " + k.id + " " + direction
+	params = append(params, limit+1)
+	sqlQuery += " LIMIT $" + strconv.Itoa(len(params))
+	return sqlQuery, params
+}
+
+// pageOf makes the page of the rows selected by keyset.page, keys holding the cursor of each row.
+func pageOf[T any](items []T, keys []model.Cursor, cursor *model.Cursor, limit int) *model.Page[T] {
+	more := len(items) > limit
+	if more {
+		items, keys = items[:limit], keys[:limit]
+	}
+	hasNext, hasPrev := more, cursor != nil
+	if cursor != nil && cursor.Backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+	page := &model.Page[T]{Items: items, HasMore: hasNext && len(items) > 0}
+	if page.Items == nil {
+		page.Items = make([]T, 0)
+	}
+	if len(items) == 0 {
+		return page
+	}
+	if hasNext {
+		next := keys[len(keys)-1]
+		next.Backward = false
+		page.NextCursor = EncodeCursor(next)
+	}
+	if hasPrev {
+		prev := keys[0]
+		prev.Backward = true
+		page.PrevCursor = EncodeCursor(prev)
+	}
+	return page
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/model"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	cursor := model.Cursor{Sort: SightingSortTimestamp, Descending: true, Key: "2023-07-30T12:34:56.123456Z", ID: 42}
+	decoded, err := DecodeCursor(EncodeCursor(cursor), SightingSortTimestamp, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor, decoded)
+
+	_, err = DecodeCursor(EncodeCursor(cursor), SightingSortTimestamp, false)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "Cursor of another order should be rejected")
+	_, err = DecodeCursor(EncodeCursor(cursor), model.AnimalSortName, true)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "Cursor of another sort should be rejected")
+	_, err = DecodeCursor("e30", SightingSortTimestamp, true)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "Cursor without a key should be rejected")
+	_, err = DecodeCursor("%%%", SightingSortTimestamp, true)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	age := model.Cursor{Sort: model.AnimalSortAge, Key: "2019-04-02T00:00:00Z", ID: 1}
+	_, err = DecodeCursor(EncodeCursor(age), model.AnimalSortAge, false)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "Age cursors should hold a date")
+}
+
+func TestKeysetPage(t *testing.T) {
+	params := []interface{}{int64(7)}
+	sqlQuery, params := sightingKeyset.page("SELECT s.id FROM sighting s WHERE s.animal_id = $1", params, nil, true, 10)
+	assert.Equal(t, "SELECT s.id FROM sighting s WHERE s.animal_id = $1 ORDER BY s.spotting_timestamp DESC, s.id DESC LIMIT $2", sqlQuery)
+	assert.Equal(t, []interface{}{int64(7), 11}, params)
+
+	next := &model.Cursor{Key: "2023-07-30T12:34:56.123456Z", ID: 42}
+	sqlQuery, params = sightingKeyset.page("WHERE true", nil, next, true, 10)
+	assert.Equal(t, "WHERE true AND (s.spotting_timestamp, s.id) < ($1::timestamptz, $2) ORDER BY s.spotting_timestamp DESC, s.id DESC LIMIT $3", sqlQuery)
+	assert.Equal(t, []interface{}{next.Key, next.ID, 11}, params)
+
+	prev := &model.Cursor{Key: "2023-07-30T12:34:56.123456Z", ID: 42, Backward: true}
+	sqlQuery, _ = sightingKeyset.page("WHERE true", nil, prev, true, 10)
+	assert.Equal(t, "WHERE true AND (s.spotting_timestamp, s.id) > ($1::timestamptz, $2) ORDER BY s.spotting_timestamp ASC, s.id ASC LIMIT $3", sqlQuery,
+		"Backward pages should be selected in reverse")
+}
+
+func TestPageOf(t *testing.T) {
+	keys := func(ids ...int64) []model.Cursor {
+		cursors := make([]model.Cursor, 0, len(ids))
+		for _, id := range ids {
+			cursors = append(cursors, model.Cursor{Sort: model.AnimalSortName, Key: "k", ID: id})
+		}
+		return cursors
+	}
+	decode := func(value string) *model.Cursor {
+		cursor, err := DecodeCursor(value, model.AnimalSortName, false)
+		assert.NoError(t, err)
+		return cursor
+	}
+
+	page := pageOf([]int64{1, 2, 3}, keys(1, 2, 3), nil, 2)
+	assert.Equal(t, []int64{1, 2}, page.Items, "The extra row should be dropped")
+	assert.True(t, page.HasMore)
+	assert.Equal(t, int64(2), decode(page.NextCursor).ID)
+	assert.False(t, decode(page.NextCursor).Backward)
+	assert.Empty(t, page.PrevCursor, "The first page has no previous page")
+
+	page = pageOf([]int64{3, 4}, keys(3, 4), decode(page.NextCursor), 2)
+	assert.False(t, page.HasMore, "The last page has no more")
+	assert.Empty(t, page.NextCursor)
+	prev := decode(page.PrevCursor)
+	assert.Equal(t, int64(3), prev.ID)
+	assert.True(t, prev.Backward)
+
+	page = pageOf([]int64{2, 1}, keys(2, 1), prev, 2)
+	assert.Equal(t, []int64{1, 2}, page.Items, "Backward pages should be put back in order")
+	assert.True(t, page.HasMore)
+	assert.Equal(t, int64(2), decode(page.NextCursor).ID)
+	assert.Empty(t, page.PrevCursor, "Going back to the first page leaves no previous page")
+
+	page = pageOf([]int64(nil), nil, nil, 2)
+	assert.Equal(t, []int64{}, page.Items, "Empty pages should have empty items")
+	assert.False(t, page.HasMore)
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"regexp"
+	"strings"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/pii"
+)
+
+const maxUsernameLength = 30
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type IIdentity interface {
+	FindOrCreateUserByIdentity(identity *model.ExternalIdentity, actor model.Actor) (*model.User, error)
+}
+
+type IdentityDB struct {
+	pool *pgxpool.Pool
+	keys *pii.Keyring
+}
+
+func NewIdentityDB(pool *pgxpool.Pool, keys *pii.Keyring) *IdentityDB {
+	return &IdentityDB{
+		pool: pool,
+		keys: keys,
+	}
+}
+
+// FindOrCreateUserByIdentity returns the local user linked to the external identity,
+// creating and linking a new reporter on first login. The email of the new reporter is verified
+// when the identity provider verified it. An identity with the email of a local user is linked to
+// that user when both the provider and the user verified the email, and fails with ErrConflict
+// otherwise.
+func (db *IdentityDB) FindOrCreateUserByIdentity(identity *model.ExternalIdentity, actor model.Actor) (*model.User, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var user model.User
+	var email sealedEmail
+	err = tx.QueryRow(ctx,
+		`SELECT u.id, u.username, u.email_key_id, u.email_ciphertext, u.email, u.role,
+			u.email_verified_at IS NOT NULL, u.disabled_at IS NOT NULL
+		FROM user_identity i
+		JOIN "user" u ON i.user_id = u.id
+		WHERE i.provider = $1 AND i.subject = $2`,
+		identity.Provider, identity.Subject).Scan(&user.ID, &user.Username, &email.keyID, &email.ciphertext, &email.legacy,
+		&user.Role, &user.EmailVerified, &user.Disabled)
+	if err == nil {
+		if user.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		logger.LogError(err)
+		return nil, err
+	}
+	linked, err := db.findUserByEmail(ctx, tx, identity, &user)
+	if err != nil {
+		return nil, err
+	}
+	if !linked {
+		if err = db.createExternalUser(ctx, tx, identity, &user); err != nil {
+			return nil, err
+		}
+	}
+	var keyID *string
+	var ciphertext []byte
+	if identity.Email != "" {
+		sealedKeyID, sealed, err := db.keys.Seal(identityEmailLabel, identity.Email)
+		if err != nil {
+			logger.LogError(err)
+			return nil, fmt.Errorf("Failed to encrypt email: %w", err)
+		}
+		keyID, ciphertext = &sealedKeyID, sealed
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO user_identity (user_id, provider, subject, email_key_id, email_ciphertext) VALUES($1, $2, $3, $4, $5)`,
+		user.ID, identity.Provider, identity.Subject, keyID, ciphertext)
+	if err != nil {
+		logger.LogError(err)
+		return nil, fmt.Errorf("Failed to link identity: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	if linked {
+		logger.LogInfo("User", user.Username, "linked to", identity.Provider, "identity")
+	} else {
+		logger.LogInfo("User", user.Username, "created for", identity.Provider, "identity")
+	}
+	return &user, nil
+}
+
+// findUserByEmail scans the local user with the email of the identity into user and returns whether the identity
+// can be linked to it, which needs both the provider and the user to have verified the email. Otherwise a
+// registration with somebody else's email could take over their login, and it fails with ErrConflict.
+func (db *IdentityDB) findUserByEmail(ctx context.Context, tx pgx.Tx, identity *model.ExternalIdentity, user *model.User) (bool, error) {
+	if identity.Email == "" {
+		return false, nil
+	}
+	var email sealedEmail
+	err := tx.QueryRow(ctx,
+		`SELECT id, username, `+emailColumns+`, role, email_verified_at IS NOT NULL, disabled_at IS NOT NULL
+		FROM "user" WHERE email_index = $1 OR lower(email) = lower($2)
+		ORDER BY id LIMIT 1 FOR UPDATE`,
+		userEmailIndex(db.keys, identity.Email), identity.Email).Scan(&user.ID, &user.Username, &email.keyID,
+		&email.ciphertext, &email.legacy, &user.Role, &user.EmailVerified, &user.Disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		logger.LogError(err)
+		return false, err
+	}
+	if !identity.EmailVerified || !user.EmailVerified {
+		return false, ErrConflict
+	}
+	if user.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// createExternalUser creates a user without a password, so it can only log in through its identity provider.
+func (db *IdentityDB) createExternalUser(ctx context.Context, tx pgx.Tx, identity *model.ExternalIdentity, user *model.User) error {
+	keyID, ciphertext, index, err := sealUserEmail(db.keys, identity.Email)
+	if err != nil {
+		return err
+	}
+	if identity.Email == "" {
+		// Users without an email would all share the blind index of the empty string, which is unique.
+		index = nil
+	}
+	base := externalUsername(identity)
+	for attempt := 1; attempt <= 10; attempt++ {
+		username := base
+		if attempt > 1 {
+			suffix := fmt.Sprintf("-%d", attempt)
+			username = truncate(base, maxUsernameLength-len(suffix)) + suffix
+		}
+		err = tx.QueryRow(ctx,
+			`INSERT INTO "user" (username, password, email_key_id, email_ciphertext, email_index, email_verified_at)
+			VALUES($1, '', $2, $3, $4, CASE WHEN $5 THEN now() END)
+			ON CONFLICT (username) DO NOTHING RETURNING id, username, role, email_verified_at IS NOT NULL`,
+			username, keyID, ciphertext, index, identity.EmailVerified && identity.Email != "").
+			Scan(&user.ID, &user.Username, &user.Role, &user.EmailVerified)
+		if err == nil {
+			user.Email = identity.Email
+			return nil
+		}
+		if isUniqueViolation(err) {
+			// Another login registered the email in the meantime.
+			return ErrConflict
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.LogError(err)
+			return fmt.Errorf("Failed to create user: %w", err)
+		}
+	}
+	return fmt.Errorf("Failed to find a free username for %s", base)
+}
+
+// externalUsername derives a username from the preferred username, the email or the provider subject.
+func externalUsername(identity *model.ExternalIdentity) string {
+	candidates := []string{
+		identity.PreferredUsername,
+		strings.Split(identity.Email, "@")[0],
+		identity.Provider + "-" + identity.Subject,
+	}
+	for _, candidate := range candidates {
+		username := usernameInvalidChars.ReplaceAllString(candidate, "")
+		if username != "" {
+			return truncate(username, maxUsernameLength)
+		}
+	}
+	return truncate(identity.Provider, maxUsernameLength)
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
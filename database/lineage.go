@@ -0,0 +1,322 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// LineageError explains why parents, a litter or a date of birth were rejected, its message is meant for the user.
+type LineageError struct {
+	Reason string
+}
+
+func (e *LineageError) Error() string {
+	return e.Reason
+}
+
+// lineageLockKey is the advisory lock taken by every change of parents or dates of birth, so that two concurrent
+// changes cannot make a cycle or break the order of births together.
+const lineageLockKey = 0x6c696e65616765
+
+func lockLineage(ctx context.Context, tx pgx.Tx) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(lineageLockKey)); err != nil {
+		logger.LogError(err)
+		return err
+	}
+	return nil
+}
+
+// checkParents fails with a LineageError when the mother or father does not exist, is a descendant of the animal
+// or is not born before it. New animals have a zero animalId.
+func checkParents(ctx context.Context, tx pgx.Tx, animalId int64, dateOfBirth string, motherId *int64, fatherId *int64) error {
+	if motherId != nil && fatherId != nil && *motherId == *fatherId {
+		return &LineageError{Reason: "mother_id and father_id should be different animals"}
+	}
+	if err := lockLineage(ctx, tx); err != nil {
+		return err
+	}
+	parents := []struct {
+		role string
+		id   *int64
+	}{{"mother", motherId}, {"father", fatherId}}
+	for _, parent := range parents {
+		if parent.id == nil {
+			continue
+		}
+		if *parent.id == animalId {
+			return &LineageError{Reason: fmt.Sprintf("An animal cannot be its own %s", parent.role)}
+		}
+		var descendant, bornBefore bool
+		err := tx.QueryRow(ctx, `
+			WITH RECURSIVE descendant AS (
+				SELECT id FROM animal WHERE mother_id = $1 OR father_id = $1
+				UNION
+				SELECT a.id FROM animal a JOIN descendant d ON a.mother_id = d.id OR a.father_id = d.id
+			)
+			SELECT p.id IN (SELECT id FROM descendant), p.date_of_birth < TO_DATE($3, 'YYYY-MM-DD')
+			FROM animal p
+			WHERE p.id = $2 AND p.deleted_at IS NULL`,
+			animalId, *parent.id, dateOfBirth).Scan(&descendant, &bornBefore)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &LineageError{Reason: fmt.Sprintf("The %s, animal %d, does not exist", parent.role, *parent.id)}
+		}
+		if err != nil {
+			logger.LogError(err)
+			return err
+		}
+		if descendant {
+			return &LineageError{Reason: fmt.Sprintf("The %s, animal %d, is a descendant of the animal", parent.role, *parent.id)}
+		}
+		if !bornBefore {
+			return &LineageError{Reason: fmt.Sprintf("The %s, animal %d, should be born before the animal", parent.role, *parent.id)}
+		}
+	}
+	return nil
+}
+
+// checkDateOfBirth fails with a LineageError when the animal would not be born after its parents and before its
+// offspring. Deleted relatives count too, as they can be restored.
+func checkDateOfBirth(ctx context.Context, tx pgx.Tx, animalId int64, dateOfBirth string) error {
+	if err := lockLineage(ctx, tx); err != nil {
+		return err
+	}
+	var afterParents, beforeOffspring bool
+	err := tx.QueryRow(ctx, `
+		SELECT
+			NOT EXISTS (
+				SELECT 1 FROM animal a JOIN animal p ON p.id IN (a.mother_id, a.father_id)
+				WHERE a.id = $1 AND p.date_of_birth >= TO_DATE($2, 'YYYY-MM-DD')
+			),
+			NOT EXISTS (
+				SELECT 1 FROM animal c
+				WHERE (c.mother_id = $1 OR c.father_id = $1) AND c.date_of_birth <= TO_DATE($2, 'YYYY-MM-DD')
+			)`,
+		animalId, dateOfBirth).Scan(&afterParents, &beforeOffspring)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if !afterParents {
+		return &LineageError{Reason: "An animal should be born after its parents"}
+	}
+	if !beforeOffspring {
+		return &LineageError{Reason: "An animal should be born before its offspring"}
+	}
+	return nil
+}
+
+// SetParents replaces both parents of the animal, see checkParents. Cubs of a litter keep the litter's mother.
+// Deleted animals yield ErrNotFound.
+func (db *AnimalDB) SetParents(animalId int64, parents model.AnimalParents, actor model.Actor) (*model.Animal, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var dateOfBirth string
+	var motherId, litterId *int64
+	err = tx.QueryRow(ctx, `
+		SELECT TO_CHAR(date_of_birth, 'YYYY-MM-DD'), mother_id, litter_id
+		FROM animal
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`,
+		animalId).Scan(&dateOfBirth, &motherId, &litterId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if litterId != nil && motherId != nil && (parents.MotherID == nil || *parents.MotherID != *motherId) {
+		return nil, &LineageError{Reason: "The mother of a cub registered with a litter cannot be changed"}
+	}
+	if err = checkParents(ctx, tx, animalId, dateOfBirth, parents.MotherID, parents.FatherID); err != nil {
+		return nil, err
+	}
+	var animal model.Animal
+	err = scanAnimal(tx.QueryRow(ctx,
+		`WITH a AS (
+			UPDATE animal SET mother_id = $2, father_id = $3, updated_at = now()
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT `+animalColumns+` FROM a`+animalTaxonJoins,
+		animalId, parents.MotherID, parents.FatherID), &animal)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Parents of animal with id", animalId, "updated")
+	return &animal, nil
+}
+
+// GetFamilyTree returns the animal with its ancestors and descendants up to the given number of generations.
+// Deleted relatives are left out along with their own relatives. Deleted animals yield ErrNotFound.
+func (db *AnimalDB) GetFamilyTree(animalId int64, generations int) (*model.FamilyTree, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		WITH RECURSIVE ancestor AS (
+			SELECT id, 0 AS generation FROM animal WHERE id = $1 AND deleted_at IS NULL
+			UNION
+			SELECT p.id, an.generation + 1
+			FROM ancestor an
+			JOIN animal c ON c.id = an.id
+			JOIN animal p ON p.id IN (c.mother_id, c.father_id) AND p.deleted_at IS NULL
+			WHERE an.generation < $2
+		), descendant AS (
+			SELECT id, 0 AS generation FROM animal WHERE id = $1 AND deleted_at IS NULL
+			UNION
+			SELECT c.id, d.generation + 1
+			FROM descendant d
+			JOIN animal c ON (c.mother_id = d.id OR c.father_id = d.id) AND c.deleted_at IS NULL
+			WHERE d.generation < $2
+		)
+		SELECT `+animalColumns+`
+		FROM animal a`+animalTaxonJoins+`
+		WHERE a.id IN (SELECT id FROM ancestor UNION SELECT id FROM descendant)
+		ORDER BY a.date_of_birth, a.id`,
+		animalId, generations)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var animals []model.Animal
+	for rows.Next() {
+		var animal model.Animal
+		if err = scanAnimal(rows, &animal); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		animals = append(animals, animal)
+	}
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	tree := buildFamilyTree(animals, animalId, generations)
+	if tree == nil {
+		return nil, ErrNotFound
+	}
+	return tree, nil
+}
+
+// buildFamilyTree links the animals into the family tree of the one with animalId, which is nil when it is not
+// among them. Offspring keep the order of the animals.
+func buildFamilyTree(animals []model.Animal, animalId int64, generations int) *model.FamilyTree {
+	byID := make(map[int64]model.Animal, len(animals))
+	offspring := make(map[int64][]int64)
+	for _, animal := range animals {
+		byID[animal.ID] = animal
+		for _, parentId := range []*int64{animal.MotherID, animal.FatherID} {
+			if parentId != nil {
+				offspring[*parentId] = append(offspring[*parentId], animal.ID)
+			}
+		}
+	}
+	root, ok := byID[animalId]
+	if !ok {
+		return nil
+	}
+	var ancestors func(parentId *int64, generation int) *model.FamilyTree
+	ancestors = func(parentId *int64, generation int) *model.FamilyTree {
+		if parentId == nil || generation > generations {
+			return nil
+		}
+		parent, ok := byID[*parentId]
+		if !ok {
+			return nil
+		}
+		return &model.FamilyTree{
+			Animal: parent,
+			Mother: ancestors(parent.MotherID, generation+1),
+			Father: ancestors(parent.FatherID, generation+1),
+		}
+	}
+	var descendants func(node *model.FamilyTree, generation int)
+	descendants = func(node *model.FamilyTree, generation int) {
+		if generation > generations {
+			return
+		}
+		for _, childId := range offspring[node.ID] {
+			child := &model.FamilyTree{Animal: byID[childId]}
+			node.Offspring = append(node.Offspring, child)
+			descendants(child, generation+1)
+		}
+	}
+	tree := &model.FamilyTree{
+		Animal: root,
+		Mother: ancestors(root.MotherID, 1),
+		Father: ancestors(root.FatherID, 1),
+	}
+	descendants(tree, 1)
+	return tree
+}
+
+// CreateLitter registers the cubs of the mother, each with its own copy of the litter's sighting, in one
+// transaction. The father and the date of birth are checked as by checkParents, and cubs taking the name of
+// another animal of the same type and variant fail with ErrConflict. Deleted mothers yield ErrNotFound.
+func (db *AnimalDB) CreateLitter(motherId int64, litter *model.LitterRequest, actor model.Actor) (*model.Litter, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var motherType, motherVariant string
+	err = tx.QueryRow(ctx, `
+		SELECT sp.name, COALESCE(ss.name, '')
+		FROM animal a`+animalTaxonJoins+`
+		WHERE a.id = $1 AND a.deleted_at IS NULL`,
+		motherId).Scan(&motherType, &motherVariant)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = checkParents(ctx, tx, 0, litter.DateOfBirth, &motherId, litter.FatherID); err != nil {
+		return nil, err
+	}
+	created := model.Litter{MotherID: motherId, FatherID: litter.FatherID, DateOfBirth: litter.DateOfBirth}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO litter (mother_id, father_id, date_of_birth) VALUES ($1, $2, TO_DATE($3, 'YYYY-MM-DD')) RETURNING id`,
+		motherId, litter.FatherID, litter.DateOfBirth).Scan(&created.ID)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	for _, cub := range litter.Cubs {
+		cub.Type = motherType
+		if cub.Variant == "" {
+			cub.Variant = motherVariant
+		}
+		cub.DateOfBirth = litter.DateOfBirth
+		cub.MotherID = &motherId
+		cub.FatherID = litter.FatherID
+		cub.LitterID = &created.ID
+		if err = createAnimalWithTransaction(ctx, tx, &cub); err != nil {
+			return nil, err
+		}
+		sighting := litter.Sighting
+		if err = CreateSightingWithTransaction(ctx, tx, cub.ID, &sighting); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		created.Cubs = append(created.Cubs, model.AnimalReqResp{AnimalID: cub.ID, Animal: cub, Sighting: sighting})
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Litter with id", created.ID, "of animal with id", motherId, "created")
+	return &created, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/model"
+)
+
+func TestBuildFamilyTree(t *testing.T) {
+	id := func(id int64) *int64 {
+		return &id
+	}
+	// 1 and 2 are the parents of 3, the mother of 4 and 5 whose daughter is 6. 7 is the grandmother of 1.
+	animals := []model.Animal{
+		{ID: 7, Name: "Rani"},
+		{ID: 8, Name: "Badi", MotherID: id(7)},
+		{ID: 1, Name: "Sheru", MotherID: id(8)},
+		{ID: 2, Name: "Raja"},
+		{ID: 3, Name: "Maya", MotherID: id(1), FatherID: id(2)},
+		{ID: 4, Name: "Chotu", MotherID: id(3), LitterID: id(1)},
+		{ID: 5, Name: "Mini", MotherID: id(3), LitterID: id(1)},
+		{ID: 6, Name: "Tara", MotherID: id(5)},
+	}
+
+	tree := buildFamilyTree(animals, 3, 2)
+	assert.Equal(t, "Maya", tree.Name)
+	assert.Equal(t, "Sheru", tree.Mother.Name)
+	assert.Equal(t, "Raja", tree.Father.Name)
+	assert.Equal(t, "Badi", tree.Mother.Mother.Name)
+	assert.Nil(t, tree.Mother.Mother.Mother, "Great-grandparents are beyond two generations")
+	assert.Nil(t, tree.Father.Mother)
+	assert.Len(t, tree.Offspring, 2)
+	assert.Equal(t, "Chotu", tree.Offspring[0].Name, "Offspring should keep the order of the animals")
+	assert.Nil(t, tree.Offspring[0].Mother, "Descendants should not repeat their parents")
+	assert.Equal(t, "Tara", tree.Offspring[1].Offspring[0].Name)
+
+	tree = buildFamilyTree(animals, 3, 1)
+	assert.Nil(t, tree.Mother.Mother)
+	assert.Empty(t, tree.Offspring[1].Offspring, "Grandchildren are beyond one generation")
+
+	tree = buildFamilyTree(animals[3:], 3, 2)
+	assert.Nil(t, tree.Mother, "Relatives that were not found should be left out")
+	assert.Nil(t, buildFamilyTree(animals, 9, 2))
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+const (
+	LoginKeyUsername = "username"
+	LoginKeyIP       = "ip"
+
+	// Failures beyond the threshold double the lockout, starting at baseLockout and capped at maxLockout.
+	// Failures older than failureWindow are forgotten.
+	lockoutThreshold = 5
+	baseLockout      = time.Minute
+	maxLockout       = time.Hour
+	failureWindow    = time.Hour
+)
+
+type ILoginAttempt interface {
+	LockedUntil(keys []model.LoginKey) (time.Time, error)
+	RecordLoginFailure(key model.LoginKey) (time.Time, error)
+	ClearLoginFailures(key model.LoginKey) error
+	ListLockouts() ([]model.Lockout, error)
+}
+
+type LoginAttemptDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewLoginAttemptDB(pool *pgxpool.Pool) *LoginAttemptDB {
+	return &LoginAttemptDB{
+		pool: pool,
+	}
+}
+
+// LockedUntil returns the latest lockout of the keys, or the zero time when none of them is locked.
+func (db *LoginAttemptDB) LockedUntil(keys []model.LoginKey) (time.Time, error) {
+	var lockedUntil time.Time
+	for _, key := range keys {
+		var until time.Time
+		err := db.pool.QueryRow(context.Background(),
+			`SELECT locked_until FROM login_attempt WHERE kind = $1 AND key = $2 AND locked_until > now()`,
+			key.Kind, key.Key).Scan(&until)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			logger.LogError(err)
+			return time.Time{}, err
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	return lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed attempt against the key and returns the resulting lockout, if any.
+func (db *LoginAttemptDB) RecordLoginFailure(key model.LoginKey) (time.Time, error) {
+	ctx := context.Background()
+	var failures int
+	err := db.pool.QueryRow(ctx,
+		`INSERT INTO login_attempt (kind, key, failures) VALUES($1, $2, 1)
+		ON CONFLICT (kind, key) DO UPDATE SET
+			failures = CASE WHEN login_attempt.last_failure_at < now() - $3::interval THEN 1 ELSE login_attempt.failures + 1 END,
+			last_failure_at = now()
+		RETURNING failures`,
+		key.Kind, key.Key, fmt.Sprintf("%d seconds", int(failureWindow.Seconds()))).Scan(&failures)
+	if err != nil {
+		logger.LogError(err)
+		return time.Time{}, err
+	}
+	duration := lockoutDuration(failures)
+	if duration == 0 {
+		return time.Time{}, nil
+	}
+	lockedUntil := time.Now().Add(duration)
+	_, err = db.pool.Exec(ctx,
+		`UPDATE login_attempt SET locked_until = $3 WHERE kind = $1 AND key = $2`,
+		key.Kind, key.Key, lockedUntil)
+	if err != nil {
+		logger.LogError(err)
+		return time.Time{}, err
+	}
+	logger.LogAudit("system", "login.lockout", key.Kind, key.Key, "failures", failures, "until", lockedUntil.Format(time.RFC3339))
+	return lockedUntil, nil
+}
+
+func (db *LoginAttemptDB) ClearLoginFailures(key model.LoginKey) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`DELETE FROM login_attempt WHERE kind = $1 AND key = $2`,
+		key.Kind, key.Key)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *LoginAttemptDB) ListLockouts() ([]model.Lockout, error) {
+	rows, err := db.pool.Query(context.Background(),
+		`SELECT kind, key, failures, TO_CHAR(last_failure_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), TO_CHAR(locked_until, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM login_attempt WHERE locked_until > now()
+		ORDER BY locked_until DESC`)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	lockouts := make([]model.Lockout, 0)
+	for rows.Next() {
+		var lockout model.Lockout
+		err = rows.Scan(&lockout.Kind, &lockout.Key, &lockout.Failures, &lockout.LastFailureAt, &lockout.LockedUntil)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, nil
+}
+
+// lockoutDuration is zero below the threshold and doubles with every further failure.
+func lockoutDuration(failures int) time.Duration {
+	if failures < lockoutThreshold {
+		return 0
+	}
+	duration := baseLockout
+	for i := lockoutThreshold; i < failures && duration < maxLockout; i++ {
+		duration *= 2
+	}
+	if duration > maxLockout {
+		return maxLockout
+	}
+	return duration
+}
@@ -0,0 +1,16 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	assert.Equal(t, time.Duration(0), lockoutDuration(1), "First failure should not lock")
+	assert.Equal(t, time.Duration(0), lockoutDuration(lockoutThreshold-1), "Failures below the threshold should not lock")
+	assert.Equal(t, baseLockout, lockoutDuration(lockoutThreshold), "Reaching the threshold should lock for the base duration")
+	assert.Equal(t, 2*baseLockout, lockoutDuration(lockoutThreshold+1), "Every further failure should double the lockout")
+	assert.Equal(t, 4*baseLockout, lockoutDuration(lockoutThreshold+2), "Every further failure should double the lockout")
+	assert.Equal(t, maxLockout, lockoutDuration(lockoutThreshold+100), "Lockout should be capped")
+}
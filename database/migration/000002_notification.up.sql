@@ -0,0 +1,17 @@
+CREATE TABLE "notification" (
+                              "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                              "user_id" bigint NOT NULL,
+                              "animal_id" bigint NOT NULL,
+                              "sighting_id" bigint NOT NULL,
+                              "message" varchar(255) NOT NULL,
+                              "read_at" timestamptz,
+                              "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "notification" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+ALTER TABLE "notification" ADD FOREIGN KEY ("animal_id") REFERENCES "animal" ("id");
+
+ALTER TABLE "notification" ADD FOREIGN KEY ("sighting_id") REFERENCES "sighting" ("id");
+
+CREATE INDEX notification_user_id_created_at_idx ON "notification" ("user_id", "created_at" DESC);
@@ -0,0 +1,20 @@
+CREATE TABLE "refresh_token" (
+                               "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                               "user_id" bigint NOT NULL,
+                               "family_id" varchar(64) NOT NULL,
+                               "token_hash" varchar(64) UNIQUE NOT NULL,
+                               "access_jti" varchar(64) NOT NULL,
+                               "expires_at" timestamptz NOT NULL,
+                               "revoked_at" timestamptz,
+                               "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE TABLE "revoked_token" (
+                               "jti" varchar(64) PRIMARY KEY,
+                               "expires_at" timestamptz NOT NULL,
+                               "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "refresh_token" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+CREATE INDEX refresh_token_family_id_idx ON "refresh_token" ("family_id");
@@ -0,0 +1,3 @@
+ALTER TABLE "user" ADD COLUMN "role" varchar(15) NOT NULL DEFAULT 'reporter';
+
+ALTER TABLE "user" ADD CONSTRAINT user_role_check CHECK ("role" IN ('reporter', 'ranger', 'moderator', 'admin'));
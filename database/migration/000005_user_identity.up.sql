@@ -0,0 +1,15 @@
+CREATE TABLE "user_identity" (
+                               "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                               "user_id" bigint NOT NULL,
+                               "provider" varchar(30) NOT NULL,
+                               "subject" varchar(255) NOT NULL,
+                               "email" varchar(254),
+                               "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "user_identity" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+ALTER TABLE "user_identity" ADD CONSTRAINT user_identity_provider_subject_key UNIQUE (provider, subject);
+
+/* Emails of partner identity providers do not fit the original column */
+ALTER TABLE "user" ALTER COLUMN "email" TYPE varchar(254);
@@ -0,0 +1,12 @@
+ALTER TABLE "user" ADD COLUMN "email_verified_at" timestamptz;
+
+CREATE TABLE "account_token" (
+                               "jti" varchar(64) PRIMARY KEY,
+                               "user_id" bigint NOT NULL,
+                               "purpose" varchar(30) NOT NULL,
+                               "expires_at" timestamptz NOT NULL,
+                               "used_at" timestamptz,
+                               "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "account_token" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
@@ -0,0 +1,8 @@
+CREATE TABLE "login_attempt" (
+                               "kind" varchar(10) NOT NULL,
+                               "key" varchar(255) NOT NULL,
+                               "failures" integer NOT NULL DEFAULT 0,
+                               "last_failure_at" timestamptz NOT NULL DEFAULT (now()),
+                               "locked_until" timestamptz,
+                               PRIMARY KEY ("kind", "key")
+);
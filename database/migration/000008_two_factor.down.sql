@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "two_factor_policy";
+DROP TABLE IF EXISTS "recovery_code";
+DROP TABLE IF EXISTS "user_totp";
@@ -0,0 +1,31 @@
+CREATE TABLE "user_totp" (
+                           "user_id" bigint PRIMARY KEY,
+                           "secret" varchar(64) NOT NULL,
+                           "confirmed_at" timestamptz,
+                           "last_used_step" bigint NOT NULL DEFAULT 0,
+                           "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE TABLE "recovery_code" (
+                               "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                               "user_id" bigint NOT NULL,
+                               "code_hash" varchar(64) NOT NULL,
+                               "used_at" timestamptz,
+                               "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE TABLE "two_factor_policy" (
+                                   "role" varchar(15) PRIMARY KEY,
+                                   "updated_by" bigint,
+                                   "updated_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "user_totp" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+ALTER TABLE "recovery_code" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+ALTER TABLE "two_factor_policy" ADD FOREIGN KEY ("updated_by") REFERENCES "user" ("id");
+
+ALTER TABLE "two_factor_policy" ADD CONSTRAINT two_factor_policy_role_check CHECK ("role" IN ('reporter', 'ranger', 'moderator', 'admin'));
+
+CREATE INDEX recovery_code_user_id_idx ON "recovery_code" ("user_id");
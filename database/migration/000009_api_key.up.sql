@@ -0,0 +1,16 @@
+CREATE TABLE "api_key" (
+                         "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                         "user_id" bigint NOT NULL,
+                         "name" varchar(100) NOT NULL,
+                         "prefix" varchar(16) NOT NULL,
+                         "key_hash" varchar(64) UNIQUE NOT NULL,
+                         "scopes" varchar(30)[] NOT NULL,
+                         "expires_at" timestamptz,
+                         "last_used_at" timestamptz,
+                         "revoked_at" timestamptz,
+                         "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "api_key" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+CREATE INDEX api_key_user_id_idx ON "api_key" ("user_id");
@@ -0,0 +1,10 @@
+ALTER TABLE "user" DROP COLUMN IF EXISTS "updated_at";
+/*
+ Anonymised sightings are attributed to the test user created by the initial migration, which is recreated without
+ a password it can log in with when it was deleted too.
+ */
+INSERT INTO "user" (id, username, password, email)
+SELECT 0, 'deleted-reporter', '', ''
+WHERE EXISTS (SELECT 1 FROM "sighting" WHERE "reporter" IS NULL) AND NOT EXISTS (SELECT 1 FROM "user" WHERE id = 0);
+UPDATE "sighting" SET "reporter" = 0 WHERE "reporter" IS NULL;
+ALTER TABLE "sighting" ALTER COLUMN "reporter" SET NOT NULL;
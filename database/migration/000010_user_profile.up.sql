@@ -0,0 +1,4 @@
+/* Sightings of deleted accounts are kept without a reporter */
+ALTER TABLE "sighting" ALTER COLUMN "reporter" DROP NOT NULL;
+
+ALTER TABLE "user" ADD COLUMN "updated_at" timestamptz;
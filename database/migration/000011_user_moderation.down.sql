@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS user_username_lower_idx;
+DROP TABLE IF EXISTS "user_admin_action";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "disabled_reason";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "disabled_at";
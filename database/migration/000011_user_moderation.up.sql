@@ -0,0 +1,21 @@
+ALTER TABLE "user" ADD COLUMN "disabled_at" timestamptz;
+
+ALTER TABLE "user" ADD COLUMN "disabled_reason" varchar(255);
+
+/* Actions on and by deleted accounts are kept for the record without the account */
+CREATE TABLE "user_admin_action" (
+                                   "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                                   "user_id" bigint,
+                                   "actor_id" bigint,
+                                   "action" varchar(30) NOT NULL,
+                                   "reason" varchar(255) NOT NULL,
+                                   "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "user_admin_action" ADD FOREIGN KEY ("user_id") REFERENCES "user" ("id");
+
+ALTER TABLE "user_admin_action" ADD FOREIGN KEY ("actor_id") REFERENCES "user" ("id");
+
+CREATE INDEX user_admin_action_user_id_created_at_idx ON "user_admin_action" ("user_id", "created_at" DESC);
+
+CREATE INDEX user_username_lower_idx ON "user" (lower("username"));
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS image_audit ON "image";
+DROP TRIGGER IF EXISTS sighting_audit ON "sighting";
+DROP TRIGGER IF EXISTS animal_audit ON "animal";
+DROP TRIGGER IF EXISTS user_audit ON "user";
+DROP FUNCTION IF EXISTS audit_row_change();
+DROP TABLE IF EXISTS "audit_log";
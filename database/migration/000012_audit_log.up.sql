@@ -0,0 +1,85 @@
+CREATE TABLE "audit_log" (
+                           "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                           "actor_id" bigint,
+                           "action" varchar(10) NOT NULL,
+                           "entity" varchar(30) NOT NULL,
+                           "entity_id" bigint NOT NULL,
+                           "before" jsonb,
+                           "after" jsonb,
+                           "request_id" varchar(64),
+                           "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX audit_log_entity_entity_id_created_at_idx ON "audit_log" ("entity", "entity_id", "created_at" DESC);
+
+CREATE INDEX audit_log_actor_id_created_at_idx ON "audit_log" ("actor_id", "created_at" DESC);
+
+CREATE INDEX audit_log_created_at_idx ON "audit_log" ("created_at" DESC);
+
+/*
+ Records every row change of the audited tables in the same transaction. The actor and request are read from
+ the audit.actor_id and audit.request_id settings of the transaction, left empty for changes the application
+ did not attribute. Columns passed as trigger arguments hold secrets or blobs and are only recorded as changed.
+ Updates record the changed columns only and updates that change nothing are not recorded.
+ */
+CREATE FUNCTION audit_row_change() RETURNS trigger AS $$
+DECLARE
+    old_row jsonb;
+    new_row jsonb;
+    diff_before jsonb;
+    diff_after jsonb;
+    redacted text;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+    FOREACH redacted IN ARRAY TG_ARGV LOOP
+        IF old_row IS NOT NULL THEN
+            old_row := jsonb_set(old_row, ARRAY[redacted], '"[redacted]"');
+        END IF;
+        IF new_row IS NOT NULL THEN
+            new_row := jsonb_set(new_row, ARRAY[redacted], CASE
+                WHEN TG_OP = 'UPDATE' AND to_jsonb(OLD) -> redacted IS DISTINCT FROM to_jsonb(NEW) -> redacted
+                THEN '"[changed]"'::jsonb
+                ELSE '"[redacted]"'::jsonb END);
+        END IF;
+    END LOOP;
+    diff_before := old_row;
+    diff_after := new_row;
+    IF TG_OP = 'UPDATE' THEN
+        SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, new_row -> o.key)
+        INTO diff_before, diff_after
+        FROM jsonb_each(old_row) o
+        WHERE new_row -> o.key IS DISTINCT FROM o.value;
+        IF diff_before IS NULL THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+    INSERT INTO audit_log (actor_id, action, entity, entity_id, before, after, request_id)
+    VALUES (
+        NULLIF(current_setting('audit.actor_id', true), '')::bigint,
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        TG_TABLE_NAME,
+        (COALESCE(new_row, old_row) ->> 'id')::bigint,
+        diff_before,
+        diff_after,
+        NULLIF(current_setting('audit.request_id', true), '')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit AFTER INSERT OR UPDATE OR DELETE ON "user"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('password');
+
+CREATE TRIGGER animal_audit AFTER INSERT OR UPDATE OR DELETE ON "animal"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+CREATE TRIGGER sighting_audit AFTER INSERT OR UPDATE OR DELETE ON "sighting"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+CREATE TRIGGER image_audit AFTER INSERT OR UPDATE OR DELETE ON "image"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('data');
@@ -0,0 +1,8 @@
+CREATE TABLE "rate_limit_bucket" (
+                                   "key" varchar(255) PRIMARY KEY,
+                                   "tokens" double precision NOT NULL,
+                                   "updated_at" timestamptz NOT NULL,
+                                   "full_at" timestamptz NOT NULL
+);
+
+CREATE INDEX rate_limit_bucket_full_at_idx ON "rate_limit_bucket" ("full_at");
@@ -0,0 +1,78 @@
+/*
+ Plain text emails are restored by the application, which holds the keys: run it with PII_DECRYPT=true until every
+ email is decrypted before migrating down. Emails that were redacted in the audit log stay redacted.
+ */
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM "user" WHERE email_key_id IS NOT NULL)
+        OR EXISTS (SELECT 1 FROM "user_identity" WHERE email_key_id IS NOT NULL) THEN
+        RAISE EXCEPTION 'Emails are still encrypted, run the application with PII_DECRYPT=true first';
+    END IF;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS user_audit ON "user";
+
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS trigger AS $$
+DECLARE
+    old_row jsonb;
+    new_row jsonb;
+    diff_before jsonb;
+    diff_after jsonb;
+    redacted text;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+    FOREACH redacted IN ARRAY TG_ARGV LOOP
+        IF old_row IS NOT NULL THEN
+            old_row := jsonb_set(old_row, ARRAY[redacted], '"[redacted]"');
+        END IF;
+        IF new_row IS NOT NULL THEN
+            new_row := jsonb_set(new_row, ARRAY[redacted], CASE
+                WHEN TG_OP = 'UPDATE' AND to_jsonb(OLD) -> redacted IS DISTINCT FROM to_jsonb(NEW) -> redacted
+                THEN '"[changed]"'::jsonb
+                ELSE '"[redacted]"'::jsonb END);
+        END IF;
+    END LOOP;
+    diff_before := old_row;
+    diff_after := new_row;
+    IF TG_OP = 'UPDATE' THEN
+        SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, new_row -> o.key)
+        INTO diff_before, diff_after
+        FROM jsonb_each(old_row) o
+        WHERE new_row -> o.key IS DISTINCT FROM o.value;
+        IF diff_before IS NULL THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+    INSERT INTO audit_log (actor_id, action, entity, entity_id, before, after, request_id)
+    VALUES (
+        NULLIF(current_setting('audit.actor_id', true), '')::bigint,
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        TG_TABLE_NAME,
+        (COALESCE(new_row, old_row) ->> 'id')::bigint,
+        diff_before,
+        diff_after,
+        NULLIF(current_setting('audit.request_id', true), '')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit AFTER INSERT OR UPDATE OR DELETE ON "user"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('password');
+
+DROP INDEX IF EXISTS user_identity_email_key_id_idx;
+ALTER TABLE "user_identity" DROP COLUMN IF EXISTS "email_ciphertext";
+ALTER TABLE "user_identity" DROP COLUMN IF EXISTS "email_key_id";
+DROP INDEX IF EXISTS user_email_key_id_idx;
+DROP INDEX IF EXISTS user_email_index_idx;
+ALTER TABLE "user" DROP COLUMN IF EXISTS "email_index";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "email_ciphertext";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "email_key_id";
+
+ALTER TABLE "user" ALTER COLUMN "email" SET NOT NULL;
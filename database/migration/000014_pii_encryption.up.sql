@@ -0,0 +1,113 @@
+/*
+ Emails are envelope encrypted by the application. The plain text email column is emptied by the re-encryption
+ job once it has encrypted the row, lookups go through the blind index.
+ */
+ALTER TABLE "user" ALTER COLUMN "email" DROP NOT NULL;
+
+/*
+ The blind index is unique and ignores case and surrounding spaces, users sharing an email that way have to be
+ given distinct emails first. Users without an email get no index.
+ */
+DO $$
+DECLARE
+    duplicates text;
+BEGIN
+    SELECT string_agg(ids, '; ') INTO duplicates
+    FROM (
+        SELECT string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM "user"
+        WHERE btrim(email) <> ''
+        GROUP BY lower(btrim(email))
+        HAVING count(*) > 1
+    ) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'Users share an email ignoring case, give them distinct emails before migrating: %', duplicates;
+    END IF;
+END;
+$$;
+
+ALTER TABLE "user" ADD COLUMN "email_key_id" varchar(32);
+
+ALTER TABLE "user" ADD COLUMN "email_ciphertext" bytea;
+
+ALTER TABLE "user" ADD COLUMN "email_index" bytea;
+
+CREATE UNIQUE INDEX user_email_index_idx ON "user" ("email_index");
+
+CREATE INDEX user_email_key_id_idx ON "user" ("email_key_id");
+
+ALTER TABLE "user_identity" ADD COLUMN "email_key_id" varchar(32);
+
+ALTER TABLE "user_identity" ADD COLUMN "email_ciphertext" bytea;
+
+CREATE INDEX user_identity_email_key_id_idx ON "user_identity" ("email_key_id");
+
+/*
+ Trigger arguments starting with - name columns left out of the audit log entirely, the encrypted values only
+ change their representation. The blind index changes with the email, so email changes are still recorded.
+ */
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS trigger AS $$
+DECLARE
+    old_row jsonb;
+    new_row jsonb;
+    diff_before jsonb;
+    diff_after jsonb;
+    col text;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+    FOREACH col IN ARRAY TG_ARGV LOOP
+        IF left(col, 1) = '-' THEN
+            old_row := old_row - substr(col, 2);
+            new_row := new_row - substr(col, 2);
+            CONTINUE;
+        END IF;
+        IF old_row IS NOT NULL THEN
+            old_row := jsonb_set(old_row, ARRAY[col], '"[redacted]"');
+        END IF;
+        IF new_row IS NOT NULL THEN
+            new_row := jsonb_set(new_row, ARRAY[col], CASE
+                WHEN TG_OP = 'UPDATE' AND to_jsonb(OLD) -> col IS DISTINCT FROM to_jsonb(NEW) -> col
+                THEN '"[changed]"'::jsonb
+                ELSE '"[redacted]"'::jsonb END);
+        END IF;
+    END LOOP;
+    diff_before := old_row;
+    diff_after := new_row;
+    IF TG_OP = 'UPDATE' THEN
+        SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, new_row -> o.key)
+        INTO diff_before, diff_after
+        FROM jsonb_each(old_row) o
+        WHERE new_row -> o.key IS DISTINCT FROM o.value;
+        IF diff_before IS NULL THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+    INSERT INTO audit_log (actor_id, action, entity, entity_id, before, after, request_id)
+    VALUES (
+        NULLIF(current_setting('audit.actor_id', true), '')::bigint,
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        TG_TABLE_NAME,
+        (COALESCE(new_row, old_row) ->> 'id')::bigint,
+        diff_before,
+        diff_after,
+        NULLIF(current_setting('audit.request_id', true), '')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER user_audit ON "user";
+
+CREATE TRIGGER user_audit AFTER INSERT OR UPDATE OR DELETE ON "user"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('password', 'email', 'email_index', '-email_key_id', '-email_ciphertext');
+
+UPDATE "audit_log" SET "before" = jsonb_set("before", '{email}', '"[redacted]"')
+WHERE "entity" = 'user' AND "before" ? 'email';
+
+UPDATE "audit_log" SET "after" = jsonb_set("after", '{email}', '"[redacted]"')
+WHERE "entity" = 'user' AND "after" ? 'email';
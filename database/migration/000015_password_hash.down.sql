@@ -0,0 +1 @@
+ALTER TABLE "user" ALTER COLUMN "password" TYPE varchar(128);
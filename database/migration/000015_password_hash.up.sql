@@ -0,0 +1,2 @@
+-- Encoded hashes name their algorithm and parameters, leave room for stronger ones.
+ALTER TABLE "user" ALTER COLUMN "password" TYPE varchar(255);
@@ -0,0 +1,2 @@
+ALTER TABLE "animal" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "animal" DROP COLUMN IF EXISTS "updated_at";
@@ -0,0 +1,3 @@
+/* Deleted animals and their sightings are hidden but kept for the audit log and can be restored */
+ALTER TABLE "animal" ADD COLUMN "updated_at" timestamptz;
+ALTER TABLE "animal" ADD COLUMN "deleted_at" timestamptz;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS animal_lower_name_idx;
+DROP INDEX IF EXISTS sighting_animal_id_spotting_timestamp_idx;
@@ -0,0 +1,4 @@
+/* The animal list joins each animal to its latest sighting */
+CREATE INDEX sighting_animal_id_spotting_timestamp_idx ON "sighting" ("animal_id", "spotting_timestamp" DESC, "id" DESC);
+
+CREATE INDEX animal_lower_name_idx ON "animal" (lower("name") text_pattern_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS animal_variant_trgm_idx;
+DROP INDEX IF EXISTS animal_name_trgm_idx;
+DROP INDEX IF EXISTS animal_search_idx;
@@ -0,0 +1,13 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+/* Full-text search over the name, variant and description, weighted in that order. Queries must repeat the expression */
+CREATE INDEX animal_search_idx ON "animal" USING gin ((
+    setweight(to_tsvector('english', "name"), 'A') ||
+    setweight(to_tsvector('english', "variant"), 'B') ||
+    setweight(to_tsvector('english', coalesce("description", '')), 'C')
+));
+
+/* Trigram indexes find misspelled names and variants */
+CREATE INDEX animal_name_trgm_idx ON "animal" USING gin ("name" gin_trgm_ops);
+
+CREATE INDEX animal_variant_trgm_idx ON "animal" USING gin ("variant" gin_trgm_ops);
@@ -0,0 +1,26 @@
+DROP INDEX IF EXISTS taxon_name_trgm_idx;
+DROP INDEX IF EXISTS animal_search_idx;
+
+ALTER TABLE "animal" ADD COLUMN "type" varchar(30) NOT NULL DEFAULT 'tiger';
+ALTER TABLE "animal" ADD COLUMN "variant" varchar(30) NOT NULL DEFAULT 'bengal tiger';
+
+UPDATE "animal" a SET
+    "type" = s."name",
+    "variant" = COALESCE((SELECT ss."name" FROM "taxon" ss WHERE ss."id" = a."subspecies_id"), '')
+FROM "taxon" s
+WHERE s."id" = a."species_id";
+
+ALTER TABLE "animal" DROP COLUMN "subspecies_id";
+ALTER TABLE "animal" DROP COLUMN "species_id";
+
+ALTER TABLE "animal" ADD CONSTRAINT unique_constraint_name UNIQUE (name, type, variant);
+
+CREATE INDEX animal_search_idx ON "animal" USING gin ((
+    setweight(to_tsvector('english', "name"), 'A') ||
+    setweight(to_tsvector('english', "variant"), 'B') ||
+    setweight(to_tsvector('english', coalesce("description", '')), 'C')
+));
+
+CREATE INDEX animal_variant_trgm_idx ON "animal" USING gin ("variant" gin_trgm_ops);
+
+DROP TABLE IF EXISTS "taxon";
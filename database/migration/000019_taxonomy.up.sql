@@ -0,0 +1,100 @@
+/*
+ Species and their subspecies. Names are the lower case keys animals are given as type and variant, common names
+ map BCP 47 language tags to the name in that language and the conservation status is an IUCN Red List category.
+ */
+CREATE TABLE "taxon" (
+                       "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                       "parent_id" bigint,
+                       "name" varchar(30) NOT NULL,
+                       "scientific_name" varchar(100) UNIQUE,
+                       "common_names" jsonb NOT NULL DEFAULT '{}',
+                       "conservation_status" varchar(2),
+                       "created_at" timestamptz NOT NULL DEFAULT (now()),
+                       "updated_at" timestamptz,
+                       CONSTRAINT taxon_name_check CHECK ("name" <> '' AND "name" = lower(btrim("name"))),
+                       CONSTRAINT taxon_conservation_status_check
+                           CHECK ("conservation_status" IN ('LC', 'NT', 'VU', 'EN', 'CR', 'EW', 'EX', 'DD', 'NE')),
+                       CONSTRAINT taxon_id_parent_id_key UNIQUE ("id", "parent_id")
+);
+
+ALTER TABLE "taxon" ADD FOREIGN KEY ("parent_id") REFERENCES "taxon" ("id");
+
+/* Species are unique by name, subspecies by name within their species */
+CREATE UNIQUE INDEX taxon_species_name_idx ON "taxon" ("name") WHERE "parent_id" IS NULL;
+
+CREATE UNIQUE INDEX taxon_subspecies_name_idx ON "taxon" ("parent_id", "name") WHERE "parent_id" IS NOT NULL;
+
+CREATE TRIGGER taxon_audit AFTER INSERT OR UPDATE OR DELETE ON "taxon"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+INSERT INTO "taxon" ("name", "scientific_name", "common_names", "conservation_status")
+VALUES ('tiger', 'Panthera tigris', '{"en": "Tiger", "hi": "बाघ"}', 'EN');
+
+INSERT INTO "taxon" ("parent_id", "name", "scientific_name", "common_names")
+SELECT "id", 'bengal tiger', 'Panthera tigris tigris', '{"en": "Bengal tiger", "hi": "बंगाल टाइगर"}'
+FROM "taxon" WHERE "name" = 'tiger';
+
+/* The free text type and variant become references. The unique constraint on them cannot hold while they are normalised. */
+ALTER TABLE "animal" DROP CONSTRAINT unique_constraint_name;
+
+UPDATE "animal" SET
+    "type" = lower(btrim(regexp_replace("type", '\s+', ' ', 'g'))),
+    "variant" = lower(btrim(regexp_replace("variant", '\s+', ' ', 'g')));
+
+UPDATE "animal" SET "type" = 'tiger' WHERE "type" = '';
+
+/* Variants named without their species, such as bengal, take the full name when it is known, as bengal tiger */
+UPDATE "animal" a SET "variant" = a."variant" || ' ' || a."type"
+WHERE a."variant" <> '' AND a."variant" NOT LIKE '% ' || a."type"
+  AND (EXISTS (SELECT 1 FROM "animal" b WHERE b."type" = a."type" AND b."variant" = a."variant" || ' ' || a."type")
+    OR EXISTS (SELECT 1 FROM "taxon" s JOIN "taxon" ss ON ss."parent_id" = s."id"
+               WHERE s."name" = a."type" AND ss."name" = a."variant" || ' ' || a."type"));
+
+/* Animals that only differed by spelling keep the oldest one's name, the others are told apart by their id */
+UPDATE "animal" a SET "name" = left(a."name", 30 - length(' #' || a."id")) || ' #' || a."id"
+WHERE EXISTS (SELECT 1 FROM "animal" b
+              WHERE b."id" < a."id" AND b."name" = a."name" AND b."type" = a."type" AND b."variant" = a."variant");
+
+INSERT INTO "taxon" ("name")
+SELECT DISTINCT "type" FROM "animal"
+WHERE NOT EXISTS (SELECT 1 FROM "taxon" t WHERE t."parent_id" IS NULL AND t."name" = "animal"."type");
+
+INSERT INTO "taxon" ("parent_id", "name")
+SELECT DISTINCT s."id", a."variant" FROM "animal" a
+JOIN "taxon" s ON s."parent_id" IS NULL AND s."name" = a."type"
+WHERE a."variant" <> ''
+  AND NOT EXISTS (SELECT 1 FROM "taxon" t WHERE t."parent_id" = s."id" AND t."name" = a."variant");
+
+ALTER TABLE "animal" ADD COLUMN "species_id" bigint;
+ALTER TABLE "animal" ADD COLUMN "subspecies_id" bigint;
+
+UPDATE "animal" a SET
+    "species_id" = s."id",
+    "subspecies_id" = (SELECT ss."id" FROM "taxon" ss WHERE ss."parent_id" = s."id" AND ss."name" = a."variant")
+FROM "taxon" s
+WHERE s."parent_id" IS NULL AND s."name" = a."type";
+
+ALTER TABLE "animal" ALTER COLUMN "species_id" SET NOT NULL;
+
+ALTER TABLE "animal" ADD FOREIGN KEY ("species_id") REFERENCES "taxon" ("id");
+
+/* The subspecies has to belong to the species */
+ALTER TABLE "animal" ADD CONSTRAINT animal_subspecies_id_species_id_fkey
+    FOREIGN KEY ("subspecies_id", "species_id") REFERENCES "taxon" ("id", "parent_id");
+
+CREATE UNIQUE INDEX animal_name_species_id_subspecies_id_idx ON "animal" ("name", "species_id", COALESCE("subspecies_id", 0));
+
+CREATE INDEX animal_species_id_idx ON "animal" ("species_id");
+
+CREATE INDEX animal_subspecies_id_idx ON "animal" ("subspecies_id");
+
+/* Dropping the variant drops the indexes over it, search matches variants through the taxon table instead */
+ALTER TABLE "animal" DROP COLUMN "type";
+ALTER TABLE "animal" DROP COLUMN "variant";
+
+CREATE INDEX animal_search_idx ON "animal" USING gin ((
+    setweight(to_tsvector('english', "name"), 'A') ||
+    setweight(to_tsvector('english', coalesce("description", '')), 'C')
+));
+
+CREATE INDEX taxon_name_trgm_idx ON "taxon" USING gin ("name" gin_trgm_ops);
@@ -0,0 +1,5 @@
+ALTER TABLE "animal" DROP COLUMN "litter_id";
+ALTER TABLE "animal" DROP COLUMN "father_id";
+ALTER TABLE "animal" DROP COLUMN "mother_id";
+
+DROP TABLE "litter";
@@ -0,0 +1,41 @@
+/* A litter is the cubs a mother gave birth to at once. The father is often unknown. */
+CREATE TABLE "litter" (
+                        "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                        "mother_id" bigint NOT NULL,
+                        "father_id" bigint,
+                        "date_of_birth" date NOT NULL,
+                        "created_at" timestamptz NOT NULL DEFAULT (now()),
+                        CONSTRAINT litter_parents_check CHECK ("father_id" <> "mother_id"),
+                        CONSTRAINT litter_id_mother_id_key UNIQUE ("id", "mother_id")
+);
+
+ALTER TABLE "litter" ADD FOREIGN KEY ("mother_id") REFERENCES "animal" ("id");
+
+ALTER TABLE "litter" ADD FOREIGN KEY ("father_id") REFERENCES "animal" ("id");
+
+CREATE INDEX litter_mother_id_idx ON "litter" ("mother_id");
+
+CREATE TRIGGER litter_audit AFTER INSERT OR UPDATE OR DELETE ON "litter"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+/* Cycles and the order of dates of birth are checked by the application, which can tell why they are wrong */
+ALTER TABLE "animal" ADD COLUMN "mother_id" bigint;
+ALTER TABLE "animal" ADD COLUMN "father_id" bigint;
+ALTER TABLE "animal" ADD COLUMN "litter_id" bigint;
+
+ALTER TABLE "animal" ADD CONSTRAINT animal_parents_check
+    CHECK ("mother_id" <> "id" AND "father_id" <> "id" AND "father_id" <> "mother_id");
+
+ALTER TABLE "animal" ADD FOREIGN KEY ("mother_id") REFERENCES "animal" ("id");
+
+ALTER TABLE "animal" ADD FOREIGN KEY ("father_id") REFERENCES "animal" ("id");
+
+/* A cub of a litter is a child of the litter's mother */
+ALTER TABLE "animal" ADD CONSTRAINT animal_litter_id_mother_id_fkey
+    FOREIGN KEY ("litter_id", "mother_id") REFERENCES "litter" ("id", "mother_id");
+
+CREATE INDEX animal_mother_id_idx ON "animal" ("mother_id");
+
+CREATE INDEX animal_father_id_idx ON "animal" ("father_id");
+
+CREATE INDEX animal_litter_id_idx ON "animal" ("litter_id");
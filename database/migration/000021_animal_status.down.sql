@@ -0,0 +1,3 @@
+DROP TABLE "animal_status_history";
+
+ALTER TABLE "animal" DROP COLUMN "status";
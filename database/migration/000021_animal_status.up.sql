@@ -0,0 +1,27 @@
+/* Transitions between statuses are checked by the application, deceased is final */
+ALTER TABLE "animal" ADD COLUMN "status" varchar(15) NOT NULL DEFAULT 'active';
+
+ALTER TABLE "animal" ADD CONSTRAINT animal_status_check
+    CHECK ("status" IN ('active', 'missing', 'rehabilitation', 'relocated', 'deceased'));
+
+CREATE INDEX animal_status_idx ON "animal" ("status");
+
+/* Every change of status with who made it, why and the sighting that backs it up, if any */
+CREATE TABLE "animal_status_history" (
+                                       "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                                       "animal_id" bigint NOT NULL,
+                                       "actor_id" bigint,
+                                       "from_status" varchar(15) NOT NULL,
+                                       "to_status" varchar(15) NOT NULL,
+                                       "reason" varchar(255) NOT NULL,
+                                       "sighting_id" bigint,
+                                       "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "animal_status_history" ADD FOREIGN KEY ("animal_id") REFERENCES "animal" ("id");
+
+ALTER TABLE "animal_status_history" ADD FOREIGN KEY ("actor_id") REFERENCES "user" ("id");
+
+ALTER TABLE "animal_status_history" ADD FOREIGN KEY ("sighting_id") REFERENCES "sighting" ("id");
+
+CREATE INDEX animal_status_history_animal_id_created_at_idx ON "animal_status_history" ("animal_id", "created_at" DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE "animal" ALTER CONSTRAINT animal_litter_id_mother_id_fkey NOT DEFERRABLE;
+
+ALTER TABLE "animal" DROP COLUMN "merged_into_id";
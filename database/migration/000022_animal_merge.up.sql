@@ -0,0 +1,12 @@
+/* A merged animal stays behind as a deleted alias, lookups of it lead to the animal it was merged into */
+ALTER TABLE "animal" ADD COLUMN "merged_into_id" bigint;
+
+ALTER TABLE "animal" ADD CONSTRAINT animal_merged_into_id_check
+    CHECK ("merged_into_id" IS NULL OR ("merged_into_id" <> "id" AND "deleted_at" IS NOT NULL));
+
+ALTER TABLE "animal" ADD FOREIGN KEY ("merged_into_id") REFERENCES "animal" ("id");
+
+CREATE INDEX animal_merged_into_id_idx ON "animal" ("merged_into_id");
+
+/* A merge hands the litters of the duplicate and their cubs over to the animal, which the check defers till commit */
+ALTER TABLE "animal" ALTER CONSTRAINT animal_litter_id_mother_id_fkey DEFERRABLE INITIALLY IMMEDIATE;
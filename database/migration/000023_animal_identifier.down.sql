@@ -0,0 +1 @@
+DROP TABLE "animal_identifier";
@@ -0,0 +1,22 @@
+/* Stripe pattern IDs, radio collar IDs, IDs in other registries and local nicknames of animals. Values are matched
+   ignoring case and each can only be given to one animal per scheme, deleted animals included as they can be restored */
+CREATE TABLE "animal_identifier" (
+                                   "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+                                   "animal_id" bigint NOT NULL,
+                                   "scheme" varchar(20) NOT NULL,
+                                   "value" varchar(50) NOT NULL,
+                                   "created_at" timestamptz NOT NULL DEFAULT (now()),
+                                   CONSTRAINT animal_identifier_scheme_check
+                                       CHECK ("scheme" IN ('collar', 'stripe_id', 'external_registry', 'nickname'))
+);
+
+ALTER TABLE "animal_identifier" ADD FOREIGN KEY ("animal_id") REFERENCES "animal" ("id");
+
+CREATE UNIQUE INDEX animal_identifier_scheme_value_idx ON "animal_identifier" ("scheme", lower("value"));
+
+CREATE INDEX animal_identifier_lower_value_idx ON "animal_identifier" (lower("value"));
+
+CREATE INDEX animal_identifier_animal_id_idx ON "animal_identifier" ("animal_id");
+
+CREATE TRIGGER animal_identifier_audit AFTER INSERT OR UPDATE OR DELETE ON "animal_identifier"
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change();
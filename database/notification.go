@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+const sightingNotificationMessage = "An animal you reported has been sighted again"
+
+var ErrNotFound = errors.New("Record not found")
+
+type INotification interface {
+	CreateNotifications(event *model.SightingEvent) error
+	ListNotifications(userId int64, unreadOnly bool, limit string, offset string) ([]model.Notification, error)
+	MarkNotificationRead(userId int64, notificationId int64) error
+	MarkAllNotificationsRead(userId int64) (int64, error)
+	UnreadNotificationCount(userId int64) (int64, error)
+}
+
+type NotificationDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewNotificationDB(pool *pgxpool.Pool) *NotificationDB {
+	return &NotificationDB{
+		pool: pool,
+	}
+}
+
+func (db *NotificationDB) CreateNotifications(event *model.SightingEvent) error {
+	if len(event.Recipients) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, recipient := range event.Recipients {
+		batch.Queue(
+			`INSERT INTO notification (user_id, animal_id, sighting_id, message) VALUES($1, $2, $3, $4)`,
+			recipient.UserID, event.AnimalID, event.SightingID, sightingNotificationMessage)
+	}
+	results := db.pool.SendBatch(context.Background(), batch)
+	defer results.Close()
+	for range event.Recipients {
+		if _, err := results.Exec(); err != nil {
+			logger.LogError(err)
+			return fmt.Errorf("Failed to create notification: %w", err)
+		}
+	}
+	logger.LogInfo("Created notifications for sighting", event.SightingID)
+	return nil
+}
+
+func (db *NotificationDB) ListNotifications(userId int64, unreadOnly bool, limit string, offset string) ([]model.Notification, error) {
+	sqlQuery := `
+		SELECT n.id, n.animal_id, n.sighting_id, n.message,
+		COALESCE(TO_CHAR(n.read_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), TO_CHAR(n.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM notification n
+		WHERE n.user_id = $1
+	`
+	if unreadOnly {
+		sqlQuery += " AND n.read_at IS NULL"
+	}
+	sqlQuery += " ORDER BY n.created_at DESC, n.id DESC"
+	sqlQuery += " LIMIT $2 OFFSET $3"
+	rows, err := db.pool.Query(context.Background(), sqlQuery, userId, limit, offset)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	responseArray := make([]model.Notification, 0)
+	for rows.Next() {
+		var response model.Notification
+		err = rows.Scan(
+			&response.ID,
+			&response.AnimalID,
+			&response.SightingID,
+			&response.Message,
+			&response.ReadAt,
+			&response.CreatedAt,
+		)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		response.UserID = userId
+		response.Read = response.ReadAt != ""
+		responseArray = append(responseArray, response)
+	}
+	logger.LogInfo("Retrieved notifications for user", userId)
+	return responseArray, nil
+}
+
+func (db *NotificationDB) MarkNotificationRead(userId int64, notificationId int64) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`UPDATE notification SET read_at = COALESCE(read_at, now()) WHERE id = $1 AND user_id = $2`,
+		notificationId, userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *NotificationDB) MarkAllNotificationsRead(userId int64) (int64, error) {
+	tag, err := db.pool.Exec(context.Background(),
+		`UPDATE notification SET read_at = now() WHERE user_id = $1 AND read_at IS NULL`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db *NotificationDB) UnreadNotificationCount(userId int64) (int64, error) {
+	var count int64
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM notification WHERE user_id = $1 AND read_at IS NULL`,
+		userId).Scan(&count)
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/pii"
+)
+
+// Labels the encrypted emails are bound to, a ciphertext only opens in the column it was written to.
+const (
+	userEmailLabel     = "user.email"
+	identityEmailLabel = "user_identity.email"
+
+	// emailColumns are the columns an email is read from: the key ID and ciphertext or, until the re-encryption
+	// job has reached the row, the legacy plain text email.
+	emailColumns = "email_key_id, email_ciphertext, email"
+)
+
+type IPII interface {
+	ReencryptPII(batchSize int) (int64, error)
+	DecryptPII(batchSize int) (int64, error)
+}
+
+type PIIDB struct {
+	pool *pgxpool.Pool
+	keys *pii.Keyring
+	// skipped holds the IDs of the rows of each table that failed to re-encrypt, they are left out until restart.
+	skipped map[string][]int64
+}
+
+func NewPIIDB(pool *pgxpool.Pool, keys *pii.Keyring) *PIIDB {
+	return &PIIDB{
+		pool:    pool,
+		keys:    keys,
+		skipped: make(map[string][]int64),
+	}
+}
+
+// sealedEmail holds the emailColumns of a row.
+type sealedEmail struct {
+	keyID      *string
+	ciphertext []byte
+	legacy     *string
+}
+
+func openEmail(keys *pii.Keyring, label string, email sealedEmail) (string, error) {
+	if email.keyID == nil {
+		if email.legacy != nil {
+			return *email.legacy, nil
+		}
+		return "", nil
+	}
+	value, err := keys.Open(label, *email.keyID, email.ciphertext)
+	if err != nil {
+		logger.LogError(fmt.Errorf("Failed to decrypt %s: %w", label, err))
+		return "", err
+	}
+	return value, nil
+}
+
+// sealUserEmail encrypts a user's email and computes its blind index.
+func sealUserEmail(keys *pii.Keyring, email string) (string, []byte, []byte, error) {
+	keyID, ciphertext, err := keys.Seal(userEmailLabel, email)
+	if err != nil {
+		logger.LogError(err)
+		return "", nil, nil, fmt.Errorf("Failed to encrypt email: %w", err)
+	}
+	return keyID, ciphertext, userEmailIndex(keys, email), nil
+}
+
+func userEmailIndex(keys *pii.Keyring, email string) []byte {
+	return keys.BlindIndex(userEmailLabel, pii.NormalizeEmail(email))
+}
+
+// ReencryptPII encrypts up to batchSize plain text or not actively encrypted emails of each table with the active
+// key and returns how many it re-encrypted. It is run until it returns 0 after the active key changes.
+// Rows are locked with SKIP LOCKED so that several instances can run it at the same time. Rows that fail, e.g. because
+// another user has the same email, are logged and skipped without failing the rest of the batch.
+func (db *PIIDB) ReencryptPII(batchSize int) (int64, error) {
+	users, err := db.reencrypt(batchSize, `"user"`, userEmailLabel, true)
+	if err != nil {
+		return users, err
+	}
+	identities, err := db.reencrypt(batchSize, "user_identity", identityEmailLabel, false)
+	return users + identities, err
+}
+
+func (db *PIIDB) reencrypt(batchSize int, table string, label string, indexed bool) (int64, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, model.Actor{RequestID: "pii-reencryption"})
+	if err != nil {
+		return 0, err
+	}
+	defer rollbackAudited(ctx, tx)
+	skipped := db.skipped[table]
+	if skipped == nil {
+		skipped = []int64{}
+	}
+	rows, err := tx.Query(ctx,
+		`SELECT id, `+emailColumns+` FROM `+table+`
+		WHERE (email IS NOT NULL OR email_key_id <> $1) AND id <> ALL($3)
+		LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		db.keys.ActiveKeyID(), batchSize, skipped)
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	emails := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var email sealedEmail
+		if err = rows.Scan(&id, &email.keyID, &email.ciphertext, &email.legacy); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return 0, err
+		}
+		value, err := openEmail(db.keys, label, email)
+		if err != nil {
+			db.skip(table, id)
+			continue
+		}
+		emails[id] = value
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	var reencrypted int64
+	for id, email := range emails {
+		if err = db.reencryptRow(ctx, tx, table, label, indexed, id, email); err != nil {
+			db.skip(table, id)
+			continue
+		}
+		reencrypted++
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction")
+	}
+	if reencrypted > 0 {
+		logger.LogInfo("Re-encrypted", reencrypted, "emails of", table, "with key", db.keys.ActiveKeyID())
+	}
+	return reencrypted, nil
+}
+
+// reencryptRow encrypts the email of a row with the active key in a savepoint, so that a failing row does not abort
+// the transaction of the batch.
+func (db *PIIDB) reencryptRow(ctx context.Context, tx pgx.Tx, table string, label string, indexed bool, id int64, email string) error {
+	keyID, ciphertext, err := db.keys.Seal(label, email)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	var index []byte
+	if indexed && email != "" {
+		index = db.keys.BlindIndex(label, pii.NormalizeEmail(email))
+	}
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	defer savepoint.Rollback(ctx)
+	_, err = savepoint.Exec(ctx,
+		`UPDATE `+table+` SET email_key_id = $2, email_ciphertext = $3, email = NULL WHERE id = $1`,
+		id, keyID, ciphertext)
+	if err == nil && indexed {
+		_, err = savepoint.Exec(ctx, `UPDATE "user" SET email_index = $2 WHERE id = $1`, id, index)
+	}
+	if err == nil {
+		err = savepoint.Commit(ctx)
+	}
+	if err != nil {
+		logger.LogError(fmt.Errorf("Failed to re-encrypt the email of %s %d: %w", table, id, err))
+		return err
+	}
+	return nil
+}
+
+// skip leaves the row out of the next batches of ReencryptPII.
+func (db *PIIDB) skip(table string, id int64) {
+	db.skipped[table] = append(db.skipped[table], id)
+	logger.LogInfo("Skipping the email of", table, id, "until restart")
+}
+
+// DecryptPII writes up to batchSize encrypted emails of each table back to the plain text email column and returns
+// how many it decrypted. It is run until it returns 0 before migrating down past the encryption, in place of
+// ReencryptPII, which would encrypt them again.
+func (db *PIIDB) DecryptPII(batchSize int) (int64, error) {
+	users, err := db.decrypt(batchSize, `"user"`, userEmailLabel)
+	if err != nil {
+		return users, err
+	}
+	identities, err := db.decrypt(batchSize, "user_identity", identityEmailLabel)
+	return users + identities, err
+}
+
+func (db *PIIDB) decrypt(batchSize int, table string, label string) (int64, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, model.Actor{RequestID: "pii-decryption"})
+	if err != nil {
+		return 0, err
+	}
+	defer rollbackAudited(ctx, tx)
+	rows, err := tx.Query(ctx,
+		`SELECT id, `+emailColumns+` FROM `+table+`
+		WHERE email_key_id IS NOT NULL
+		LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		batchSize)
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	emails := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var email sealedEmail
+		if err = rows.Scan(&id, &email.keyID, &email.ciphertext, &email.legacy); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return 0, err
+		}
+		if emails[id], err = openEmail(db.keys, label, email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+	}
+	rows.Close()
+	for id, email := range emails {
+		_, err = tx.Exec(ctx,
+			`UPDATE `+table+` SET email = $2, email_key_id = NULL, email_ciphertext = NULL WHERE id = $1`,
+			id, email)
+		if err != nil {
+			logger.LogError(err)
+			return 0, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction")
+	}
+	if len(emails) > 0 {
+		logger.LogInfo("Decrypted", len(emails), "emails of", table)
+	}
+	return int64(len(emails)), nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"tigerhall-kittens/pii"
+)
+
+func testKeyring(t *testing.T) *pii.Keyring {
+	keys, err := pii.NewKeyring(map[string][]byte{"test": bytes.Repeat([]byte{1}, pii.KeySize)}, "test",
+		bytes.Repeat([]byte{2}, pii.KeySize))
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	return keys
+}
+
+func TestOpenEmail(t *testing.T) {
+	keys := testKeyring(t)
+	keyID, ciphertext, index, err := sealUserEmail(keys, "Reporter@Example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, userEmailIndex(keys, " reporter@example.com"), index, "Index should ignore case and spaces")
+
+	email, err := openEmail(keys, userEmailLabel, sealedEmail{keyID: &keyID, ciphertext: ciphertext})
+	assert.NoError(t, err)
+	assert.Equal(t, "Reporter@Example.com", email)
+
+	_, err = openEmail(keys, identityEmailLabel, sealedEmail{keyID: &keyID, ciphertext: ciphertext})
+	assert.ErrorIs(t, err, pii.ErrInvalidCiphertext, "Email should only open in its own column")
+
+	legacy := "legacy@example.com"
+	email, err = openEmail(keys, userEmailLabel, sealedEmail{legacy: &legacy})
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, email, "Rows not yet re-encrypted should return the plain text email")
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/ratelimit"
+	"time"
+)
+
+// RateLimitDB keeps rate limit buckets in Postgres so that instances behind a load balancer share them.
+// It implements ratelimit.Store.
+type RateLimitDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewRateLimitDB(pool *pgxpool.Pool) *RateLimitDB {
+	return &RateLimitDB{
+		pool: pool,
+	}
+}
+
+// Take locks the bucket while a token is taken so that concurrent requests cannot take the same token.
+func (db *RateLimitDB) Take(key string, policy ratelimit.Policy, now time.Time) (ratelimit.Result, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	var bucket ratelimit.Bucket
+	err = tx.QueryRow(ctx,
+		`SELECT tokens, updated_at FROM rate_limit_bucket WHERE key = $1 AND full_at > $2 FOR UPDATE`,
+		key, now).Scan(&bucket.Tokens, &bucket.UpdatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.LogError(err)
+		return ratelimit.Result{}, err
+	}
+	result := policy.Take(&bucket, now)
+	_, err = tx.Exec(ctx,
+		`INSERT INTO rate_limit_bucket (key, tokens, updated_at, full_at) VALUES($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at, full_at = EXCLUDED.full_at`,
+		key, bucket.Tokens, bucket.UpdatedAt, now.Add(result.Reset))
+	if err != nil {
+		logger.LogError(err)
+		return ratelimit.Result{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return ratelimit.Result{}, fmt.Errorf("Failed to commit transaction")
+	}
+	return result, nil
+}
+
+// PurgeFullBuckets deletes the buckets that have refilled completely, they are the same as missing ones.
+func (db *RateLimitDB) PurgeFullBuckets() (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM rate_limit_bucket WHERE full_at <= now()`)
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"math"
-	"strings"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/model"
 )
@@ -17,9 +16,11 @@ const earthRadiusKm = 6371.0
 const thresholdDistanceInKms = 5
 
 type ISighting interface {
-	CreateSighting(sighting *model.SightingReqResp) (*model.SightingReqResp, error)
-	ListSightingInfo(animalId int64, limit string, offset string) ([]model.SightingReqResp, error)
-	SpottedByEmailIds(animalId int64) (string, error)
+	CreateSighting(sighting *model.SightingReqResp, allowDeceased bool, actor model.Actor) (*model.SightingReqResp, error)
+	ListSightingInfo(filter model.SightingFilter) (*model.Page[model.SightingReqResp], error)
+	UpdateSighting(sightingId int64, update *model.SightingUpdate, actor model.Actor) (*model.SightingReqResp, error)
+	DeleteSighting(sightingId int64, actor model.Actor) error
+	SpottedBy(animalId int64) ([]model.Recipient, error)
 }
 
 type SightingDB struct {
@@ -32,11 +33,29 @@ func NewSightingDB(pool *pgxpool.Pool) *SightingDB {
 	}
 }
 
-func (db *SightingDB) CreateSighting(sightingReq *model.SightingReqResp) (*model.SightingReqResp, error) {
+// CreateSighting records a sighting of an animal that is not deleted, or ErrNotFound. A sighting of an animal that
+// was merged into another is recorded on that animal, and the returned sighting carries its id. Sightings of deceased
+// animals yield ErrAnimalDeceased unless allowDeceased. The animal is locked until the sighting is committed, so that
+// its status cannot change to deceased in between.
+func (db *SightingDB) CreateSighting(sightingReq *model.SightingReqResp, allowDeceased bool, actor model.Actor) (*model.SightingReqResp, error) {
 	ctx := context.Background()
-	tx, err := db.pool.Begin(ctx)
+	tx, err := beginAudited(ctx, db.pool, actor)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to begin transaction")
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var status model.AnimalStatus
+	err = tx.QueryRow(ctx, `SELECT id, status FROM animal WHERE id = `+sightingAnimalId+` AND deleted_at IS NULL FOR UPDATE`,
+		sightingReq.AnimalID).Scan(&sightingReq.AnimalID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if status == model.AnimalStatusDeceased && !allowDeceased {
+		return nil, ErrAnimalDeceased
 	}
 	lastLocation, err := db.GetLastLocation(sightingReq.AnimalID)
 	if err != nil {
@@ -137,37 +156,53 @@ func toRadians(deg float64) float64 {
 	return deg * (math.Pi / 180)
 }
 
-func (db *SightingDB) ListSightingInfo(animalId int64, limit string, offset string) ([]model.SightingReqResp, error) {
+var sightingKeyset = keyset{
+	column:  "s.spotting_timestamp",
+	key:     fmt.Sprintf(cursorTimestampKey, "s.spotting_timestamp"),
+	keyType: "timestamptz",
+	id:      "s.id",
+}
+
+// sightingAnimalId is the animal given as $1, or the animal it was merged into.
+const sightingAnimalId = `(SELECT COALESCE(merged_into_id, id) FROM animal WHERE id = $1)`
+
+// ListSightingInfo returns a page of the sightings of an animal, latest first. Animals that were merged into another
+// list the sightings of that animal.
+func (db *SightingDB) ListSightingInfo(filter model.SightingFilter) (*model.Page[model.SightingReqResp], error) {
+	ctx := context.Background()
 	sqlQuery := `
-		SELECT a.id, s.location[0] as longitude, s.location[1] as latitude, TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), i.filename, i.type, i.data
+		SELECT a.id, s.id, s.location[0] as longitude, s.location[1] as latitude, TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), i.filename, i.type, i.data,
+		` + sightingKeyset.key + `
 		FROM animal a
 		JOIN sighting s ON a.id = s.animal_id
 		LEFT OUTER JOIN image i ON s.image_id = i.id
 	`
 	params := make([]interface{}, 0)
-	sqlQuery += " WHERE a.id = $1"
-	params = append(params, animalId)
-	sqlQuery += " ORDER BY s.spotting_timestamp DESC"
-	sqlQuery += " LIMIT $2 OFFSET $3"
-	params = append(params, limit, offset)
-	rows, err := db.pool.Query(context.Background(), sqlQuery, params...)
+	sqlQuery += " WHERE a.id = " + sightingAnimalId + " AND a.deleted_at IS NULL"
+	params = append(params, filter.AnimalID)
+	sqlQuery, params = sightingKeyset.page(sqlQuery, params, filter.Cursor, true, filter.Limit)
+	rows, err := db.pool.Query(ctx, sqlQuery, params...)
 	if err != nil {
 		logger.LogError(err)
 		return nil, err
 	}
 	defer rows.Close()
 	var responseArray []model.SightingReqResp
+	var keys []model.Cursor
 	for rows.Next() {
 		var response model.SightingReqResp
 		var nullFileName, nullType sql.NullString
+		key := model.Cursor{Sort: SightingSortTimestamp, Descending: true}
 		err = rows.Scan(
 			&response.AnimalID,
+			&response.Sighting.ID,
 			&response.Sighting.Location.Longitude,
 			&response.Sighting.Location.Latitude,
 			&response.Sighting.SpottingTimestamp,
 			&nullFileName,
 			&nullType,
 			&response.Sighting.Image.Data,
+			&key.Key,
 		)
 		if err != nil {
 			logger.LogError(err)
@@ -183,15 +218,35 @@ func (db *SightingDB) ListSightingInfo(animalId int64, limit string, offset stri
 		} else {
 			response.Sighting.Image.Type = ""
 		}
+		key.ID = response.Sighting.ID
 		responseArray = append(responseArray, response)
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	page := pageOf(responseArray, keys, filter.Cursor, filter.Limit)
+	if filter.WithTotal {
+		var total int64
+		err = db.pool.QueryRow(ctx, `
+			SELECT COUNT(*)
+			FROM animal a
+			JOIN sighting s ON a.id = s.animal_id
+			WHERE a.id = `+sightingAnimalId+` AND a.deleted_at IS NULL`, filter.AnimalID).Scan(&total)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		page.Total = &total
 	}
 	logger.LogInfo("Retrieved animal list info")
-	return responseArray, nil
+	return page, nil
 }
 
-func (db *SightingDB) SpottedByEmailIds(animalId int64) (string, error) {
+func (db *SightingDB) SpottedBy(animalId int64) ([]model.Recipient, error) {
 	sqlQuery := `
-		SELECT distinct u.email
+		SELECT distinct u.id
 		FROM animal a
 		JOIN sighting s ON a.id = s.animal_id
 		JOIN "user" u ON s.reporter = u.id
@@ -202,19 +257,19 @@ func (db *SightingDB) SpottedByEmailIds(animalId int64) (string, error) {
 	rows, err := db.pool.Query(context.Background(), sqlQuery, params...)
 	if err != nil {
 		logger.LogError(err)
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
-	var emailList []string
+	var recipients []model.Recipient
 	for rows.Next() {
-		var email string
-		err = rows.Scan(&email)
+		var recipient model.Recipient
+		err = rows.Scan(&recipient.UserID)
 		if err != nil {
 			logger.LogError(err)
-			return "", err
+			return nil, err
 		}
-		emailList = append(emailList, email)
+		recipients = append(recipients, recipient)
 	}
-	logger.LogInfo("Retrieved email addresses of users who spotted the animal")
-	return strings.Join(emailList, ","), nil
+	logger.LogInfo("Retrieved users who spotted the animal")
+	return recipients, nil
 }
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+// UpdateSighting corrects the location and spotting time of a sighting. Unlike new sightings it is not checked
+// against the distance threshold, moderators correct what was reported. Sightings of deleted animals yield
+// ErrNotFound.
+func (db *SightingDB) UpdateSighting(sightingId int64, update *model.SightingUpdate, actor model.Actor) (*model.SightingReqResp, error) {
+	var longitude, latitude *float64
+	if update.Location != nil {
+		longitude, latitude = &update.Location.Longitude, &update.Location.Latitude
+	}
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var sighting model.SightingReqResp
+	err = tx.QueryRow(ctx, `
+		UPDATE sighting s SET
+			location = CASE WHEN $2::float8 IS NULL THEN s.location ELSE point($2, $3) END,
+			spotting_timestamp = COALESCE($4::timestamptz, s.spotting_timestamp)
+		FROM animal a
+		WHERE s.id = $1 AND a.id = s.animal_id AND a.deleted_at IS NULL
+		RETURNING s.animal_id, s.id, s.location[0], s.location[1],
+			TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`,
+		sightingId, longitude, latitude, update.SpottingTimestamp).Scan(&sighting.AnimalID, &sighting.Sighting.ID,
+		&sighting.Sighting.Location.Longitude, &sighting.Sighting.Location.Latitude, &sighting.Sighting.SpottingTimestamp)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Sighting with id", sightingId, "updated")
+	return &sighting, nil
+}
+
+// DeleteSighting removes a sighting along with its image and the notifications about it. Status changes it backed
+// up are kept without it. Sightings of deleted animals yield ErrNotFound.
+func (db *SightingDB) DeleteSighting(sightingId int64, actor model.Actor) error {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return err
+	}
+	defer rollbackAudited(ctx, tx)
+	var imageId *int64
+	err = tx.QueryRow(ctx, `
+		SELECT s.image_id FROM sighting s JOIN animal a ON a.id = s.animal_id
+		WHERE s.id = $1 AND a.deleted_at IS NULL
+		FOR UPDATE OF s`,
+		sightingId).Scan(&imageId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM notification WHERE sighting_id = $1`,
+		`UPDATE animal_status_history SET sighting_id = NULL WHERE sighting_id = $1`,
+		`DELETE FROM sighting WHERE id = $1`,
+	} {
+		if _, err = tx.Exec(ctx, query, sightingId); err != nil {
+			logger.LogError(err)
+			return err
+		}
+	}
+	if imageId != nil {
+		if _, err = tx.Exec(ctx, `DELETE FROM image WHERE id = $1`, *imageId); err != nil {
+			logger.LogError(err)
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Sighting with id", sightingId, "deleted")
+	return nil
+}
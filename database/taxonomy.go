@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+var (
+	ErrUnknownTaxon = errors.New("Unknown species or subspecies")
+	ErrInUse        = errors.New("Record is in use")
+)
+
+const foreignKeyViolation = "23503"
+
+type ITaxonomy interface {
+	ListTaxa() ([]model.Taxon, error)
+	GetTaxon(taxonId int64) (*model.Taxon, error)
+	CreateTaxon(taxon *model.Taxon, actor model.Actor) (*model.Taxon, error)
+	UpdateTaxon(taxonId int64, update *model.TaxonUpdate, actor model.Actor) (*model.Taxon, error)
+	DeleteTaxon(taxonId int64, actor model.Actor) error
+}
+
+const taxonColumns = `id, parent_id, name, scientific_name, conservation_status, common_names`
+
+func scanTaxon(row pgx.Row, taxon *model.Taxon) error {
+	err := row.Scan(&taxon.ID, &taxon.ParentID, &taxon.Name, &taxon.ScientificName, &taxon.ConservationStatus, &taxon.CommonNames)
+	if err != nil {
+		return err
+	}
+	taxon.Rank = model.TaxonRankSpecies
+	if taxon.ParentID != nil {
+		taxon.Rank = model.TaxonRankSubspecies
+	}
+	if taxon.CommonNames == nil {
+		taxon.CommonNames = make(map[string]string)
+	}
+	return nil
+}
+
+type TaxonomyDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewTaxonomyDB(pool *pgxpool.Pool) *TaxonomyDB {
+	return &TaxonomyDB{
+		pool: pool,
+	}
+}
+
+// NormalizeTaxonName returns the name the way the taxonomy stores it, in lower case with single spaces.
+func NormalizeTaxonName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ListTaxa returns every species followed by its subspecies, by name.
+func (db *TaxonomyDB) ListTaxa() ([]model.Taxon, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT t.id, t.parent_id, t.name, t.scientific_name, t.conservation_status, t.common_names
+		FROM taxon t
+		LEFT JOIN taxon p ON p.id = t.parent_id
+		ORDER BY COALESCE(p.name, t.name), t.parent_id IS NOT NULL, t.name`)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	taxa := make([]model.Taxon, 0)
+	for rows.Next() {
+		var taxon model.Taxon
+		if err = scanTaxon(rows, &taxon); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		taxa = append(taxa, taxon)
+	}
+	return taxa, rows.Err()
+}
+
+func (db *TaxonomyDB) GetTaxon(taxonId int64) (*model.Taxon, error) {
+	var taxon model.Taxon
+	err := scanTaxon(db.pool.QueryRow(context.Background(),
+		`SELECT `+taxonColumns+` FROM taxon WHERE id = $1`, taxonId), &taxon)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	return &taxon, nil
+}
+
+// CreateTaxon adds a species or, when the taxon has a parent, a subspecies of it. The parent has to be a species
+// or ErrUnknownTaxon is returned, and names already taken at the same rank yield ErrConflict.
+func (db *TaxonomyDB) CreateTaxon(taxon *model.Taxon, actor model.Actor) (*model.Taxon, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	if taxon.ParentID != nil {
+		var isSpecies bool
+		err = tx.QueryRow(ctx, `SELECT parent_id IS NULL FROM taxon WHERE id = $1`, *taxon.ParentID).Scan(&isSpecies)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && !isSpecies) {
+			return nil, ErrUnknownTaxon
+		}
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+	}
+	commonNames := taxon.CommonNames
+	if commonNames == nil {
+		commonNames = make(map[string]string)
+	}
+	var created model.Taxon
+	err = scanTaxon(tx.QueryRow(ctx,
+		`INSERT INTO taxon (parent_id, name, scientific_name, conservation_status, common_names)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+		RETURNING `+taxonColumns,
+		taxon.ParentID, NormalizeTaxonName(taxon.Name), taxon.ScientificName, taxon.ConservationStatus, commonNames), &created)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Taxon with id", created.ID, "created")
+	return &created, nil
+}
+
+// UpdateTaxon changes the fields of the update that are not nil. Renaming a taxon renames the type or variant of
+// its animals too.
+func (db *TaxonomyDB) UpdateTaxon(taxonId int64, update *model.TaxonUpdate, actor model.Actor) (*model.Taxon, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var name *string
+	if update.Name != nil {
+		normalized := NormalizeTaxonName(*update.Name)
+		name = &normalized
+	}
+	// A nil map would be sent as a JSON null rather than NULL.
+	var commonNames interface{}
+	if update.CommonNames != nil {
+		commonNames = update.CommonNames
+	}
+	var taxon model.Taxon
+	err = scanTaxon(tx.QueryRow(ctx,
+		`UPDATE taxon SET
+			name = COALESCE($2, name),
+			scientific_name = NULLIF(COALESCE($3, scientific_name), ''),
+			conservation_status = NULLIF(COALESCE($4, conservation_status), ''),
+			common_names = COALESCE($5, common_names),
+			updated_at = now()
+		WHERE id = $1
+		RETURNING `+taxonColumns,
+		taxonId, name, update.ScientificName, update.ConservationStatus, commonNames), &taxon)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("Taxon with id", taxonId, "updated")
+	return &taxon, nil
+}
+
+// DeleteTaxon removes a taxon no animal or subspecies refers to, otherwise it fails with ErrInUse. Deleted
+// animals still refer to their taxon.
+func (db *TaxonomyDB) DeleteTaxon(taxonId int64, actor model.Actor) error {
+	tag, err := execAudited(context.Background(), db.pool, actor, `DELETE FROM taxon WHERE id = $1`, taxonId)
+	if isForeignKeyViolation(err) {
+		return ErrInUse
+	}
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("Taxon with id", taxonId, "deleted")
+	return nil
+}
+
+// resolveTaxa returns the IDs of the species and subspecies named by an animal's type and variant. Animals without
+// a variant have no subspecies. Names that are not in the taxonomy yield ErrUnknownTaxon.
+func resolveTaxa(ctx context.Context, tx pgx.Tx, animalType string, variant string) (int64, *int64, error) {
+	var speciesID int64
+	var subspeciesID *int64
+	variant = NormalizeTaxonName(variant)
+	err := tx.QueryRow(ctx, `
+		SELECT s.id, ss.id
+		FROM taxon s
+		LEFT JOIN taxon ss ON ss.parent_id = s.id AND ss.name = $2
+		WHERE s.parent_id IS NULL AND s.name = $1`,
+		NormalizeTaxonName(animalType), variant).Scan(&speciesID, &subspeciesID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && variant != "" && subspeciesID == nil) {
+		return 0, nil, ErrUnknownTaxon
+	}
+	if err != nil {
+		logger.LogError(err)
+		return 0, nil, err
+	}
+	return speciesID, subspeciesID, nil
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+var (
+	ErrTokenExpired = errors.New("Refresh token expired")
+	ErrTokenReused  = errors.New("Refresh token reuse detected")
+)
+
+type IToken interface {
+	CreateRefreshToken(token *model.RefreshToken) error
+	RotateRefreshToken(tokenHash string, next *model.RefreshToken) (*model.RefreshToken, error)
+	RevokeRefreshToken(tokenHash string, userId int64) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti string) (bool, error)
+	RevokeUserTokens(userId int64) error
+	CreateAccountToken(jti string, userId int64, purpose string, expiresAt time.Time) error
+	ConsumeAccountToken(jti string, purpose string) (int64, error)
+}
+
+type TokenDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewTokenDB(pool *pgxpool.Pool) *TokenDB {
+	return &TokenDB{
+		pool: pool,
+	}
+}
+
+func (db *TokenDB) CreateRefreshToken(token *model.RefreshToken) error {
+	return createRefreshToken(context.Background(), db.pool, token)
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func createRefreshToken(ctx context.Context, q queryRower, token *model.RefreshToken) error {
+	err := q.QueryRow(ctx,
+		`INSERT INTO refresh_token (user_id, family_id, token_hash, access_jti, expires_at)
+         VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		token.UserID, token.FamilyID, token.TokenHash, token.AccessJTI, token.ExpiresAt).Scan(&token.ID)
+	if err != nil {
+		logger.LogError(err)
+		return fmt.Errorf("Failed to store refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken consumes the refresh token identified by tokenHash and stores next in the
+// same family. Presenting a token that has already been consumed revokes the whole family.
+func (db *TokenDB) RotateRefreshToken(tokenHash string, next *model.RefreshToken) (*model.RefreshToken, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	var current model.RefreshToken
+	var revoked bool
+	err = tx.QueryRow(ctx,
+		`SELECT id, user_id, family_id, token_hash, access_jti, expires_at, revoked_at IS NOT NULL
+		FROM refresh_token WHERE token_hash = $1 FOR UPDATE`,
+		tokenHash).Scan(&current.ID, &current.UserID, &current.FamilyID, &current.TokenHash, &current.AccessJTI, &current.ExpiresAt, &revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if revoked {
+		if err = revokeTokenFamily(ctx, tx, current.FamilyID); err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("Failed to commit transaction")
+		}
+		logger.LogError(fmt.Errorf("refresh token reuse detected for user %d, family %s revoked", current.UserID, current.FamilyID))
+		return nil, ErrTokenReused
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if _, err = tx.Exec(ctx, `UPDATE refresh_token SET revoked_at = now() WHERE id = $1`, current.ID); err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	if err = createRefreshToken(ctx, tx, next); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	return &current, nil
+}
+
+// RevokeRefreshToken revokes the family of the given refresh token, provided it belongs to userId.
+func (db *TokenDB) RevokeRefreshToken(tokenHash string, userId int64) error {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	var familyId string
+	err = tx.QueryRow(ctx,
+		`SELECT family_id FROM refresh_token WHERE token_hash = $1 AND user_id = $2`,
+		tokenHash, userId).Scan(&familyId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if err = revokeTokenFamily(ctx, tx, familyId); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	return nil
+}
+
+// revokeTokenFamily revokes every refresh token of the family along with the access tokens issued with them.
+func revokeTokenFamily(ctx context.Context, tx pgx.Tx, familyId string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO revoked_token (jti, expires_at)
+		SELECT access_jti, expires_at FROM refresh_token WHERE family_id = $1
+		ON CONFLICT (jti) DO NOTHING`,
+		familyId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_token SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`,
+		familyId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	logger.LogInfo("Revoked refresh token family", familyId)
+	return nil
+}
+
+func (db *TokenDB) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := db.pool.Exec(context.Background(),
+		`INSERT INTO revoked_token (jti, expires_at) VALUES($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	return nil
+}
+
+func (db *TokenDB) IsAccessTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM revoked_token WHERE jti = $1)`,
+		jti).Scan(&revoked)
+	if err != nil {
+		logger.LogError(err)
+		return false, err
+	}
+	return revoked, nil
+}
+
+// RevokeUserTokens revokes every refresh token family of the user, logging them out everywhere.
+func (db *TokenDB) RevokeUserTokens(userId int64) error {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	if err = revokeUserTokenFamilies(ctx, tx, userId); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	return nil
+}
+
+func revokeUserTokenFamilies(ctx context.Context, tx pgx.Tx, userId int64) error {
+	rows, err := tx.Query(ctx,
+		`SELECT DISTINCT family_id FROM refresh_token WHERE user_id = $1 AND revoked_at IS NULL`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	var families []string
+	for rows.Next() {
+		var familyId string
+		if err = rows.Scan(&familyId); err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return err
+		}
+		families = append(families, familyId)
+	}
+	rows.Close()
+	for _, familyId := range families {
+		if err = revokeTokenFamily(ctx, tx, familyId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateAccountToken records a single use token, e.g. for email verification or password reset.
+func (db *TokenDB) CreateAccountToken(jti string, userId int64, purpose string, expiresAt time.Time) error {
+	_, err := db.pool.Exec(context.Background(),
+		`INSERT INTO account_token (jti, user_id, purpose, expires_at) VALUES($1, $2, $3, $4)`,
+		jti, userId, purpose, expiresAt)
+	if err != nil {
+		logger.LogError(err)
+		return fmt.Errorf("Failed to store account token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAccountToken marks the token used and returns its user. Used, expired or unknown tokens yield ErrNotFound.
+func (db *TokenDB) ConsumeAccountToken(jti string, purpose string) (int64, error) {
+	var userId int64
+	err := db.pool.QueryRow(context.Background(),
+		`UPDATE account_token SET used_at = now()
+		WHERE jti = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`,
+		jti, purpose).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return 0, err
+	}
+	return userId, nil
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("Two-factor authentication already enabled")
+	ErrCodeReused       = errors.New("One-time code already used")
+)
+
+type ITwoFactor interface {
+	StartTOTPEnrolment(userId int64, secret string) error
+	GetTOTP(userId int64) (*model.TOTP, error)
+	ConfirmTOTP(userId int64, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(userId int64, step int64) error
+	DisableTOTP(userId int64) error
+	UseRecoveryCode(userId int64, codeHash string) error
+	ReplaceRecoveryCodes(userId int64, codeHashes []string) error
+	IsTwoFactorRequired(role model.Role) (bool, error)
+	TwoFactorRequiredRoles() ([]model.Role, error)
+	SetTwoFactorRequired(role model.Role, required bool, updatedBy int64) error
+}
+
+type TwoFactorDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewTwoFactorDB(pool *pgxpool.Pool) *TwoFactorDB {
+	return &TwoFactorDB{
+		pool: pool,
+	}
+}
+
+// StartTOTPEnrolment stores a pending secret for the user, replacing a previous pending one.
+// It fails with ErrTwoFactorEnabled when the user already confirmed an enrolment.
+func (db *TwoFactorDB) StartTOTPEnrolment(userId int64, secret string) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`INSERT INTO user_totp (user_id, secret) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE user_totp.confirmed_at IS NULL`,
+		userId, secret)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (db *TwoFactorDB) GetTOTP(userId int64) (*model.TOTP, error) {
+	totp := model.TOTP{UserID: userId}
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT secret, confirmed_at IS NOT NULL, last_used_step FROM user_totp WHERE user_id = $1`,
+		userId).Scan(&totp.Secret, &totp.Confirmed, &totp.LastUsedStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// ConfirmTOTP enables the pending enrolment once the user proved it works with the code of the given
+// time step, and stores the user's recovery codes.
+func (db *TwoFactorDB) ConfirmTOTP(userId int64, step int64, recoveryCodeHashes []string) error {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	tag, err := tx.Exec(ctx,
+		`UPDATE user_totp SET confirmed_at = now(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL AND last_used_step < $2`,
+		userId, step)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err = replaceRecoveryCodes(ctx, tx, userId, recoveryCodeHashes); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("User with id", userId, "enabled two-factor authentication")
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code. Codes of the same or an earlier step are rejected
+// with ErrCodeReused, so an intercepted code cannot be replayed.
+func (db *TwoFactorDB) UseTOTPStep(userId int64, step int64) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`,
+		userId, step)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+func (db *TwoFactorDB) DisableTOTP(userId int64) error {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	if _, err = tx.Exec(ctx, `DELETE FROM recovery_code WHERE user_id = $1`, userId); err != nil {
+		logger.LogError(err)
+		return err
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("User with id", userId, "disabled two-factor authentication")
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. Unknown or used codes yield ErrNotFound.
+func (db *TwoFactorDB) UseRecoveryCode(userId int64, codeHash string) error {
+	tag, err := db.pool.Exec(context.Background(),
+		`UPDATE recovery_code SET used_at = now()
+		WHERE id = (SELECT id FROM recovery_code WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)`,
+		userId, codeHash)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("User with id", userId, "used a recovery code")
+	return nil
+}
+
+func (db *TwoFactorDB) ReplaceRecoveryCodes(userId int64, codeHashes []string) error {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.LogError(err)
+		}
+	}()
+	if err = replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId int64, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_code WHERE user_id = $1`, userId); err != nil {
+		logger.LogError(err)
+		return err
+	}
+	batch := &pgx.Batch{}
+	for _, codeHash := range codeHashes {
+		batch.Queue(`INSERT INTO recovery_code (user_id, code_hash) VALUES($1, $2)`, userId, codeHash)
+	}
+	results := tx.SendBatch(ctx, batch)
+	for range codeHashes {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			logger.LogError(err)
+			return fmt.Errorf("Failed to store recovery codes: %w", err)
+		}
+	}
+	return results.Close()
+}
+
+func (db *TwoFactorDB) IsTwoFactorRequired(role model.Role) (bool, error) {
+	var required bool
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM two_factor_policy WHERE role = $1)`,
+		role).Scan(&required)
+	if err != nil {
+		logger.LogError(err)
+		return false, err
+	}
+	return required, nil
+}
+
+func (db *TwoFactorDB) TwoFactorRequiredRoles() ([]model.Role, error) {
+	rows, err := db.pool.Query(context.Background(), `SELECT role FROM two_factor_policy ORDER BY role`)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	roles := make([]model.Role, 0)
+	for rows.Next() {
+		var role model.Role
+		if err = rows.Scan(&role); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// SetTwoFactorRequired adds the role to or removes it from the roles that must use two-factor authentication.
+func (db *TwoFactorDB) SetTwoFactorRequired(role model.Role, required bool, updatedBy int64) error {
+	var err error
+	if required {
+		_, err = db.pool.Exec(context.Background(),
+			`INSERT INTO two_factor_policy (role, updated_by) VALUES($1, $2)
+			ON CONFLICT (role) DO UPDATE SET updated_by = EXCLUDED.updated_by, updated_at = now()`,
+			role, updatedBy)
+	} else {
+		_, err = db.pool.Exec(context.Background(), `DELETE FROM two_factor_policy WHERE role = $1`, role)
+	}
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	logger.LogInfo("Two-factor authentication requirement for role", role, "set to", required)
+	return nil
+}
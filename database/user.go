@@ -2,57 +2,168 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"golang.org/x/crypto/bcrypt"
+	"sync"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/model"
+	"tigerhall-kittens/password"
+	"tigerhall-kittens/pii"
 )
 
 type IUser interface {
-	CreateUser(user *model.User) (*model.User, error)
+	CreateUser(user *model.User, actor model.Actor) (*model.User, error)
 	GetUserByUsername(username string) (*model.User, error)
+	GetUserByID(userId int64) (*model.User, error)
+	UpdateUserRole(userId int64, role model.Role, actor model.Actor) (*model.User, error)
+	GetUsersByEmail(email string) ([]model.User, error)
+	MarkEmailVerified(userId int64, actor model.Actor) error
+	UpdatePassword(userId int64, password string, actor model.Actor) error
+	CheckPasswordPolicy(password string) error
+	VerifyPassword(user *model.User, password string, actor model.Actor) bool
+	GetProfile(userId int64) (*model.Profile, error)
+	UpdateProfile(userId int64, username *string, email *string, actor model.Actor) (*model.Profile, error)
+	DeleteUser(userId int64, actor model.Actor) error
+	ExportUserData(userId int64) (*model.UserExport, error)
+	ListUsers(filter model.UserFilter) ([]model.AdminUser, int64, error)
+	GetAdminUser(userId int64) (*model.AdminUser, error)
+	SetUserDisabled(userId int64, disabled bool, actor model.Actor, reason string) (*model.AdminUser, error)
+	ForceLogout(userId int64, actor model.Actor, reason string) error
+	ListUserAdminActions(userId int64) ([]model.UserAdminAction, error)
+	IsUserDisabled(userId int64) (bool, error)
 }
 
+var ErrConflict = errors.New("Record already exists")
+
+const uniqueViolation = "23505"
+
+// UserDB stores users' emails encrypted with the keyring, along with a blind index to look them up by.
+// Passwords are hashed with the hasher and have to satisfy the policy, if there is one.
 type UserDB struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	keys   *pii.Keyring
+	hasher password.Hasher
+	policy *password.Policy
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
-func NewUserDB(pool *pgxpool.Pool) *UserDB {
+func NewUserDB(pool *pgxpool.Pool, keys *pii.Keyring, hasher password.Hasher, policy *password.Policy) *UserDB {
 	return &UserDB{
-		pool: pool,
+		pool:   pool,
+		keys:   keys,
+		hasher: hasher,
+		policy: policy,
 	}
 }
 
-func (db *UserDB) CreateUser(user *model.User) (*model.User, error) {
-	hashedPassword, err := hashPassword(user.Password)
+// CreateUser fails with a *password.PolicyError when the password does not satisfy the policy.
+func (db *UserDB) CreateUser(user *model.User, actor model.Actor) (*model.User, error) {
+	hashedPassword, err := db.hashPassword(user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
+	keyID, ciphertext, index, err := sealUserEmail(db.keys, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
 	var id int
-	err = db.pool.QueryRow(context.Background(),
-		`INSERT INTO "user" (username, password, email) VALUES($1, $2, $3) RETURNING id`,
-		user.Username, hashedPassword, user.Email).Scan(&id)
+	err = tx.QueryRow(ctx,
+		`INSERT INTO "user" (username, password, email_key_id, email_ciphertext, email_index)
+		VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		user.Username, hashedPassword, keyID, ciphertext, index).Scan(&id)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
 	logger.LogInfo("User with username", user.Username, " created")
 	return &model.User{
 		ID:       int64(id),
 		Username: user.Username,
+		Role:     model.RoleReporter,
 	}, nil
 }
 
-func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// hashPassword checks the password against the policy and hashes it.
+func (db *UserDB) hashPassword(plain string) (string, error) {
+	if err := db.CheckPasswordPolicy(plain); err != nil {
+		return "", err
+	}
+	hashedPassword, err := db.hasher.Hash(plain)
 	if err != nil {
 		return "", fmt.Errorf("%w", err)
 	}
-	return string(hashedPassword), nil
+	return hashedPassword, nil
+}
+
+// CheckPasswordPolicy returns a *password.PolicyError when the password may not be used.
+func (db *UserDB) CheckPasswordPolicy(plain string) error {
+	if db.policy == nil {
+		return nil
+	}
+	return db.policy.Check(plain)
+}
+
+// VerifyPassword reports whether the password is the user's. Users without a password are compared against a
+// dummy hash so that response times do not reveal them. A correct password whose hash was made with an outdated
+// algorithm or parameters is rehashed, unless the password was changed in the meantime.
+func (db *UserDB) VerifyPassword(user *model.User, plain string, actor model.Actor) bool {
+	if user.Password == "" {
+		_, _ = db.hasher.Verify(db.dummyPasswordHash(), plain)
+		return false
+	}
+	ok, err := db.hasher.Verify(user.Password, plain)
+	if err != nil {
+		logger.LogError(fmt.Errorf("Failed to verify password of user with id %d: %w", user.ID, err))
+		return false
+	}
+	if !ok || !db.hasher.NeedsRehash(user.Password) {
+		return ok
+	}
+	hashedPassword, err := db.hasher.Hash(plain)
+	if err != nil {
+		logger.LogError(err)
+		return true
+	}
+	tag, err := execAudited(context.Background(), db.pool, actor,
+		`UPDATE "user" SET password = $1 WHERE id = $2 AND password = $3`,
+		hashedPassword, user.ID, user.Password)
+	if err != nil {
+		logger.LogError(err)
+		return true
+	}
+	if tag.RowsAffected() == 1 {
+		user.Password = hashedPassword
+		logger.LogInfo("Rehashed password of user with id", user.ID)
+	}
+	return true
+}
+
+func (db *UserDB) dummyPasswordHash() string {
+	db.dummyHashOnce.Do(func() {
+		var err error
+		db.dummyHash, err = db.hasher.Hash("dummy password that nobody has")
+		if err != nil {
+			logger.LogError(err)
+		}
+	})
+	return db.dummyHash
 }
 
 func (db *UserDB) GetUserByUsername(username string) (*model.User, error) {
-	sqlQuery := `SELECT id, username, password, email FROM "user" where username = $1`
+	sqlQuery := `SELECT id, username, password, ` + emailColumns + `, role, email_verified_at IS NOT NULL, disabled_at IS NOT NULL FROM "user" where username = $1`
 	rows, err := db.pool.Query(context.Background(), sqlQuery, username)
 	if err != nil {
 		logger.LogError(err)
@@ -61,13 +172,365 @@ func (db *UserDB) GetUserByUsername(username string) (*model.User, error) {
 	defer rows.Close()
 
 	var data model.User
+	var email sealedEmail
 	for rows.Next() {
 		err = rows.Scan(
 			&data.ID,
 			&data.Username,
 			&data.Password,
-			&data.Email,
+			&email.keyID,
+			&email.ciphertext,
+			&email.legacy,
+			&data.Role,
+			&data.EmailVerified,
+			&data.Disabled,
 		)
 	}
+	if data.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (db *UserDB) GetUserByID(userId int64) (*model.User, error) {
+	var data model.User
+	var email sealedEmail
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT id, username, password, `+emailColumns+`, role, email_verified_at IS NOT NULL, disabled_at IS NOT NULL FROM "user" where id = $1`,
+		userId).Scan(&data.ID, &data.Username, &data.Password, &email.keyID, &email.ciphertext, &email.legacy, &data.Role,
+		&data.EmailVerified, &data.Disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if data.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (db *UserDB) UpdateUserRole(userId int64, role model.Role, actor model.Actor) (*model.User, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	var data model.User
+	var email sealedEmail
+	err = tx.QueryRow(ctx,
+		`UPDATE "user" SET role = $1 WHERE id = $2 RETURNING id, username, `+emailColumns+`, role`,
+		role, userId).Scan(&data.ID, &data.Username, &email.keyID, &email.ciphertext, &email.legacy, &data.Role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if data.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("User with id", userId, "assigned role", role)
 	return &data, nil
 }
+
+// GetUsersByEmail looks users up by the blind index of the email, or by the plain text email of the users
+// the re-encryption job has not reached yet.
+func (db *UserDB) GetUsersByEmail(email string) ([]model.User, error) {
+	rows, err := db.pool.Query(context.Background(),
+		`SELECT id, username, `+emailColumns+` FROM "user" where email_index = $1 OR lower(email) = lower($2)`,
+		userEmailIndex(db.keys, email), email)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var users []model.User
+	for rows.Next() {
+		var data model.User
+		var sealed sealedEmail
+		if err = rows.Scan(&data.ID, &data.Username, &sealed.keyID, &sealed.ciphertext, &sealed.legacy); err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		if data.Email, err = openEmail(db.keys, userEmailLabel, sealed); err != nil {
+			return nil, err
+		}
+		users = append(users, data)
+	}
+	return users, nil
+}
+
+func (db *UserDB) MarkEmailVerified(userId int64, actor model.Actor) error {
+	tag, err := execAudited(context.Background(), db.pool, actor,
+		`UPDATE "user" SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("User with id", userId, "verified their email")
+	return nil
+}
+
+// UpdatePassword fails with a *password.PolicyError when the password does not satisfy the policy.
+func (db *UserDB) UpdatePassword(userId int64, password string, actor model.Actor) error {
+	hashedPassword, err := db.hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	tag, err := execAudited(context.Background(), db.pool, actor,
+		`UPDATE "user" SET password = $1 WHERE id = $2`,
+		hashedPassword, userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	logger.LogInfo("User with id", userId, "changed their password")
+	return nil
+}
+
+func (db *UserDB) GetProfile(userId int64) (*model.Profile, error) {
+	var profile model.Profile
+	var email sealedEmail
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT u.id, u.username, u.email_key_id, u.email_ciphertext, u.email, u.role, u.email_verified_at IS NOT NULL,
+		EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.confirmed_at IS NOT NULL),
+		TO_CHAR(u.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), COALESCE(TO_CHAR(u.updated_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), '')
+		FROM "user" u WHERE u.id = $1`,
+		userId).Scan(&profile.ID, &profile.Username, &email.keyID, &email.ciphertext, &email.legacy, &profile.Role,
+		&profile.EmailVerified, &profile.TwoFactorEnabled, &profile.CreatedAt, &profile.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if profile.Email, err = openEmail(db.keys, userEmailLabel, email); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateProfile changes the fields that are not nil. A new email address has to be verified again, and verification
+// tokens sent before are revoked in the same transaction so that they cannot verify it.
+// Taking a username or email that is already in use fails with ErrConflict.
+func (db *UserDB) UpdateProfile(userId int64, username *string, email *string, actor model.Actor) (*model.Profile, error) {
+	sqlQuery := `UPDATE "user" SET username = COALESCE($2, username), updated_at = now() WHERE id = $1`
+	params := []interface{}{userId, username}
+	if email != nil {
+		keyID, ciphertext, index, err := sealUserEmail(db.keys, *email)
+		if err != nil {
+			return nil, err
+		}
+		sqlQuery = `UPDATE "user" SET
+			username = COALESCE($2, username),
+			email_verified_at = CASE WHEN email_index = $5 OR lower(email) = lower($6) THEN email_verified_at END,
+			email_key_id = $3,
+			email_ciphertext = $4,
+			email_index = $5,
+			email = NULL,
+			updated_at = now()
+		WHERE id = $1`
+		params = append(params, keyID, ciphertext, index, *email)
+	}
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	tag, err := tx.Exec(ctx, sqlQuery, params...)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+	if email != nil {
+		_, err = tx.Exec(ctx,
+			`DELETE FROM account_token WHERE user_id = $1 AND purpose = 'email_verification' AND used_at IS NULL`,
+			userId)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("User with id", userId, "updated their profile")
+	return db.GetProfile(userId)
+}
+
+// DeleteUser removes the user and everything that belongs to them. Their sightings are kept for the
+// animals' history and the admin actions on them for the record, but no longer name them, and their
+// outstanding access tokens are revoked.
+func (db *UserDB) DeleteUser(userId int64, actor model.Actor) error {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return err
+	}
+	defer rollbackAudited(ctx, tx)
+	statements := []string{
+		`INSERT INTO revoked_token (jti, expires_at)
+		SELECT access_jti, expires_at FROM refresh_token WHERE user_id = $1 AND expires_at > now()
+		ON CONFLICT (jti) DO NOTHING`,
+		`DELETE FROM login_attempt WHERE kind = 'username' AND key = (SELECT lower(username) FROM "user" WHERE id = $1)`,
+		`DELETE FROM refresh_token WHERE user_id = $1`,
+		`DELETE FROM account_token WHERE user_id = $1`,
+		`DELETE FROM notification WHERE user_id = $1`,
+		`DELETE FROM user_identity WHERE user_id = $1`,
+		`DELETE FROM recovery_code WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM api_key WHERE user_id = $1`,
+		`UPDATE two_factor_policy SET updated_by = NULL WHERE updated_by = $1`,
+		`UPDATE user_admin_action SET user_id = NULL WHERE user_id = $1`,
+		`UPDATE user_admin_action SET actor_id = NULL WHERE actor_id = $1`,
+		`UPDATE sighting SET reporter = NULL WHERE reporter = $1`,
+		`UPDATE animal_status_history SET actor_id = NULL WHERE actor_id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(ctx, statement, userId); err != nil {
+			logger.LogError(err)
+			return fmt.Errorf("Failed to delete user data: %w", err)
+		}
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM "user" WHERE id = $1`, userId)
+	if err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	logger.LogInfo("User with id", userId, "deleted their account")
+	return nil
+}
+
+// ExportUserData collects everything stored about the user. Secrets such as password and key hashes are left out.
+func (db *UserDB) ExportUserData(userId int64) (*model.UserExport, error) {
+	profile, err := db.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	export := &model.UserExport{
+		Profile:       *profile,
+		Identities:    make([]model.LinkedIdentity, 0),
+		Sightings:     make([]model.ReportedSighting, 0),
+		Notifications: make([]model.Notification, 0),
+		APIKeys:       make([]model.APIKey, 0),
+	}
+	ctx := context.Background()
+	err = db.pool.QueryRow(ctx, `SELECT TO_CHAR(now(), 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`).Scan(&export.ExportedAt)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(ctx,
+		`SELECT provider, subject, `+emailColumns+`, TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM user_identity WHERE user_id = $1 ORDER BY created_at`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	for rows.Next() {
+		var identity model.LinkedIdentity
+		var email sealedEmail
+		err = rows.Scan(&identity.Provider, &identity.Subject, &email.keyID, &email.ciphertext, &email.legacy, &identity.LinkedAt)
+		if err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		if identity.Email, err = openEmail(db.keys, identityEmailLabel, email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Identities = append(export.Identities, identity)
+	}
+	rows.Close()
+
+	rows, err = db.pool.Query(ctx,
+		`SELECT s.id, a.id, a.name, s.location[0], s.location[1], TO_CHAR(s.spotting_timestamp, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+		COALESCE(i.filename, ''), TO_CHAR(s.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM sighting s
+		JOIN animal a ON s.animal_id = a.id
+		LEFT OUTER JOIN image i ON s.image_id = i.id
+		WHERE s.reporter = $1 ORDER BY s.spotting_timestamp`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	for rows.Next() {
+		var sighting model.ReportedSighting
+		err = rows.Scan(&sighting.ID, &sighting.AnimalID, &sighting.AnimalName, &sighting.Location.Longitude,
+			&sighting.Location.Latitude, &sighting.SpottingTimestamp, &sighting.ImageFileName, &sighting.CreatedAt)
+		if err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		export.Sightings = append(export.Sightings, sighting)
+	}
+	rows.Close()
+
+	rows, err = db.pool.Query(ctx,
+		`SELECT id, animal_id, sighting_id, message, read_at IS NOT NULL,
+		COALESCE(TO_CHAR(read_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM notification WHERE user_id = $1 ORDER BY created_at`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	for rows.Next() {
+		notification := model.Notification{UserID: userId}
+		err = rows.Scan(&notification.ID, &notification.AnimalID, &notification.SightingID, &notification.Message,
+			&notification.Read, &notification.ReadAt, &notification.CreatedAt)
+		if err != nil {
+			rows.Close()
+			logger.LogError(err)
+			return nil, err
+		}
+		export.Notifications = append(export.Notifications, notification)
+	}
+	rows.Close()
+
+	export.APIKeys, err = NewAPIKeyDB(db.pool, db.keys).ListAPIKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo("Exported data of user with id", userId)
+	return export, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strconv"
+	"strings"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+)
+
+const adminUserColumns = `u.id, u.username, u.email_key_id, u.email_ciphertext, u.email, u.role, u.email_verified_at IS NOT NULL,
+		EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.confirmed_at IS NOT NULL),
+		TO_CHAR(u.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), COALESCE(TO_CHAR(u.updated_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+		u.disabled_at IS NOT NULL, COALESCE(TO_CHAR(u.disabled_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), COALESCE(u.disabled_reason, '')`
+
+func (db *UserDB) scanAdminUser(row pgx.Row, user *model.AdminUser) error {
+	var email sealedEmail
+	err := row.Scan(&user.ID, &user.Username, &email.keyID, &email.ciphertext, &email.legacy, &user.Role, &user.EmailVerified,
+		&user.TwoFactorEnabled, &user.CreatedAt, &user.UpdatedAt, &user.Disabled, &user.DisabledAt, &user.DisabledReason)
+	if err != nil {
+		return err
+	}
+	user.Email, err = openEmail(db.keys, userEmailLabel, email)
+	return err
+}
+
+// ListUsers returns a page of the users matching the filter, ordered by username, along with the number of matches.
+func (db *UserDB) ListUsers(filter model.UserFilter) ([]model.AdminUser, int64, error) {
+	where := " WHERE true"
+	params := make([]interface{}, 0)
+	if filter.Query != "" {
+		params = append(params, "%"+escapeLike(strings.ToLower(filter.Query))+"%", userEmailIndex(db.keys, filter.Query))
+		where += " AND (lower(u.username) LIKE $" + strconv.Itoa(len(params)-1) + " OR lower(u.email) LIKE $" + strconv.Itoa(len(params)-1) +
+			" OR u.email_index = $" + strconv.Itoa(len(params)) + ")"
+	}
+	if filter.Role != "" {
+		params = append(params, filter.Role)
+		where += " AND u.role = $" + strconv.Itoa(len(params))
+	}
+	if filter.Disabled != nil {
+		if *filter.Disabled {
+			where += " AND u.disabled_at IS NOT NULL"
+		} else {
+			where += " AND u.disabled_at IS NULL"
+		}
+	}
+	ctx := context.Background()
+	var total int64
+	err := db.pool.QueryRow(ctx, `SELECT COUNT(*) FROM "user" u`+where, params...).Scan(&total)
+	if err != nil {
+		logger.LogError(err)
+		return nil, 0, err
+	}
+	sqlQuery := `SELECT ` + adminUserColumns + ` FROM "user" u` + where + " ORDER BY lower(u.username), u.id"
+	sqlQuery += " LIMIT $" + strconv.Itoa(len(params)+1) + " OFFSET $" + strconv.Itoa(len(params)+2)
+	params = append(params, filter.Limit, filter.Offset)
+	rows, err := db.pool.Query(ctx, sqlQuery, params...)
+	if err != nil {
+		logger.LogError(err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := make([]model.AdminUser, 0)
+	for rows.Next() {
+		var user model.AdminUser
+		if err = db.scanAdminUser(rows, &user); err != nil {
+			logger.LogError(err)
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, nil
+}
+
+func (db *UserDB) GetAdminUser(userId int64) (*model.AdminUser, error) {
+	var user model.AdminUser
+	err := db.scanAdminUser(db.pool.QueryRow(context.Background(),
+		`SELECT `+adminUserColumns+` FROM "user" u WHERE u.id = $1`, userId), &user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserDisabled disables or enables the account and records the action. Disabling also logs the user
+// out of every session, and their remaining access tokens are rejected for as long as the account is disabled.
+func (db *UserDB) SetUserDisabled(userId int64, disabled bool, actor model.Actor, reason string) (*model.AdminUser, error) {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackAudited(ctx, tx)
+	action := model.AdminActionEnable
+	sqlQuery := `UPDATE "user" SET disabled_at = NULL, disabled_reason = NULL WHERE id = $1`
+	params := []interface{}{userId}
+	if disabled {
+		action = model.AdminActionDisable
+		sqlQuery = `UPDATE "user" SET disabled_at = COALESCE(disabled_at, now()), disabled_reason = $2 WHERE id = $1`
+		params = append(params, reason)
+	}
+	tag, err := tx.Exec(ctx, sqlQuery, params...)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+	if disabled {
+		if err = revokeUserTokenFamilies(ctx, tx, userId); err != nil {
+			return nil, err
+		}
+	}
+	if err = recordAdminAction(ctx, tx, userId, actor.UserID, action, reason); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction")
+	}
+	return db.GetAdminUser(userId)
+}
+
+// ForceLogout revokes every session of the user and records the action.
+func (db *UserDB) ForceLogout(userId int64, actor model.Actor, reason string) error {
+	ctx := context.Background()
+	tx, err := beginAudited(ctx, db.pool, actor)
+	if err != nil {
+		return err
+	}
+	defer rollbackAudited(ctx, tx)
+	var exists bool
+	if err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM "user" WHERE id = $1)`, userId).Scan(&exists); err != nil {
+		logger.LogError(err)
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	if err = revokeUserTokenFamilies(ctx, tx, userId); err != nil {
+		return err
+	}
+	if err = recordAdminAction(ctx, tx, userId, actor.UserID, model.AdminActionForceLogout, reason); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Failed to commit transaction")
+	}
+	return nil
+}
+
+func recordAdminAction(ctx context.Context, tx pgx.Tx, userId int64, actorId int64, action string, reason string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO user_admin_action (user_id, actor_id, action, reason) VALUES($1, $2, $3, $4)`,
+		userId, actorId, action, reason)
+	if err != nil {
+		logger.LogError(err)
+		return fmt.Errorf("Failed to record admin action: %w", err)
+	}
+	logger.LogInfo("Admin", actorId, "performed", action, "on user", userId)
+	return nil
+}
+
+func (db *UserDB) ListUserAdminActions(userId int64) ([]model.UserAdminAction, error) {
+	rows, err := db.pool.Query(context.Background(),
+		`SELECT a.id, a.user_id, COALESCE(a.actor_id, 0), COALESCE(u.username, ''), a.action, a.reason,
+		TO_CHAR(a.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		FROM user_admin_action a
+		LEFT OUTER JOIN "user" u ON a.actor_id = u.id
+		WHERE a.user_id = $1
+		ORDER BY a.created_at DESC, a.id DESC`,
+		userId)
+	if err != nil {
+		logger.LogError(err)
+		return nil, err
+	}
+	defer rows.Close()
+	actions := make([]model.UserAdminAction, 0)
+	for rows.Next() {
+		var action model.UserAdminAction
+		err = rows.Scan(&action.ID, &action.UserID, &action.ActorID, &action.ActorUsername, &action.Action, &action.Reason, &action.CreatedAt)
+		if err != nil {
+			logger.LogError(err)
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (db *UserDB) IsUserDisabled(userId int64) (bool, error) {
+	var disabled bool
+	err := db.pool.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM "user" WHERE id = $1 AND disabled_at IS NOT NULL)`,
+		userId).Scan(&disabled)
+	if err != nil {
+		logger.LogError(err)
+		return false, err
+	}
+	return disabled, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so that it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,11 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "tiger", escapeLike("tiger"), "Plain text should be unchanged")
+	assert.Equal(t, `100\%\_ranger\\`, escapeLike(`100%_ranger\`), "Wildcards should be escaped")
+}
@@ -0,0 +1,233 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Key is a JWT signing key identified by its kid. Retired keys only carry the public half
+// and are kept so that tokens they signed stay valid until they expire.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// KeySet signs tokens with its active key and verifies tokens signed by any of its keys.
+type KeySet struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+// JWK is the JSON Web Key representation of a public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeySet reads every *.pem file of dir, using the file name without extension as kid.
+// Private keys (PKCS#1 or PKCS#8, RSA or Ed25519) can sign, public keys (PKIX) only verify.
+// activeKID selects the signing key; when empty the lexically greatest kid with a private key is used,
+// so naming keys by date, e.g. 2023-08-01.pem, rotates to the newest key.
+func LoadKeySet(dir string, activeKID string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keySet := NewKeySet()
+	for _, file := range files {
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".pem"), ".pub")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key %s: %w", file, err)
+		}
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key %s: %w", file, err)
+		}
+		if existing, ok := keySet.keys[kid]; ok && existing.PrivateKey != nil {
+			continue
+		}
+		keySet.keys[kid] = key
+	}
+	if activeKID == "" {
+		kids := make([]string, 0, len(keySet.keys))
+		for kid, key := range keySet.keys {
+			if key.PrivateKey != nil {
+				kids = append(kids, kid)
+			}
+		}
+		if len(kids) == 0 {
+			return nil, fmt.Errorf("no private key found in %s", dir)
+		}
+		sort.Strings(kids)
+		activeKID = kids[len(kids)-1]
+	}
+	if err = keySet.SetActive(activeKID); err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys: make(map[string]*Key),
+	}
+}
+
+// GenerateEd25519 adds a freshly generated Ed25519 key and makes it the active key.
+func (ks *KeySet) GenerateEd25519(kid string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ks.keys[kid] = &Key{ID: kid, Method: SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: publicKey}
+	return ks.SetActive(kid)
+}
+
+func (ks *KeySet) SetActive(kid string) error {
+	key, ok := ks.keys[kid]
+	if !ok {
+		return fmt.Errorf("key %s not found", kid)
+	}
+	if key.PrivateKey == nil {
+		return fmt.Errorf("key %s has no private key and cannot sign", kid)
+	}
+	ks.active = key
+	return nil
+}
+
+// Sign signs the claims with the active key and records its kid in the token header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.active == nil {
+		return "", errors.New("no active signing key")
+	}
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.PrivateKey)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header, for use with jwt.Parse.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS returns the public keys of the set, sorted by kid.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, PrivateKey: k, PublicKey: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: kid, Method: SigningMethodEdDSA, PrivateKey: k, PublicKey: k.Public()}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, PublicKey: k}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: SigningMethodEdDSA, PublicKey: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// ParseJWKS builds a verification only key set from a JSON Web Key Set, e.g. one published by an identity provider.
+// Keys of unsupported types are skipped.
+func ParseJWKS(jwks JWKS) (*KeySet, error) {
+	keySet := NewKeySet()
+	for _, jwk := range jwks.Keys {
+		switch {
+		case jwk.KeyType == "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modulus of key %s: %w", jwk.KeyID, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exponent of key %s: %w", jwk.KeyID, err)
+			}
+			publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keySet.keys[jwk.KeyID] = &Key{ID: jwk.KeyID, Method: jwt.SigningMethodRS256, PublicKey: publicKey}
+		case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid Ed25519 key %s", jwk.KeyID)
+			}
+			keySet.keys[jwk.KeyID] = &Key{ID: jwk.KeyID, Method: SigningMethodEdDSA, PublicKey: ed25519.PublicKey(x)}
+		}
+	}
+	if len(keySet.keys) == 0 {
+		return nil, errors.New("no supported keys found in JWKS")
+	}
+	return keySet, nil
+}
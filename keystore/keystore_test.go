@@ -0,0 +1,122 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("Error writing key file: %s", err)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	writePEM(t, filepath.Join(dir, "2023-07-01.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating Ed25519 key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("Error marshalling Ed25519 key: %s", err)
+	}
+	writePEM(t, filepath.Join(dir, "2023-08-01.pem"), "PRIVATE KEY", der)
+	retiredKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(&retiredKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error marshalling RSA public key: %s", err)
+	}
+	writePEM(t, filepath.Join(dir, "2023-06-01.pub.pem"), "PUBLIC KEY", der)
+
+	keySet, err := LoadKeySet(dir, "")
+	assert.NoError(t, err, "Unexpected error while loading keys")
+	assert.Equal(t, "2023-08-01", keySet.active.ID, "Newest private key should be active")
+
+	jwks := keySet.JWKS()
+	assert.Len(t, jwks.Keys, 3, "All keys should be published")
+	assert.Equal(t, "2023-06-01", jwks.Keys[0].KeyID, "Retired key should be published")
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType, "RSA key type mismatch")
+	assert.Equal(t, "OKP", jwks.Keys[2].KeyType, "Ed25519 key type mismatch")
+
+	_, err = LoadKeySet(dir, "2023-06-01")
+	assert.Error(t, err, "A public key cannot be the active key")
+}
+
+func TestSignAndVerifyAcrossRotation(t *testing.T) {
+	keySet := NewKeySet()
+	assert.NoError(t, keySet.GenerateEd25519("old"), "Unexpected error while generating key")
+	claims := jwt.StandardClaims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	oldToken, err := keySet.Sign(claims)
+	assert.NoError(t, err, "Unexpected error while signing")
+
+	assert.NoError(t, keySet.GenerateEd25519("new"), "Unexpected error while generating key")
+	newToken, err := keySet.Sign(claims)
+	assert.NoError(t, err, "Unexpected error while signing")
+
+	for _, signed := range []string{oldToken, newToken} {
+		token, err := jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, keySet.Keyfunc)
+		assert.NoError(t, err, "Token should verify after rotation")
+		assert.True(t, token.Valid, "Token should be valid")
+	}
+	token, _ := jwt.Parse(newToken, keySet.Keyfunc)
+	assert.Equal(t, "new", token.Header["kid"], "Token should carry the active kid")
+}
+
+func TestKeyfuncRejectsUnknownKidAndAlgorithm(t *testing.T) {
+	keySet := NewKeySet()
+	assert.NoError(t, keySet.GenerateEd25519("current"), "Unexpected error while generating key")
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{})
+	hmacToken.Header["kid"] = "current"
+	signed, err := hmacToken.SignedString([]byte("secret"))
+	assert.NoError(t, err, "Unexpected error while signing")
+	_, err = jwt.Parse(signed, keySet.Keyfunc)
+	assert.Error(t, err, "Algorithm confusion should be rejected")
+
+	hmacToken.Header["kid"] = "unknown"
+	signed, _ = hmacToken.SignedString([]byte("secret"))
+	_, err = jwt.Parse(signed, keySet.Keyfunc)
+	assert.Error(t, err, "Unknown kid should be rejected")
+}
+
+func TestParseJWKS(t *testing.T) {
+	keySet := NewKeySet()
+	assert.NoError(t, keySet.GenerateEd25519("ed"), "Unexpected error while generating key")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	keySet.keys["rsa"] = &Key{ID: "rsa", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey}
+
+	published, err := ParseJWKS(keySet.JWKS())
+	assert.NoError(t, err, "Unexpected error while parsing JWKS")
+	for _, kid := range []string{"ed", "rsa"} {
+		assert.NoError(t, keySet.SetActive(kid), "Unexpected error while switching key")
+		signed, err := keySet.Sign(jwt.StandardClaims{Subject: "1"})
+		assert.NoError(t, err, "Unexpected error while signing")
+		_, err = jwt.Parse(signed, published.Keyfunc)
+		assert.NoError(t, err, "Token signed with %s should verify against the published keys", kid)
+	}
+
+	_, err = ParseJWKS(JWKS{})
+	assert.Error(t, err, "An empty JWKS should be rejected")
+}
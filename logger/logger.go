@@ -10,6 +10,7 @@ import (
 
 var errorLogger *log.Logger
 var infoLogger *log.Logger
+var auditLogger *log.Logger
 
 func InitLogger() {
 	file, err := os.OpenFile("error.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -25,6 +26,11 @@ func InitLogger() {
 	}
 	infoLogger = log.New(file, "", log.LstdFlags)
 
+	file, err = os.OpenFile("audit.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLogger = log.New(file, "", log.LstdFlags)
 }
 
 func LogError(err error) {
@@ -48,3 +54,13 @@ func LogInfo(v ...any) {
 		}
 	}
 }
+
+// LogAudit records a security relevant action, who performed it and on what, in audit.log.
+func LogAudit(actor string, action string, v ...any) {
+	if auditLogger != nil {
+		err := auditLogger.Output(2, fmt.Sprintf("[AUDIT] actor=%s action=%s %v", actor, action, v))
+		if err != nil {
+			return
+		}
+	}
+}
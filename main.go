@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -9,11 +10,19 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"tigerhall-kittens/controller"
 	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
 	"tigerhall-kittens/logger"
 	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/model"
+	"tigerhall-kittens/oidc"
+	"tigerhall-kittens/password"
+	"tigerhall-kittens/pii"
+	"tigerhall-kittens/ratelimit"
 	"tigerhall-kittens/worker"
+	"time"
 )
 
 func main() {
@@ -29,6 +38,21 @@ func main() {
 		return
 	}
 	defer pool.Close()
+	keys, err := setupKeySet()
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
+	piiKeys, err := setupPIIKeyring()
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
+	passwordPolicy, err := setupPasswordPolicy()
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
 
 	// Set up Kafka client configuration
 	config := sarama.NewConfig()
@@ -46,27 +70,95 @@ func main() {
 			logger.LogError(err)
 		}
 	}()
-	logger.LogInfo("Staring consumer.........................................")
-	go worker.StartConsumer()
 	//DAO
-	user := database.NewUserDB(pool)
+	user := database.NewUserDB(pool, piiKeys, password.Default(), passwordPolicy)
 	animal := database.NewAnimalDB(pool)
 	sighting := database.NewSightingDB(pool)
+	notification := database.NewNotificationDB(pool)
+	token := database.NewTokenDB(pool)
+	identity := database.NewIdentityDB(pool, piiKeys)
+	loginAttempt := database.NewLoginAttemptDB(pool)
+	twoFactor := database.NewTwoFactorDB(pool)
+	apiKey := database.NewAPIKeyDB(pool, piiKeys)
+	audit := database.NewAuditDB(pool)
+	piiStore := database.NewPIIDB(pool, piiKeys)
+	taxonomy := database.NewTaxonomyDB(pool)
+
+	logger.LogInfo("Staring consumer.........................................")
+	go worker.StartConsumer(notification, user)
+	if os.Getenv("PII_DECRYPT") == "true" {
+		go worker.StartDecryption(piiStore, time.Hour)
+	} else {
+		go worker.StartReencryption(piiStore, time.Hour)
+	}
 
 	//Controllers
-	userController := controller.NewUserController(user)
+	userController := controller.NewUserController(user, token, loginAttempt, twoFactor, keys, producer)
 	animalController := controller.NewAnimalController(animal)
 	sightingController := controller.NewSightingController(sighting, producer)
+	notificationController := controller.NewNotificationController(notification)
+	adminController := controller.NewAdminController(user, loginAttempt, twoFactor, audit)
+	jwksController := controller.NewJWKSController(keys)
+	apiKeyController := controller.NewAPIKeyController(apiKey)
+	taxonomyController := controller.NewTaxonomyController(taxonomy)
+	oidcController := controller.NewOIDCController(userController, identity, setupOIDCProviders())
 	//Middlewares
-	jwtMiddleWare := middleware.JWTMiddleware
+	authMiddleWare := middleware.AuthMiddleware
+	apiKeyMiddleWare := middleware.APIKeyMiddleware
+	middleware.SetRevocationList(token)
+	middleware.SetKeySet(keys)
+	middleware.SetAPIKeyStore(apiKey)
+	middleware.SetAccountStatus(user)
+	setupRateLimitStore(pool)
+	signupLimit := rateLimitPolicy("signup", "5/1h", ratelimit.KeyByIP)
+	loginLimit := rateLimitPolicy("login", "20/1m", ratelimit.KeyByIP)
+	accountEmailLimit := rateLimitPolicy("account_email", "5/1h", ratelimit.KeyByIP)
+	animalLimit := rateLimitPolicy("animal", "10/1m", ratelimit.KeyByUser)
+	sightingLimit := rateLimitPolicy("sighting", "30/1m", ratelimit.KeyByUser)
 
 	//Register handlers/controllers
-	http.HandleFunc("/user", userController.CreateUserHandler)
-	http.HandleFunc("/user/login", userController.LoginHandler)
-	http.HandleFunc("/animal", jwtMiddleWare(animalController.AnimalHandler))
-	http.HandleFunc("/sighting", jwtMiddleWare(sightingController.SightingHandler))
+	http.HandleFunc("/.well-known/jwks.json", jwksController.JWKSHandler)
+	http.HandleFunc("/user", middleware.RateLimit(signupLimit, userController.CreateUserHandler, http.MethodPost))
+	http.HandleFunc("/user/login", middleware.RateLimit(loginLimit, userController.LoginHandler))
+	http.HandleFunc("/user/login/2fa", middleware.RateLimit(loginLimit, userController.TwoFactorLoginHandler))
+	http.HandleFunc("/user/login/2fa/enrol", middleware.RateLimit(loginLimit, userController.TwoFactorEnrolmentHandler))
+	http.HandleFunc("/user/me", authMiddleWare(userController.ProfileHandler))
+	http.HandleFunc("/user/me/", authMiddleWare(userController.ProfileHandler))
+	http.HandleFunc("/user/2fa/", authMiddleWare(userController.TwoFactorHandler))
+	http.HandleFunc("/user/api-key", authMiddleWare(apiKeyController.APIKeyHandler))
+	http.HandleFunc("/user/api-key/", authMiddleWare(apiKeyController.APIKeyHandler))
+	http.HandleFunc("/user/token/refresh", userController.RefreshTokenHandler)
+	http.HandleFunc("/user/logout", authMiddleWare(userController.LogoutHandler))
+	http.HandleFunc("/user/oidc/", oidcController.OIDCHandler)
+	http.HandleFunc("/user/email/verify/request", middleware.RateLimit(accountEmailLimit, authMiddleWare(userController.RequestEmailVerificationHandler)))
+	http.HandleFunc("/user/email/verify/confirm", userController.ConfirmEmailVerificationHandler)
+	http.HandleFunc("/user/password/reset/request", middleware.RateLimit(accountEmailLimit, userController.RequestPasswordResetHandler))
+	http.HandleFunc("/user/password/reset/confirm", userController.ConfirmPasswordResetHandler)
+	animalHandler := middleware.RequirePermission(model.PermissionCreateAnimal, animalController.AnimalHandler, http.MethodPost)
+	animalHandler = middleware.RequirePermission(model.PermissionUpdateAnimal, animalHandler, http.MethodPatch, http.MethodPut)
+	animalHandler = middleware.RequirePermission(model.PermissionDeleteAnimal, animalHandler, http.MethodDelete)
+	http.HandleFunc("/animal", apiKeyMiddleWare(middleware.RateLimit(animalLimit, animalHandler, http.MethodPost)))
+	http.HandleFunc("/animal/", apiKeyMiddleWare(middleware.RateLimit(animalLimit, animalHandler, http.MethodPost)))
+	sightingHandler := middleware.RequirePermission(model.PermissionCreateSighting, sightingController.SightingHandler, http.MethodPost)
+	sightingHandler = middleware.RequirePermission(model.PermissionModerateSightings, sightingHandler, http.MethodPatch, http.MethodDelete)
+	if os.Getenv("REQUIRE_VERIFIED_EMAIL_FOR_SIGHTINGS") == "true" {
+		sightingHandler = middleware.RequireVerifiedEmail(sightingHandler, http.MethodPost)
+	}
+	http.HandleFunc("/sighting", apiKeyMiddleWare(middleware.RateLimit(sightingLimit, sightingHandler, http.MethodPost)))
+	http.HandleFunc("/sighting/", apiKeyMiddleWare(middleware.RateLimit(sightingLimit, sightingHandler, http.MethodPost)))
+	http.HandleFunc("/notification", authMiddleWare(notificationController.NotificationHandler))
+	http.HandleFunc("/notification/", authMiddleWare(notificationController.NotificationHandler))
+	http.HandleFunc("/admin/user", authMiddleWare(middleware.RequirePermission(model.PermissionManageUsers, adminController.AdminUserHandler)))
+	http.HandleFunc("/admin/user/", authMiddleWare(middleware.RequirePermission(model.PermissionManageUsers, adminController.AdminUserHandler)))
+	http.HandleFunc("/admin/2fa/roles", authMiddleWare(middleware.RequirePermission(model.PermissionManageUsers, adminController.AdminTwoFactorPolicyHandler)))
+	http.HandleFunc("/admin/2fa/roles/", authMiddleWare(middleware.RequirePermission(model.PermissionManageUsers, adminController.AdminTwoFactorPolicyHandler)))
+	http.HandleFunc("/admin/lockout", authMiddleWare(middleware.RequirePermission(model.PermissionManageUsers, adminController.AdminLockoutHandler)))
+	http.HandleFunc("/admin/animal/", authMiddleWare(middleware.RequirePermission(model.PermissionRestoreAnimal, animalController.AdminAnimalHandler)))
+	http.HandleFunc("/admin/taxon", authMiddleWare(middleware.RequirePermission(model.PermissionManageTaxonomy, taxonomyController.AdminTaxonomyHandler)))
+	http.HandleFunc("/admin/taxon/", authMiddleWare(middleware.RequirePermission(model.PermissionManageTaxonomy, taxonomyController.AdminTaxonomyHandler)))
+	http.HandleFunc("/admin/audit", authMiddleWare(middleware.RequirePermission(model.PermissionReadAuditLog, adminController.AdminAuditHandler)))
 
-	logger.LogError(http.ListenAndServe(":"+os.Getenv("PORT"), nil))
+	logger.LogError(http.ListenAndServe(":"+os.Getenv("PORT"), middleware.RequestID(http.DefaultServeMux)))
 	logger.LogInfo("Server listening at port ", os.Getenv("PORT"))
 	logger.LogInfo("Server exited and released port ", os.Getenv("PORT"))
 }
@@ -88,3 +180,117 @@ func setupDatabase() (*pgxpool.Pool, error) {
 	logger.LogInfo("Database connection pool is set up successfully!")
 	return pool, nil
 }
+
+// setupKeySet loads the JWT signing keys from JWT_KEYS_DIR. Without it an ephemeral key is generated,
+// which is only suitable for local development as tokens stop validating on restart.
+func setupKeySet() (*keystore.KeySet, error) {
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		logger.LogError(errors.New("JWT_KEYS_DIR missing from env file, using an ephemeral signing key"))
+		keys := keystore.NewKeySet()
+		if err := keys.GenerateEd25519("ephemeral"); err != nil {
+			return nil, fmt.Errorf("error generating signing key: %w", err)
+		}
+		return keys, nil
+	}
+	keys, err := keystore.LoadKeySet(keysDir, os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading JWT keys: %w", err)
+	}
+	logger.LogInfo("JWT signing keys loaded successfully!")
+	return keys, nil
+}
+
+// setupPIIKeyring loads the keys personal data is encrypted with from PII_KEYS, e.g. "2024-01:base64key",
+// PII_ACTIVE_KEY_ID and PII_INDEX_KEY. Unlike the JWT keys there is no ephemeral fallback, data encrypted
+// with a lost key cannot be read again.
+func setupPIIKeyring() (*pii.Keyring, error) {
+	keys, err := pii.ParseKeyring(os.Getenv("PII_KEYS"), os.Getenv("PII_ACTIVE_KEY_ID"), os.Getenv("PII_INDEX_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading PII keys: %w", err)
+	}
+	logger.LogInfo("PII encryption keys loaded, encrypting with", keys.ActiveKeyID())
+	return keys, nil
+}
+
+// setupPasswordPolicy requires new passwords to be at least PASSWORD_MIN_LENGTH characters long, 12 by default,
+// and rejects those listed in the PASSWORD_BREACHED_LIST file.
+func setupPasswordPolicy() (*password.Policy, error) {
+	minLength := 12
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("PASSWORD_MIN_LENGTH should be a positive number, got %q", value)
+		}
+		minLength = parsed
+	}
+	policy := password.NewPolicy(minLength)
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		if err := policy.LoadBreachedList(path); err != nil {
+			return nil, fmt.Errorf("error loading breached password list: %w", err)
+		}
+		logger.LogInfo("Breached password list loaded from", path)
+	}
+	return policy, nil
+}
+
+// setupOIDCProviders configures the identity providers listed in OIDC_PROVIDERS, e.g. "partnera,partnerb",
+// from OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_REDIRECT_URL.
+// Providers that cannot be reached are skipped so that password login keeps working.
+func setupOIDCProviders() map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := oidc.NewProvider(name,
+			os.Getenv(prefix+"ISSUER"),
+			os.Getenv(prefix+"CLIENT_ID"),
+			os.Getenv(prefix+"CLIENT_SECRET"),
+			os.Getenv(prefix+"REDIRECT_URL"))
+		if err != nil {
+			logger.LogError(err)
+			continue
+		}
+		providers[name] = provider
+		logger.LogInfo("OIDC provider", name, "configured")
+	}
+	return providers
+}
+
+// setupRateLimitStore keeps rate limit buckets in Postgres when RATE_LIMIT_STORE is "postgres", so that all
+// instances share them, and in memory otherwise.
+func setupRateLimitStore(pool *pgxpool.Pool) {
+	if os.Getenv("RATE_LIMIT_STORE") != "postgres" {
+		return
+	}
+	store := database.NewRateLimitDB(pool)
+	middleware.SetRateLimitStore(store)
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			if _, err := store.PurgeFullBuckets(); err != nil {
+				logger.LogError(err)
+			}
+		}
+	}()
+	logger.LogInfo("Rate limits are shared through Postgres")
+}
+
+// rateLimitPolicy returns the policy named name, read from RATE_LIMIT_<NAME> such as "10/1m" when set.
+func rateLimitPolicy(name string, defaultSpec string, keyBy ratelimit.KeyBy) ratelimit.Policy {
+	policy, err := ratelimit.ParsePolicy(name, defaultSpec, keyBy)
+	if err != nil {
+		panic(err)
+	}
+	if spec := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name)); spec != "" {
+		configured, err := ratelimit.ParsePolicy(name, spec, keyBy)
+		if err != nil {
+			logger.LogError(err)
+			return policy
+		}
+		return configured
+	}
+	return policy
+}
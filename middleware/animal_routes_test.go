@@ -0,0 +1,99 @@
+package middleware_test
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tigerhall-kittens/controller"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/keystore"
+	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/model"
+	"time"
+)
+
+// routedAnimalDB records the writes that reach the database through the animal routes.
+type routedAnimalDB struct {
+	database.IAnimal
+	writes []string
+}
+
+func (f *routedAnimalDB) SetParents(animalId int64, parents model.AnimalParents, actor model.Actor) (*model.Animal, error) {
+	f.writes = append(f.writes, "SetParents")
+	return &model.Animal{ID: animalId, MotherID: parents.MotherID, FatherID: parents.FatherID}, nil
+}
+
+func (f *routedAnimalDB) SetAnimalStatus(animalId int64, update *model.AnimalStatusUpdate, actor model.Actor) (*model.AnimalStatusChange, error) {
+	f.writes = append(f.writes, "SetAnimalStatus")
+	return &model.AnimalStatusChange{AnimalID: animalId, From: model.AnimalStatusActive, To: update.Status, Reason: update.Reason}, nil
+}
+
+func (f *routedAnimalDB) SetIdentifiers(animalId int64, identifiers []model.AnimalIdentifier, actor model.Actor) (*model.Animal, error) {
+	f.writes = append(f.writes, "SetIdentifiers")
+	return &model.Animal{ID: animalId, Identifiers: identifiers}, nil
+}
+
+// animalRoutes wraps the animal handler the way main.go does for /animal/.
+func animalRoutes(animalDB database.IAnimal) http.HandlerFunc {
+	animalController := controller.NewAnimalController(animalDB)
+	animalHandler := middleware.RequirePermission(model.PermissionCreateAnimal, animalController.AnimalHandler, http.MethodPost)
+	animalHandler = middleware.RequirePermission(model.PermissionUpdateAnimal, animalHandler, http.MethodPatch, http.MethodPut)
+	animalHandler = middleware.RequirePermission(model.PermissionDeleteAnimal, animalHandler, http.MethodDelete)
+	return middleware.APIKeyMiddleware(animalHandler)
+}
+
+// serveRoute sends the request through the handler, with a token of the role unless the role is empty.
+func serveRoute(t *testing.T, handler http.HandlerFunc, role model.Role, method string, path string, body string) *httptest.ResponseRecorder {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	middleware.SetKeySet(keys)
+	defer middleware.SetKeySet(nil)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if role != "" {
+		tokenString, err := keys.Sign(model.Claims{
+			Principal:      model.Principal{UserID: 1, Role: role},
+			StandardClaims: jwt.StandardClaims{Id: "jti", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		})
+		if err != nil {
+			t.Fatalf("Error signing token: %s", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAnimalRoutes_Writes(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		write  string
+	}{
+		{http.MethodPut, "/animal/1/parents", `{"mother_id":2,"father_id":3}`, "SetParents"},
+		{http.MethodPut, "/animal/1/status", `{"status":"missing","reason":"Not seen for a month"}`, "SetAnimalStatus"},
+		{http.MethodPut, "/animal/1/identifiers", `[{"scheme":"stripe_id","value":"T-23"}]`, "SetIdentifiers"},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			animalDB := &routedAnimalDB{}
+			handler := animalRoutes(animalDB)
+
+			rr := serveRoute(t, handler, "", test.method, test.path, test.body)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, "Requests without a token should be rejected")
+			rr = serveRoute(t, handler, model.RoleReporter, test.method, test.path, test.body)
+			assert.Equal(t, http.StatusForbidden, rr.Code, "Reporters should not update animals")
+			assert.Empty(t, animalDB.writes, "Rejected requests should not reach the database")
+
+			rr = serveRoute(t, handler, model.RoleRanger, test.method, test.path, test.body)
+			assert.Equal(t, http.StatusOK, rr.Code, "Rangers should update animals")
+			assert.Equal(t, []string{test.write}, animalDB.writes)
+		})
+	}
+}
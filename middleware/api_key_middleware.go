@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"tigerhall-kittens/controller"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/model"
+)
+
+// APIKeyStore authenticates API keys by their hash.
+type APIKeyStore interface {
+	AuthenticateAPIKey(keyHash string) (*model.Principal, error)
+}
+
+var apiKeyStore APIKeyStore
+
+// SetAPIKeyStore configures the store API keys are checked against.
+func SetAPIKeyStore(store APIKeyStore) {
+	apiKeyStore = store
+}
+
+// APIKeyMiddleware authenticates requests carrying an API key in the X-API-Key header and hands every
+// other request to JWTMiddleware. Requests authenticated with a key get the same user_id as their owner's
+// tokens, while their principal is limited to the key's scopes.
+func APIKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(controller.APIKeyHeader)
+		if key == "" {
+			JWTMiddleware(next).ServeHTTP(w, r)
+			return
+		}
+		if apiKeyStore == nil {
+			errRes := controller.ErrorResponse{Error: "API keys are not accepted"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+		principal, err := apiKeyStore.AuthenticateAPIKey(controller.HashAPIKey(key))
+		if errors.Is(err, database.ErrNotFound) {
+			errRes := controller.ErrorResponse{Error: "Invalid API key"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			errRes := controller.ErrorResponse{Error: "Failed to validate API key"}
+			controller.WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "user_id", principal.UserID)
+		ctx = context.WithValue(ctx, "principal", *principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
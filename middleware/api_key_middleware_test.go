@@ -0,0 +1,55 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tigerhall-kittens/controller"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/model"
+)
+
+type apiKeys map[string]model.Principal
+
+func (k apiKeys) AuthenticateAPIKey(keyHash string) (*model.Principal, error) {
+	principal, ok := k[keyHash]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return &principal, nil
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	middleware.SetAPIKeyStore(apiKeys{
+		controller.HashAPIKey("thk_cameratrap"): {UserID: 7, Role: model.RoleRanger, Scopes: []model.Permission{model.PermissionCreateSighting}},
+	})
+	defer middleware.SetAPIKeyStore(nil)
+
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("user_id").(int64)
+		if !ok || userID != 7 {
+			t.Errorf("expected user_id 7 in the request context, but got %v", r.Context().Value("user_id"))
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		key        string
+		permission model.Permission
+		expected   int
+	}{
+		{"thk_cameratrap", model.PermissionCreateSighting, http.StatusOK},
+		{"thk_cameratrap", model.PermissionCreateAnimal, http.StatusForbidden},
+		{"thk_unknown", model.PermissionCreateSighting, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set(controller.APIKeyHeader, test.key)
+		rr := httptest.NewRecorder()
+		middleware.APIKeyMiddleware(middleware.RequirePermission(test.permission, mockHandler)).ServeHTTP(rr, req)
+		if rr.Code != test.expected {
+			t.Errorf("%s for %s: expected status code %d, but got %d", test.key, test.permission, test.expected, rr.Code)
+		}
+	}
+}
@@ -3,56 +3,170 @@ package middleware
 import (
 	"context"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"tigerhall-kittens/controller"
+	"tigerhall-kittens/keystore"
 	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
 )
 
-type CustomClaims struct {
-	UserID int64 `json:"user_id"`
-	jwt.StandardClaims
+// RevocationList reports whether an access token, identified by its jti claim, has been revoked.
+type RevocationList interface {
+	IsAccessTokenRevoked(jti string) (bool, error)
 }
 
+var revocationList RevocationList
+
+// AccountStatus reports whether a user's account has been disabled by an admin.
+type AccountStatus interface {
+	IsUserDisabled(userId int64) (bool, error)
+}
+
+var accountStatus AccountStatus
+
+var keySet *keystore.KeySet
+
+// SetKeySet configures the keys JWT tokens are verified with.
+func SetKeySet(keys *keystore.KeySet) {
+	keySet = keys
+}
+
+// SetRevocationList configures the revocation list consulted for every authenticated request.
+func SetRevocationList(list RevocationList) {
+	revocationList = list
+}
+
+// SetAccountStatus configures the check that rejects tokens of disabled accounts.
+func SetAccountStatus(status AccountStatus) {
+	accountStatus = status
+}
+
+// JWTMiddleware lets GET requests through and requires a valid JWT token for every other request method.
 func JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
-		case http.MethodPost:
-			tokenString := r.Header.Get("Authorization")
-			if tokenString == "" {
-				errRes := controller.ErrorResponse{Error: "Missing JWT token"}
-				controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
-				return
+		case http.MethodGet:
+			next.ServeHTTP(w, r)
+		default:
+			AuthMiddleware(next).ServeHTTP(w, r)
+		}
+	}
+}
+
+// AuthMiddleware requires a valid JWT token for every request method.
+func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" {
+			errRes := controller.ErrorResponse{Error: "Missing JWT token"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+		tokenString = strings.Replace(tokenString, "Bearer ", "", 1)
+		token, err := jwt.ParseWithClaims(tokenString, &model.Claims{}, func(token *jwt.Token) (interface{}, error) {
+			if keySet == nil {
+				logger.LogError(errors.New("JWT key set is not configured"))
+				return nil, errors.New("JWT key set is not configured")
 			}
-			tokenString = strings.Replace(tokenString, "Bearer ", "", 1)
-			token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-				jwtSecret := os.Getenv("JWT_SECRET")
-				if jwtSecret == "" {
-					logger.LogError(errors.New("JWT_SECRET missing from secret/env file"))
-					return nil, errors.New("JWT_SECRET missing from secret/env file")
+			return keySet.Keyfunc(token)
+		})
+		// Email verification and password reset tokens carry an audience, access tokens never do
+		if err != nil || !token.Valid || token.Claims.(*model.Claims).Audience != "" {
+			errRes := controller.ErrorResponse{Error: "Invalid JWT token"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+		if claims, ok := token.Claims.(*model.Claims); ok {
+			if revocationList != nil && claims.Id != "" {
+				revoked, err := revocationList.IsAccessTokenRevoked(claims.Id)
+				if err != nil {
+					errRes := controller.ErrorResponse{Error: "Failed to validate JWT token"}
+					controller.WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					errRes := controller.ErrorResponse{Error: "JWT token has been revoked"}
+					controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+					return
 				}
-				return []byte(jwtSecret), nil
-			})
-			if err != nil || !token.Valid {
-				errRes := controller.ErrorResponse{Error: "Invalid JWT token"}
-				controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
-				return
 			}
-			if claims, ok := token.Claims.(*CustomClaims); ok {
-				ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-				next.ServeHTTP(w, r.WithContext(ctx))
-			} else {
-				errRes := controller.ErrorResponse{Error: "Invalid JWT claims"}
-				controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
-				return
+			if accountStatus != nil {
+				disabled, err := accountStatus.IsUserDisabled(claims.UserID)
+				if err != nil {
+					errRes := controller.ErrorResponse{Error: "Failed to validate JWT token"}
+					controller.WriteJSONResponse(w, errRes, http.StatusInternalServerError)
+					return
+				}
+				if disabled {
+					errRes := controller.ErrorResponse{Error: "Account has been disabled"}
+					controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+					return
+				}
 			}
-			break
-		case http.MethodGet:
+			ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+			ctx = context.WithValue(ctx, "principal", claims.Principal)
+			ctx = context.WithValue(ctx, "token_id", claims.Id)
+			ctx = context.WithValue(ctx, "token_expires_at", time.Unix(claims.ExpiresAt, 0))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		} else {
+			errRes := controller.ErrorResponse{Error: "Invalid JWT claims"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+	}
+}
+
+// RequirePermission rejects requests whose principal's role does not grant the permission.
+// When methods are given only requests with one of those methods are checked.
+// It must be wrapped by JWTMiddleware or AuthMiddleware so that the principal is available.
+func RequirePermission(permission model.Permission, next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(methods) > 0 && !containsMethod(methods, r.Method) {
 			next.ServeHTTP(w, r)
+			return
+		}
+		principal, ok := r.Context().Value("principal").(model.Principal)
+		if !ok {
+			errRes := controller.ErrorResponse{Error: "Missing JWT token"}
+			controller.WriteJSONResponse(w, errRes, http.StatusUnauthorized)
+			return
+		}
+		if !principal.Can(permission) {
+			errRes := controller.ErrorResponse{Error: "You are not allowed to perform this action"}
+			controller.WriteJSONResponse(w, errRes, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
 
+// RequireVerifiedEmail rejects requests of principals that have not verified their email address.
+// When methods are given only requests with one of those methods are checked.
+func RequireVerifiedEmail(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(methods) > 0 && !containsMethod(methods, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, ok := r.Context().Value("principal").(model.Principal)
+		if !ok || !principal.EmailVerified {
+			errRes := controller.ErrorResponse{Error: "Please verify your email address first"}
+			controller.WriteJSONResponse(w, errRes, http.StatusForbidden)
+			return
 		}
+		next.ServeHTTP(w, r)
 	}
 }
@@ -1,12 +1,17 @@
 package middleware_test
 
 import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/joho/godotenv"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"tigerhall-kittens/keystore"
 	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/model"
+	"time"
 )
 
 func TestJWTMiddleware_ValidToken(t *testing.T) {
@@ -88,3 +93,184 @@ func TestJWTMiddleware_MissingToken(t *testing.T) {
 // Add more test cases to cover other scenarios, such as invalid tokens and invalid claims.
 // Remember to mock the necessary dependencies, such as jwt.ParseWithClaims and controller.WriteJSONResponse.
 // The above tests demonstrate basic scenarios for the JWTMiddleware function.
+
+type revokedJTIs map[string]bool
+
+func (r revokedJTIs) IsAccessTokenRevoked(jti string) (bool, error) {
+	return r[jti], nil
+}
+
+func TestJWTMiddleware_RevokedToken(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	middleware.SetKeySet(keys)
+	defer middleware.SetKeySet(nil)
+	middleware.SetRevocationList(revokedJTIs{"revokedjti": true})
+	defer middleware.SetRevocationList(nil)
+
+	claims := model.Claims{
+		Principal: model.Principal{UserID: 1},
+		StandardClaims: jwt.StandardClaims{
+			Id:        "revokedjti",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+	req := httptest.NewRequest("POST", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	rr := httptest.NewRecorder()
+
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("mockHandler should not be called for a revoked token")
+	})
+	middleware.JWTMiddleware(mockHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := middleware.RequirePermission(model.PermissionCreateAnimal, mockHandler, http.MethodPost)
+
+	tests := []struct {
+		method   string
+		role     model.Role
+		expected int
+	}{
+		{http.MethodPost, model.RoleReporter, http.StatusForbidden},
+		{http.MethodPost, model.RoleRanger, http.StatusOK},
+		{http.MethodPost, model.RoleAdmin, http.StatusOK},
+		{http.MethodGet, model.RoleReporter, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/test", nil)
+		ctx := context.WithValue(req.Context(), "principal", model.Principal{UserID: 1, Role: test.role})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		if rr.Code != test.expected {
+			t.Errorf("%s as %s: expected status code %d, but got %d", test.method, test.role, test.expected, rr.Code)
+		}
+	}
+}
+
+func TestJWTMiddleware_RejectsAccountTokens(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	middleware.SetKeySet(keys)
+	defer middleware.SetKeySet(nil)
+
+	tokenString, err := keys.Sign(jwt.StandardClaims{
+		Id:        "resetjti",
+		Audience:  "password_reset",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+	req := httptest.NewRequest("POST", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	rr := httptest.NewRecorder()
+
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("mockHandler should not be called for a password reset token")
+	})
+	middleware.JWTMiddleware(mockHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+type disabledUsers map[int64]bool
+
+func (d disabledUsers) IsUserDisabled(userId int64) (bool, error) {
+	return d[userId], nil
+}
+
+func TestAuthMiddleware_DisabledAccount(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	middleware.SetKeySet(keys)
+	defer middleware.SetKeySet(nil)
+	middleware.SetAccountStatus(disabledUsers{2: true})
+	defer middleware.SetAccountStatus(nil)
+
+	for userID, expected := range map[int64]int{1: http.StatusOK, 2: http.StatusUnauthorized} {
+		tokenString, err := keys.Sign(model.Claims{
+			Principal: model.Principal{UserID: userID},
+			StandardClaims: jwt.StandardClaims{
+				Id:        "jti",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error signing token: %s", err)
+		}
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+
+		mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		middleware.AuthMiddleware(mockHandler).ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("user %d: expected status code %d, but got %d", userID, expected, rr.Code)
+		}
+	}
+}
+
+func TestJWTMiddleware_WriteMethods(t *testing.T) {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("testkey"); err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	middleware.SetKeySet(keys)
+	defer middleware.SetKeySet(nil)
+	tokenString, err := keys.Sign(model.Claims{
+		Principal:      model.Principal{UserID: 1},
+		StandardClaims: jwt.StandardClaims{Id: "jti", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	})
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		called := false
+		mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			if _, ok := r.Context().Value("user_id").(int64); !ok {
+				t.Errorf("%s: user_id not set in the request context", method)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		req := httptest.NewRequest(method, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+		middleware.JWTMiddleware(mockHandler).ServeHTTP(rr, req)
+		if !called || rr.Code != http.StatusNoContent {
+			t.Errorf("%s with a valid token: expected the handler to run, got status code %d", method, rr.Code)
+		}
+
+		called = false
+		rr = httptest.NewRecorder()
+		middleware.JWTMiddleware(mockHandler).ServeHTTP(rr, httptest.NewRequest(method, "/test", nil))
+		if called || rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a token: expected status code %d, but got %d", method, http.StatusUnauthorized, rr.Code)
+		}
+	}
+}
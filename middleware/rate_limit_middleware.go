@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tigerhall-kittens/controller"
+	"tigerhall-kittens/logger"
+	"tigerhall-kittens/ratelimit"
+)
+
+var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// SetRateLimitStore configures where rate limit buckets are kept, in memory unless configured otherwise.
+func SetRateLimitStore(store ratelimit.Store) {
+	rateLimitStore = store
+}
+
+// RateLimit rejects requests with 429 once the client has used up the policy's bucket. Policies keyed by user
+// must be wrapped by an authenticating middleware so that the user_id is available.
+// When methods are given only requests with one of those methods are limited.
+// Requests are let through when the store fails, an outage of the store should not take the service down.
+func RateLimit(policy ratelimit.Policy, next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(methods) > 0 && !containsMethod(methods, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result, err := rateLimitStore.Take(rateLimitKey(policy, r), policy, time.Now())
+		if err != nil {
+			logger.LogError(err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Period)))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			errRes := controller.ErrorResponse{Error: "Too many requests, please try again later"}
+			controller.WriteJSONResponse(w, errRes, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func rateLimitKey(policy ratelimit.Policy, r *http.Request) string {
+	if policy.KeyBy == ratelimit.KeyByUser {
+		if userID, ok := r.Context().Value("user_id").(int64); ok {
+			return policy.Name + ":user:" + strconv.FormatInt(userID, 10)
+		}
+	}
+	return policy.Name + ":ip:" + controller.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,60 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	middleware.SetRateLimitStore(ratelimit.NewMemoryStore())
+	defer middleware.SetRateLimitStore(ratelimit.NewMemoryStore())
+
+	policy := ratelimit.Policy{Name: "test", Limit: 2, Period: time.Minute, KeyBy: ratelimit.KeyByUser}
+	handler := middleware.RateLimit(policy, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, http.MethodPost)
+
+	request := func(method string, userID int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test", nil)
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := request(http.MethodPost, 1); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status code %d, but got %d", i, http.StatusOK, rr.Code)
+		}
+	}
+	rr := request(http.MethodPost, 1)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "30" || rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("RateLimit-Limit") != "2" {
+		t.Errorf("unexpected rate limit headers %v", rr.Header())
+	}
+	var errRes map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&errRes); err != nil || errRes["error"] == "" {
+		t.Errorf("expected a JSON error, got %q", rr.Body.String())
+	}
+
+	if rr := request(http.MethodGet, 1); rr.Code != http.StatusOK {
+		t.Errorf("GET should not be limited, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, 2); rr.Code != http.StatusOK {
+		t.Errorf("other users should not be limited, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, 0); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("anonymous requests should be limited by IP address, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,32 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tigerhall-kittens/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value("request_id").(string)
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(middleware.RequestIDHeader, "proxy-assigned.1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen != "proxy-assigned.1" || rr.Header().Get(middleware.RequestIDHeader) != "proxy-assigned.1" {
+		t.Errorf("expected the incoming request id to be kept, got %q", seen)
+	}
+
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(middleware.RequestIDHeader, "not a valid\nid")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if len(seen) != 32 || rr.Header().Get(middleware.RequestIDHeader) != seen {
+		t.Errorf("expected a new request id, got %q", seen)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"tigerhall-kittens/controller"
+	"tigerhall-kittens/database"
+	"tigerhall-kittens/middleware"
+	"tigerhall-kittens/model"
+)
+
+// routedSightingDB records the writes that reach the database through the sighting routes.
+type routedSightingDB struct {
+	database.ISighting
+	writes []string
+}
+
+func (f *routedSightingDB) UpdateSighting(sightingId int64, update *model.SightingUpdate, actor model.Actor) (*model.SightingReqResp, error) {
+	f.writes = append(f.writes, "UpdateSighting")
+	return &model.SightingReqResp{Sighting: model.Sighting{ID: sightingId, SpottingTimestamp: *update.SpottingTimestamp}}, nil
+}
+
+func (f *routedSightingDB) DeleteSighting(sightingId int64, actor model.Actor) error {
+	f.writes = append(f.writes, "DeleteSighting")
+	return nil
+}
+
+// sightingRoutes wraps the sighting handler the way main.go does for /sighting/.
+func sightingRoutes(sightingDB database.ISighting) http.HandlerFunc {
+	sightingController := controller.NewSightingController(sightingDB, nil)
+	sightingHandler := middleware.RequirePermission(model.PermissionCreateSighting, sightingController.SightingHandler, http.MethodPost)
+	sightingHandler = middleware.RequirePermission(model.PermissionModerateSightings, sightingHandler, http.MethodPatch, http.MethodDelete)
+	return middleware.APIKeyMiddleware(sightingHandler)
+}
+
+func TestSightingRoutes_Moderation(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		status int
+		write  string
+	}{
+		{http.MethodPatch, `{"spotting_timestamp":"2023-11-20T09:30:00Z"}`, http.StatusOK, "UpdateSighting"},
+		{http.MethodDelete, "", http.StatusNoContent, "DeleteSighting"},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			sightingDB := &routedSightingDB{}
+			handler := sightingRoutes(sightingDB)
+
+			rr := serveRoute(t, handler, "", test.method, "/sighting/1", test.body)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, "Requests without a token should be rejected")
+			for _, role := range []model.Role{model.RoleReporter, model.RoleRanger} {
+				rr = serveRoute(t, handler, role, test.method, "/sighting/1", test.body)
+				assert.Equal(t, http.StatusForbidden, rr.Code, "Only moderators should moderate sightings, not %s", role)
+			}
+			assert.Empty(t, sightingDB.writes, "Rejected requests should not reach the database")
+
+			rr = serveRoute(t, handler, model.RoleModerator, test.method, "/sighting/1", test.body)
+			assert.Equal(t, test.status, rr.Code, "Moderators should moderate any sighting")
+			assert.Equal(t, []string{test.write}, sightingDB.writes)
+		})
+	}
+}
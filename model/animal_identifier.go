@@ -0,0 +1,26 @@
+package model
+
+// IdentifierScheme is the kind of an identifier an animal is known by besides its name.
+type IdentifierScheme string
+
+const (
+	IdentifierSchemeCollar           IdentifierScheme = "collar"
+	IdentifierSchemeStripeID         IdentifierScheme = "stripe_id"
+	IdentifierSchemeExternalRegistry IdentifierScheme = "external_registry"
+	IdentifierSchemeNickname         IdentifierScheme = "nickname"
+)
+
+func (s IdentifierScheme) Valid() bool {
+	switch s {
+	case IdentifierSchemeCollar, IdentifierSchemeStripeID, IdentifierSchemeExternalRegistry, IdentifierSchemeNickname:
+		return true
+	}
+	return false
+}
+
+// AnimalIdentifier is a radio collar ID, stripe pattern ID, ID in another registry or local nickname of an animal.
+// A value belongs to one animal per scheme, ignoring case.
+type AnimalIdentifier struct {
+	Scheme IdentifierScheme `json:"scheme"`
+	Value  string           `json:"value"`
+}
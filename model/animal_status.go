@@ -0,0 +1,36 @@
+package model
+
+// AnimalStatus is where an animal is in its life, as far as the rangers know.
+type AnimalStatus string
+
+const (
+	AnimalStatusActive         AnimalStatus = "active"
+	AnimalStatusMissing        AnimalStatus = "missing"
+	AnimalStatusRehabilitation AnimalStatus = "rehabilitation"
+	AnimalStatusRelocated      AnimalStatus = "relocated"
+	AnimalStatusDeceased       AnimalStatus = "deceased"
+)
+
+// animalStatusTransitions lists the statuses an animal can be given from each status. Deceased is final.
+var animalStatusTransitions = map[AnimalStatus][]AnimalStatus{
+	AnimalStatusActive:         {AnimalStatusMissing, AnimalStatusRehabilitation, AnimalStatusRelocated, AnimalStatusDeceased},
+	AnimalStatusMissing:        {AnimalStatusActive, AnimalStatusRehabilitation, AnimalStatusRelocated, AnimalStatusDeceased},
+	AnimalStatusRehabilitation: {AnimalStatusActive, AnimalStatusRelocated, AnimalStatusDeceased},
+	AnimalStatusRelocated:      {AnimalStatusActive, AnimalStatusMissing, AnimalStatusRehabilitation, AnimalStatusDeceased},
+	AnimalStatusDeceased:       {},
+}
+
+func (s AnimalStatus) Valid() bool {
+	_, ok := animalStatusTransitions[s]
+	return ok
+}
+
+// CanBecome reports whether an animal of the status can be given the next one.
+func (s AnimalStatus) CanBecome(next AnimalStatus) bool {
+	for _, status := range animalStatusTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
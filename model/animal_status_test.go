@@ -0,0 +1,27 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAnimalStatusCanBecome(t *testing.T) {
+	assert.True(t, AnimalStatusActive.CanBecome(AnimalStatusMissing))
+	assert.True(t, AnimalStatusMissing.CanBecome(AnimalStatusActive), "Missing animals can be found again")
+	assert.True(t, AnimalStatusRehabilitation.CanBecome(AnimalStatusRelocated), "Rehabilitated animals can be released elsewhere")
+	assert.False(t, AnimalStatusRehabilitation.CanBecome(AnimalStatusMissing), "Animals in rehabilitation are in care")
+	assert.False(t, AnimalStatusActive.CanBecome(AnimalStatusActive), "Transitions should change the status")
+	for status := range animalStatusTransitions {
+		assert.False(t, AnimalStatusDeceased.CanBecome(status), "Deceased should be final")
+		if status != AnimalStatusDeceased {
+			assert.True(t, status.CanBecome(AnimalStatusDeceased), "%s animals can die", status)
+		}
+	}
+	assert.False(t, AnimalStatus("asleep").CanBecome(AnimalStatusActive), "Unknown statuses have no transitions")
+}
+
+func TestAnimalStatusValid(t *testing.T) {
+	assert.True(t, AnimalStatusRelocated.Valid())
+	assert.False(t, AnimalStatus("asleep").Valid())
+	assert.False(t, AnimalStatus("").Valid())
+}
@@ -2,31 +2,270 @@ package model
 
 import (
 	"github.com/dgrijalva/jwt-go"
+	"time"
 )
 
 type User struct {
-	ID       int64
-	Username string
-	Password string
-	Email    string
+	ID            int64
+	Username      string
+	Password      string
+	Email         string
+	Role          Role
+	EmailVerified bool
+	Disabled      bool
 }
 
 type Claims struct {
-	UserID   int64  `json:"user_id,omitempty"`
-	Username string `json:"username,omitempty"`
-	Email    string `json:"email_id,omitempty"`
+	Principal
 	jwt.StandardClaims
 }
 
+// Animal is given its type and variant by name, which have to be a species of the taxonomy and one of its
+// subspecies. Responses also carry the IDs of the species and subspecies. Parents have to be born before the
+// animal, and cubs registered as a litter carry its ID. New animals are active, their status is then changed
+// through its transitions. Identifiers are the other names and IDs it is known by, see AnimalIdentifier.
 type Animal struct {
-	ID          int64  `json:"id,omitempty"`
-	Name        string `json:"name,omitempty"`
-	Type        string `json:"type,omitempty"`
-	Variant     string `json:"variant,omitempty"`
-	DateOfBirth string `json:"date_of_birth,omitempty"`
+	ID           int64              `json:"id,omitempty"`
+	Name         string             `json:"name,omitempty"`
+	Type         string             `json:"type,omitempty"`
+	Variant      string             `json:"variant,omitempty"`
+	DateOfBirth  string             `json:"date_of_birth,omitempty"`
+	Description  string             `json:"description,omitempty"`
+	SpeciesID    int64              `json:"species_id,omitempty"`
+	SubspeciesID *int64             `json:"subspecies_id,omitempty"`
+	MotherID     *int64             `json:"mother_id,omitempty"`
+	FatherID     *int64             `json:"father_id,omitempty"`
+	LitterID     *int64             `json:"litter_id,omitempty"`
+	Status       AnimalStatus       `json:"status,omitempty"`
+	Identifiers  []AnimalIdentifier `json:"identifiers,omitempty"`
+}
+
+// AnimalStatusUpdate gives an animal a new status. The reason is mandatory and a sighting of the animal can back it up.
+type AnimalStatusUpdate struct {
+	Status     AnimalStatus `json:"status"`
+	Reason     string       `json:"reason"`
+	SightingID *int64       `json:"sighting_id"`
+}
+
+// AnimalStatusChange records who changed the status of an animal, when and why.
+type AnimalStatusChange struct {
+	ID            int64        `json:"id"`
+	AnimalID      int64        `json:"animal_id"`
+	From          AnimalStatus `json:"from"`
+	To            AnimalStatus `json:"to"`
+	Reason        string       `json:"reason"`
+	SightingID    *int64       `json:"sighting_id,omitempty"`
+	ActorID       int64        `json:"actor_id,omitempty"`
+	ActorUsername string       `json:"actor_username,omitempty"`
+	CreatedAt     string       `json:"created_at"`
+}
+
+// AnimalParents replaces both parents of an animal, nil for an unknown parent.
+type AnimalParents struct {
+	MotherID *int64 `json:"mother_id"`
+	FatherID *int64 `json:"father_id"`
+}
+
+// FamilyTree is an animal with its ancestors and descendants. Ancestors only have their parents filled in and
+// descendants their offspring, oldest first.
+type FamilyTree struct {
+	Animal
+	Mother    *FamilyTree   `json:"mother,omitempty"`
+	Father    *FamilyTree   `json:"father,omitempty"`
+	Offspring []*FamilyTree `json:"offspring,omitempty"`
+}
+
+// LitterRequest registers the cubs a mother gave birth to at once, seen together in a single sighting. Cubs are of
+// their mother's type and take her variant unless they are given their own.
+type LitterRequest struct {
+	FatherID    *int64   `json:"father_id"`
+	DateOfBirth string   `json:"date_of_birth"`
+	Cubs        []Animal `json:"cubs"`
+	Sighting    Sighting `json:"sighting"`
+}
+
+// Litter is the cubs a mother gave birth to at once, each with the sighting it was registered with.
+type Litter struct {
+	ID          int64           `json:"id"`
+	MotherID    int64           `json:"mother_id"`
+	FatherID    *int64          `json:"father_id,omitempty"`
+	DateOfBirth string          `json:"date_of_birth"`
+	Cubs        []AnimalReqResp `json:"cubs"`
+}
+
+// AnimalMergeRequest names the duplicate record to merge into an animal.
+type AnimalMergeRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+// AnimalMerge is an animal after a duplicate was merged into it, with the sightings that no longer pass the
+// distance check now that the sightings of both are on one timeline.
+type AnimalMerge struct {
+	Animal         Animal             `json:"animal"`
+	DuplicateID    int64              `json:"duplicate_id"`
+	MovedSightings int                `json:"moved_sightings"`
+	Conflicts      []SightingConflict `json:"conflicts"`
+}
+
+// SightingConflict is a sighting within the threshold distance of the sighting before it, when one of them came
+// from the duplicate and the other did not.
+type SightingConflict struct {
+	SightingID         int64   `json:"sighting_id"`
+	PreviousSightingID int64   `json:"previous_sighting_id"`
+	DistanceKm         float64 `json:"distance_km"`
+}
+
+// Ranks of the taxonomy.
+const (
+	TaxonRankSpecies    = "species"
+	TaxonRankSubspecies = "subspecies"
+)
+
+// ConservationStatuses are the categories of the IUCN Red List, from least concern to not evaluated.
+var ConservationStatuses = []string{"LC", "NT", "VU", "EN", "CR", "EW", "EX", "DD", "NE"}
+
+// Taxon is a species or, when it has a parent, a subspecies of the parent species. Animals give its name as their
+// type or variant.
+type Taxon struct {
+	ID                 int64   `json:"id"`
+	ParentID           *int64  `json:"parent_id,omitempty"`
+	Rank               string  `json:"rank"`
+	Name               string  `json:"name"`
+	ScientificName     *string `json:"scientific_name,omitempty"`
+	ConservationStatus *string `json:"conservation_status,omitempty"`
+	// CommonNames maps BCP 47 language tags to the name in the language.
+	CommonNames map[string]string `json:"common_names"`
+}
+
+// TaxonUpdate holds the fields of a taxon to change, nil fields are left as they are. Empty strings clear the
+// scientific name and conservation status, and common names are replaced as a whole.
+type TaxonUpdate struct {
+	Name               *string           `json:"name"`
+	ScientificName     *string           `json:"scientific_name"`
+	ConservationStatus *string           `json:"conservation_status"`
+	CommonNames        map[string]string `json:"common_names"`
+}
+
+// Sort keys of the animal list.
+const (
+	AnimalSortLastSeen = "last_seen"
+	AnimalSortName     = "name"
+	AnimalSortAge      = "age"
+)
+
+// AnimalFilter selects a page of animals. Zero values match everything. Ages are in whole years from the date of
+// birth, the seen window and bounding box apply to the latest sighting and SeenBefore is exclusive.
+type AnimalFilter struct {
+	Name        string
+	NamePrefix  string
+	Type        string
+	Variant     string
+	MinAge      *int
+	MaxAge      *int
+	SeenAfter   *time.Time
+	SeenBefore  *time.Time
+	BoundingBox *BoundingBox
+	// Statuses match animals of any of them, all but deceased animals when empty.
+	Statuses []AnimalStatus
+	// Sort is one of the AnimalSort keys, last seen when empty.
+	Sort       string
+	Descending bool
+	Cursor     *Cursor
+	Limit      int
+	WithTotal  bool
+}
+
+// AnimalSearch finds animals by name, variant and description, narrowed by the filters of the animal list. The
+// sort of the filter is ignored, results are ordered by relevance.
+type AnimalSearch struct {
+	AnimalFilter
+	Query     string
+	Highlight bool
+}
+
+// AnimalSearchResult is an animal found by a search with where it was last seen and how well it matched.
+type AnimalSearchResult struct {
+	Animal
+	Location          Point             `json:"location,omitempty"`
+	SpottingTimestamp string            `json:"spotting_timestamp"`
+	Rank              float64           `json:"rank"`
+	Highlights        *AnimalHighlights `json:"highlights,omitempty"`
+}
+
+// AnimalHighlights are the searched fields with the matching words wrapped in <mark> tags, the rest HTML escaped.
+// The description is cut down to the fragments around the matches.
+type AnimalHighlights struct {
+	Name        string `json:"name"`
+	Variant     string `json:"variant"`
 	Description string `json:"description,omitempty"`
 }
 
+// SightingUpdate is a moderator's correction of a sighting, fields that are nil are left unchanged.
+type SightingUpdate struct {
+	Location          *Point  `json:"location"`
+	SpottingTimestamp *string `json:"spotting_timestamp"`
+}
+
+// SightingFilter selects a page of the sightings of an animal, latest first.
+type SightingFilter struct {
+	AnimalID  int64
+	Cursor    *Cursor
+	Limit     int
+	WithTotal bool
+}
+
+// Cursor is a position in a list ordered by a sort key and then by ID. A page starting at the cursor holds the rows
+// after it, or the rows before it when Backward. Sort and Descending tell which order the cursor belongs to.
+type Cursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Key        string `json:"k"`
+	ID         int64  `json:"i"`
+	Backward   bool   `json:"b,omitempty"`
+}
+
+// Page is a page of a list with the opaque cursors of the pages around it. HasMore tells whether there is a next
+// page. Total is the number of items of the whole list, when it was asked for.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// BoundingBox is an area between two corners, longitudes and latitudes included.
+type BoundingBox struct {
+	MinLongitude float64
+	MinLatitude  float64
+	MaxLongitude float64
+	MaxLatitude  float64
+}
+
+// AnimalUpdate holds the fields of an animal to change, nil fields are left as they are.
+type AnimalUpdate struct {
+	Name        *string `json:"name"`
+	Variant     *string `json:"variant"`
+	DateOfBirth *string `json:"date_of_birth"`
+	Description *string `json:"description"`
+}
+
+// AnimalDetail is an animal along with a summary of all of its sightings.
+type AnimalDetail struct {
+	Animal
+	FirstSeen         *SeenAt `json:"first_seen,omitempty"`
+	LastSeen          *SeenAt `json:"last_seen,omitempty"`
+	TotalSightings    int64   `json:"total_sightings"`
+	DistinctReporters int64   `json:"distinct_reporters"`
+	// LatestImage references the image of the latest sighting that has one, without its data.
+	LatestImage *Image `json:"latest_image,omitempty"`
+}
+
+type SeenAt struct {
+	SpottingTimestamp string `json:"spotting_timestamp"`
+	Location          Point  `json:"location"`
+}
+
 type Image struct {
 	ID       int64  `json:"id,omitempty"`
 	FileName string `json:"filename,omitempty"`
@@ -57,3 +296,197 @@ type SightingReqResp struct {
 	AnimalID int64 `json:"animal_id,omitempty"`
 	Sighting
 }
+
+// Recipient is a user to notify. Events only carry the user ID, the worker looks the email up so that it does
+// not end up in Kafka; Email is still read from events produced before.
+type Recipient struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+}
+
+type SightingEvent struct {
+	AnimalID   int64       `json:"animal_id"`
+	SightingID int64       `json:"sighting_id"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+type Notification struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"-"`
+	AnimalID   int64  `json:"animal_id"`
+	SightingID int64  `json:"sighting_id"`
+	Message    string `json:"message"`
+	Read       bool   `json:"read"`
+	ReadAt     string `json:"read_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	FamilyID  string
+	TokenHash string
+	AccessJTI string
+	ExpiresAt time.Time
+}
+
+// ExternalIdentity is a user account at a partner identity provider. EmailVerified is whether the provider
+// verified the email.
+type ExternalIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// AccountEmail is an account related email, e.g. a verification link, queued for the worker to send. Like sighting
+// events it only carries the user ID and the worker looks the email up; To is still read from emails queued before.
+// The body holds a live token and must not be logged.
+type AccountEmail struct {
+	UserID  int64  `json:"user_id"`
+	To      string `json:"to,omitempty"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// LoginKey identifies what failed login attempts are counted against, a username or a client IP.
+type LoginKey struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+type Lockout struct {
+	LoginKey
+	Failures      int    `json:"failures"`
+	LastFailureAt string `json:"last_failure_at"`
+	LockedUntil   string `json:"locked_until"`
+}
+
+// TOTP is the authenticator app enrolment of a user. It is pending until a first code has been confirmed.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// APIKey lets machine clients such as camera traps act on behalf of its owner, limited to its scopes.
+// Only the hash of the key is stored, the key itself is returned once on creation.
+type APIKey struct {
+	ID         int64        `json:"id"`
+	UserID     int64        `json:"-"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	KeyHash    string       `json:"-"`
+	Scopes     []Permission `json:"scopes"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	LastUsedAt string       `json:"last_used_at,omitempty"`
+	RevokedAt  string       `json:"revoked_at,omitempty"`
+	CreatedAt  string       `json:"created_at"`
+}
+
+// Profile is what a user sees and may change about their own account.
+type Profile struct {
+	ID               int64  `json:"id"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	Role             Role   `json:"role"`
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at,omitempty"`
+}
+
+// UserExport holds everything stored about a user, for data subject access requests.
+type UserExport struct {
+	Profile       Profile            `json:"profile"`
+	Identities    []LinkedIdentity   `json:"identities"`
+	Sightings     []ReportedSighting `json:"sightings"`
+	Notifications []Notification     `json:"notifications"`
+	APIKeys       []APIKey           `json:"api_keys"`
+	ExportedAt    string             `json:"exported_at"`
+}
+
+type LinkedIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email,omitempty"`
+	LinkedAt string `json:"linked_at"`
+}
+
+type ReportedSighting struct {
+	ID                int64  `json:"id"`
+	AnimalID          int64  `json:"animal_id"`
+	AnimalName        string `json:"animal_name"`
+	Location          Point  `json:"location"`
+	SpottingTimestamp string `json:"spotting_timestamp"`
+	ImageFileName     string `json:"image_filename,omitempty"`
+	CreatedAt         string `json:"created_at"`
+}
+
+// AdminUser is a user as seen by admins managing accounts.
+type AdminUser struct {
+	Profile
+	Disabled       bool   `json:"disabled"`
+	DisabledAt     string `json:"disabled_at,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+}
+
+// UserFilter selects users for admins. Query matches part of the username or the whole email address.
+type UserFilter struct {
+	Query    string
+	Role     Role
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+const (
+	AdminActionDisable     = "disable"
+	AdminActionEnable      = "enable"
+	AdminActionForceLogout = "force_logout"
+)
+
+// UserAdminAction records an admin acting on an account and why.
+type UserAdminAction struct {
+	ID            int64  `json:"id"`
+	UserID        int64  `json:"user_id"`
+	ActorID       int64  `json:"actor_id,omitempty"`
+	ActorUsername string `json:"actor_username,omitempty"`
+	Action        string `json:"action"`
+	Reason        string `json:"reason"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// Actor is who makes a change and the request it is made in, attributed in the audit log.
+// A zero UserID is an anonymous change such as signing up.
+type Actor struct {
+	UserID    int64
+	RequestID string
+}
+
+// AuditEntry is a change of a user, animal, animal identifier, litter, sighting, image or taxon. Before and After only hold the changed columns of updates.
+type AuditEntry struct {
+	ID            int64                  `json:"id"`
+	ActorID       int64                  `json:"actor_id,omitempty"`
+	ActorUsername string                 `json:"actor_username,omitempty"`
+	Action        string                 `json:"action"`
+	Entity        string                 `json:"entity"`
+	EntityID      int64                  `json:"entity_id"`
+	Before        map[string]interface{} `json:"before,omitempty"`
+	After         map[string]interface{} `json:"after,omitempty"`
+	RequestID     string                 `json:"request_id,omitempty"`
+	CreatedAt     string                 `json:"created_at"`
+}
+
+// AuditFilter selects audit log entries. Zero values match everything, To is exclusive.
+type AuditFilter struct {
+	ActorID  int64
+	Entity   string
+	EntityID int64
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
@@ -0,0 +1,103 @@
+package model
+
+type Role string
+
+const (
+	RoleReporter  Role = "reporter"
+	RoleRanger    Role = "ranger"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermissionCreateSighting    Permission = "sighting:create"
+	PermissionCreateAnimal      Permission = "animal:create"
+	PermissionUpdateAnimal      Permission = "animal:update"
+	PermissionDeleteAnimal      Permission = "animal:delete"
+	PermissionMergeAnimals      Permission = "animal:merge"
+	PermissionRestoreAnimal     Permission = "animal:restore"
+	PermissionModerateSightings Permission = "sighting:moderate"
+	PermissionManageUsers       Permission = "user:manage"
+	PermissionReadAuditLog      Permission = "audit:read"
+	PermissionManageTaxonomy    Permission = "taxonomy:manage"
+)
+
+// rolePermissions lists what each role may do in addition to everything the previous role may do.
+var rolePermissions = []struct {
+	role        Role
+	permissions []Permission
+}{
+	{RoleReporter, []Permission{PermissionCreateSighting}},
+	{RoleRanger, []Permission{PermissionCreateAnimal, PermissionUpdateAnimal}},
+	{RoleModerator, []Permission{PermissionModerateSightings, PermissionDeleteAnimal, PermissionMergeAnimals}},
+	{RoleAdmin, []Permission{PermissionManageUsers, PermissionReadAuditLog, PermissionRestoreAnimal, PermissionManageTaxonomy}},
+}
+
+func (r Role) Valid() bool {
+	for _, rp := range rolePermissions {
+		if rp.role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the role grants the permission. Unknown roles are treated as reporters.
+func (r Role) Can(permission Permission) bool {
+	if !r.Valid() {
+		r = RoleReporter
+	}
+	for _, rp := range rolePermissions {
+		for _, p := range rp.permissions {
+			if p == permission {
+				return true
+			}
+		}
+		if rp.role == r {
+			return false
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated user a request acts on behalf of.
+type Principal struct {
+	UserID   int64  `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email_id,omitempty"`
+	Role     Role   `json:"role,omitempty"`
+	// EmailVerified is false for accounts that have not confirmed their email address yet.
+	EmailVerified bool `json:"email_verified,omitempty"`
+	// Scopes further restricts what the role grants. It is only set for requests authenticated with an API key.
+	Scopes []Permission `json:"scopes,omitempty"`
+}
+
+// Can reports whether the principal's role grants the permission and, for API keys, whether it is in scope.
+func (p Principal) Can(permission Permission) bool {
+	if !p.Role.Can(permission) {
+		return false
+	}
+	if p.Scopes == nil {
+		return true
+	}
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether the permission is one of the known permissions.
+func (p Permission) Valid() bool {
+	for _, rp := range rolePermissions {
+		for _, permission := range rp.permissions {
+			if permission == p {
+				return true
+			}
+		}
+	}
+	return false
+}
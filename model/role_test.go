@@ -0,0 +1,43 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRoleCan(t *testing.T) {
+	assert.True(t, RoleReporter.Can(PermissionCreateSighting), "Reporters should create sightings")
+	assert.False(t, RoleReporter.Can(PermissionCreateAnimal), "Reporters should not create animals")
+	assert.True(t, RoleRanger.Can(PermissionCreateAnimal), "Rangers should create animals")
+	assert.False(t, RoleRanger.Can(PermissionModerateSightings), "Rangers should not moderate sightings")
+	assert.True(t, RoleModerator.Can(PermissionCreateAnimal), "Moderators inherit ranger permissions")
+	assert.True(t, RoleModerator.Can(PermissionModerateSightings), "Moderators should moderate sightings")
+	assert.False(t, RoleModerator.Can(PermissionManageUsers), "Moderators should not manage users")
+	assert.False(t, RoleRanger.Can(PermissionDeleteAnimal), "Rangers should not delete animals")
+	assert.True(t, RoleModerator.Can(PermissionDeleteAnimal), "Moderators should delete animals")
+	assert.False(t, RoleRanger.Can(PermissionMergeAnimals), "Rangers should not merge animals")
+	assert.True(t, RoleModerator.Can(PermissionMergeAnimals), "Moderators should merge animals")
+	assert.False(t, RoleModerator.Can(PermissionRestoreAnimal), "Only admins should restore animals")
+	assert.False(t, RoleModerator.Can(PermissionManageTaxonomy), "Only admins should manage the taxonomy")
+	assert.True(t, RoleAdmin.Can(PermissionManageTaxonomy))
+	assert.True(t, RoleAdmin.Can(PermissionManageUsers), "Admins should manage users")
+	assert.True(t, Role("").Can(PermissionCreateSighting), "Unknown roles are treated as reporters")
+	assert.False(t, Role("superuser").Can(PermissionManageUsers), "Unknown roles are treated as reporters")
+}
+
+func TestRoleValid(t *testing.T) {
+	assert.True(t, RoleAdmin.Valid(), "admin should be a valid role")
+	assert.False(t, Role("superuser").Valid(), "superuser should not be a valid role")
+}
+
+func TestPrincipalCan(t *testing.T) {
+	session := Principal{Role: RoleRanger}
+	assert.True(t, session.Can(PermissionCreateAnimal), "Sessions get every permission of their role")
+
+	apiKey := Principal{Role: RoleRanger, Scopes: []Permission{PermissionCreateSighting}}
+	assert.True(t, apiKey.Can(PermissionCreateSighting), "API keys get the permissions in scope")
+	assert.False(t, apiKey.Can(PermissionCreateAnimal), "API keys should not exceed their scopes")
+
+	demoted := Principal{Role: RoleReporter, Scopes: []Permission{PermissionCreateAnimal}}
+	assert.False(t, demoted.Can(PermissionCreateAnimal), "Scopes should not exceed the role")
+}
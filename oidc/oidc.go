@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tigerhall-kittens/keystore"
+	"time"
+)
+
+// Identity is the subset of ID token claims used to link an external identity to a local user.
+type Identity struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Nonce             string   `json:"nonce"`
+}
+
+// Valid implements jwt.Claims.
+func (i *Identity) Valid() error {
+	if i.ExpiresAt == 0 || time.Now().Unix() > i.ExpiresAt {
+		return errors.New("id_token is expired")
+	}
+	return nil
+}
+
+// audience accepts both forms of the aud claim, a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider performs the OpenID Connect authorization code flow against a single identity provider.
+type Provider struct {
+	Name         string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	discovery    discovery
+	client       *http.Client
+
+	mu   sync.Mutex
+	keys *keystore.KeySet
+}
+
+// NewProvider reads the provider metadata from the issuer's discovery document.
+func NewProvider(name string, issuer string, clientID string, clientSecret string, redirectURL string) (*Provider, error) {
+	p := &Provider{
+		Name:         name,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(wellKnown, &p.discovery); err != nil {
+		return nil, fmt.Errorf("error reading discovery document of %s: %w", name, err)
+	}
+	if p.discovery.Issuer != issuer {
+		return nil, fmt.Errorf("issuer mismatch for %s: expected %s, got %s", name, issuer, p.discovery.Issuer)
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns the URL the user agent is redirected to in order to authenticate.
+func (p *Provider) AuthCodeURL(state string, nonce string) string {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems the authorization code and returns the verified identity of its ID token.
+func (p *Provider) Exchange(code string, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.redirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %w", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return p.verify(tokenResp.IDToken, nonce)
+}
+
+func (p *Provider) verify(idToken string, nonce string) (*Identity, error) {
+	identity := &Identity{}
+	_, err := jwt.ParseWithClaims(idToken, identity, p.keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if identity.Issuer != p.discovery.Issuer {
+		return nil, fmt.Errorf("unexpected id_token issuer %s", identity.Issuer)
+	}
+	if !identity.Audience.contains(p.clientID) {
+		return nil, errors.New("id_token was not issued for this client")
+	}
+	if identity.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return identity, nil
+}
+
+// keyfunc verifies with the cached provider keys, refetching them once when the kid is unknown
+// so that key rotation at the provider is picked up.
+func (p *Provider) keyfunc(token *jwt.Token) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil {
+		if key, err := p.keys.Keyfunc(token); err == nil {
+			return key, nil
+		}
+	}
+	var jwks keystore.JWKS
+	if err := p.getJSON(p.discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("error reading provider keys: %w", err)
+	}
+	keys, err := keystore.ParseJWKS(jwks)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	return p.keys.Keyfunc(token)
+}
+
+func (p *Provider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,73 @@
+package oidc_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"tigerhall-kittens/oidc"
+	"tigerhall-kittens/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:9001/user/oidc/partner/callback"
+
+// authorize follows the authorization URL and returns the code the stand-in provider redirects back with.
+func authorize(t *testing.T, provider *oidc.Provider, state string, nonce string) string {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(provider.AuthCodeURL(state, nonce))
+	if err != nil {
+		t.Fatalf("Error calling authorization endpoint: %s", err)
+	}
+	defer resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Error parsing redirect: %s", err)
+	}
+	assert.Equal(t, state, location.Query().Get("state"), "State should be returned unchanged")
+	return location.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	idp := oidctest.NewProvider(oidctest.User{Subject: "partner-42", Email: "ranger@partner.org", EmailVerified: true})
+	defer idp.Close()
+	provider, err := oidc.NewProvider("partner", idp.URL, oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err)
+	}
+
+	code := authorize(t, provider, "teststate", "testnonce")
+	identity, err := provider.Exchange(code, "testnonce")
+	assert.NoError(t, err, "Unexpected error while exchanging code")
+	assert.Equal(t, "partner-42", identity.Subject, "Subject mismatch")
+	assert.Equal(t, "ranger@partner.org", identity.Email, "Email mismatch")
+
+	_, err = provider.Exchange(code, "testnonce")
+	assert.Error(t, err, "A code should only be redeemable once")
+}
+
+func TestExchange_InvalidIDToken(t *testing.T) {
+	idp := oidctest.NewProvider(oidctest.User{Subject: "partner-42"})
+	defer idp.Close()
+	provider, err := oidc.NewProvider("partner", idp.URL, oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err)
+	}
+
+	code := authorize(t, provider, "teststate", "testnonce")
+	_, err = provider.Exchange(code, "othernonce")
+	assert.Error(t, err, "Nonce mismatch should be rejected")
+
+	idp.Audience = "another-client"
+	code = authorize(t, provider, "teststate", "testnonce")
+	_, err = provider.Exchange(code, "testnonce")
+	assert.Error(t, err, "ID token for another client should be rejected")
+}
+
+func TestNewProvider_IssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider(oidctest.User{Subject: "partner-42"})
+	defer idp.Close()
+	_, err := oidc.NewProvider("partner", idp.URL+"/other", oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+	assert.Error(t, err, "Issuer mismatch should be rejected")
+}
@@ -0,0 +1,151 @@
+// Package oidctest provides a local stand-in OpenID Connect provider for tests.
+package oidctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"tigerhall-kittens/keystore"
+	"time"
+)
+
+const (
+	ClientID     = "tigerhall-test-client"
+	ClientSecret = "tigerhall-test-secret"
+)
+
+// User is the identity the provider authenticates when it receives an authorization request.
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Provider is an httptest server implementing discovery, authorization, token and JWKS endpoints.
+// The authorization endpoint immediately redirects back with a code for the configured User.
+type Provider struct {
+	*httptest.Server
+	Keys *keystore.KeySet
+	User User
+	// Audience overrides the aud claim of issued ID tokens when set.
+	Audience string
+
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+func NewProvider(user User) *Provider {
+	keys := keystore.NewKeySet()
+	if err := keys.GenerateEd25519("oidctest"); err != nil {
+		panic(err)
+	}
+	p := &Provider{Keys: keys, User: user, codes: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code := "code-" + query.Get("state")
+	p.mu.Lock()
+	p.codes[code] = query.Get("nonce")
+	p.mu.Unlock()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != ClientID || clientSecret != ClientSecret {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+	p.mu.Lock()
+	nonce, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+	if !ok || r.FormValue("grant_type") != "authorization_code" {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+	audience := p.Audience
+	if audience == "" {
+		audience = ClientID
+	}
+	idToken, err := p.Keys.Sign(&idTokenClaims{
+		Issuer:            p.URL,
+		Subject:           p.User.Subject,
+		Audience:          []string{audience},
+		ExpiresAt:         time.Now().Add(time.Minute).Unix(),
+		Email:             p.User.Email,
+		EmailVerified:     p.User.EmailVerified,
+		PreferredUsername: p.User.PreferredUsername,
+		Nonce:             nonce,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, p.Keys.JWKS())
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          []string `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Nonce             string   `json:"nonce,omitempty"`
+}
+
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,251 @@
+// Package password hashes and verifies passwords. Hashes are stored in an encoded form that names the algorithm
+// and its parameters, so that hashes made with an older algorithm or weaker parameters can be recognised and
+// replaced the next time the user logs in.
+package password
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidHash = errors.New("Invalid password hash")
+
+// Hasher is a password hashing algorithm.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	Verify(encoded string, password string) (bool, error)
+	// Recognizes reports whether the encoded hash was made by this algorithm.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether the encoded hash was made with other parameters than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Hashers hashes with the preferred hasher and verifies hashes of any of the hashers.
+type Hashers struct {
+	preferred Hasher
+	all       []Hasher
+}
+
+func NewHashers(preferred Hasher, others ...Hasher) *Hashers {
+	return &Hashers{
+		preferred: preferred,
+		all:       append([]Hasher{preferred}, others...),
+	}
+}
+
+// Default hashes with argon2id and still verifies the bcrypt hashes stored before.
+func Default() *Hashers {
+	return NewHashers(DefaultArgon2id, Bcrypt{Cost: bcrypt.DefaultCost})
+}
+
+func (h *Hashers) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h *Hashers) Verify(encoded string, password string) (bool, error) {
+	for _, hasher := range h.all {
+		if hasher.Recognizes(encoded) {
+			return hasher.Verify(encoded, password)
+		}
+	}
+	return false, ErrInvalidHash
+}
+
+func (h *Hashers) Recognizes(encoded string) bool {
+	for _, hasher := range h.all {
+		if hasher.Recognizes(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether the hash was not made by the preferred hasher with its current parameters.
+func (h *Hashers) NeedsRehash(encoded string) bool {
+	return !h.preferred.Recognizes(encoded) || h.preferred.NeedsRehash(encoded)
+}
+
+// Argon2id hashes in the PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2id struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the OWASP recommendation of at least 19 MiB and 2 iterations with some headroom.
+var DefaultArgon2id = Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Iterations,
+		a.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(encoded string, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, actual) == 1, nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.Memory || params.Iterations != a.Iterations || params.Parallelism != a.Parallelism ||
+		uint32(len(salt)) != a.SaltLength || uint32(len(key)) != a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes in the modular crypt format, e.g. $2a$10$<salt and hash>.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// PolicyError explains why a password was rejected, its message is meant for the user.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+// Policy is what new passwords have to satisfy.
+type Policy struct {
+	minLength int
+	// breached holds the SHA-1 hex digests of known breached passwords.
+	breached map[string]struct{}
+}
+
+func NewPolicy(minLength int, breached ...string) *Policy {
+	policy := &Policy{minLength: minLength, breached: make(map[string]struct{})}
+	for _, password := range breached {
+		policy.breached[sha1Hex(password)] = struct{}{}
+	}
+	return policy
+}
+
+// LoadBreachedList adds the passwords listed in the file, one per line. Lines can also be the SHA-1 digest of
+// a password as in the Pwned Passwords downloads, optionally followed by :<count>.
+func (p *Policy) LoadBreachedList(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			p.breached[strings.ToLower(digest)] = struct{}{}
+			continue
+		}
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check returns a PolicyError when the password is too short or known to be breached.
+func (p *Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return &PolicyError{Reason: fmt.Sprintf("Password must be at least %d characters long", p.minLength)}
+	}
+	if _, found := p.breached[sha1Hex(password)]; found {
+		return &PolicyError{Reason: "Password has appeared in a data breach, please choose another one"}
+	}
+	return nil
+}
+
+func sha1Hex(password string) string {
+	digest := sha1.Sum([]byte(password))
+	return hex.EncodeToString(digest[:])
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,72 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testArgon2id = Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2id(t *testing.T) {
+	encoded, err := testArgon2id.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), "Parameters should be encoded")
+	assert.LessOrEqual(t, len(encoded), 128)
+
+	ok, err := testArgon2id.Verify(encoded, "correct horse")
+	assert.NoError(t, err)
+	assert.True(t, ok, "Password should match")
+	ok, err = testArgon2id.Verify(encoded, "battery staple")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Wrong password should not match")
+
+	assert.False(t, testArgon2id.NeedsRehash(encoded))
+	stronger := testArgon2id
+	stronger.Iterations = 2
+	assert.True(t, stronger.NeedsRehash(encoded), "Hash with fewer iterations should be rehashed")
+
+	_, err = testArgon2id.Verify("$argon2id$v=19$m=1024$salt$key", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func TestHashers(t *testing.T) {
+	hashers := NewHashers(testArgon2id, Bcrypt{Cost: bcrypt.MinCost})
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	ok, err := hashers.Verify(string(legacy), "correct horse")
+	assert.NoError(t, err)
+	assert.True(t, ok, "bcrypt hashes should still verify")
+	assert.True(t, hashers.NeedsRehash(string(legacy)), "bcrypt hashes should be upgraded")
+
+	encoded, err := hashers.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, testArgon2id.Recognizes(encoded), "New hashes should use argon2id")
+	assert.False(t, hashers.NeedsRehash(encoded))
+
+	_, err = hashers.Verify("plain text", "plain text")
+	assert.ErrorIs(t, err, ErrInvalidHash, "Unknown hashes should not verify")
+}
+
+func TestPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// The second line is the SHA-1 digest of "letmein-letmein" with a Pwned Passwords count.
+	list := "password1234\r\n6C0E4546AB08F32D036791A3F5EA17F59A0ED947:42\n\n"
+	assert.NoError(t, os.WriteFile(path, []byte(list), 0600))
+	policy := NewPolicy(12, "qwertyuiop123")
+	assert.NoError(t, policy.LoadBreachedList(path))
+
+	var policyErr *PolicyError
+	assert.ErrorAs(t, policy.Check("short"), &policyErr, "Short passwords should be rejected")
+	assert.Contains(t, policyErr.Reason, "12 characters")
+	assert.ErrorAs(t, policy.Check("password1234"), &policyErr, "Listed passwords should be rejected")
+	assert.ErrorAs(t, policy.Check("qwertyuiop123"), &policyErr, "Configured passwords should be rejected")
+	assert.ErrorAs(t, policy.Check(strings.Repeat("é", 11)), &policyErr, "Length should be counted in characters")
+	assert.NoError(t, policy.Check("correct horse battery staple"))
+	assert.ErrorAs(t, policy.Check("letmein-letmein"), &policyErr, "Listed digests should be rejected")
+	assert.NoError(t, policy.Check(strings.Repeat("é", 12)))
+}
@@ -0,0 +1,175 @@
+// Package pii protects personal data at rest. Values are envelope encrypted: every value gets its own data key,
+// which is wrapped with a key encryption key identified by a key ID stored next to the value, so that keys can be
+// rotated by re-encrypting. Blind indexes, keyed hashes of normalised values, allow exact match lookups.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	KeySize = 32
+
+	version      = 1
+	nonceSize    = 12
+	wrappedSize  = nonceSize + KeySize + 16
+	maxKeyIDSize = 32
+)
+
+var (
+	ErrUnknownKey        = errors.New("Unknown PII encryption key")
+	ErrInvalidCiphertext = errors.New("Invalid PII ciphertext")
+)
+
+// Keyring holds the key encryption keys by ID, the one new values are encrypted with and the blind index key.
+// The blind index key cannot be rotated without recomputing every index.
+type Keyring struct {
+	keys     map[string][]byte
+	activeID string
+	indexKey []byte
+}
+
+// NewKeyring returns a keyring encrypting with the key activeID. Every key must be KeySize bytes.
+func NewKeyring(keys map[string][]byte, activeID string, indexKey []byte) (*Keyring, error) {
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDSize || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("PII key id %q should be 1 to %d characters without : or ,", id, maxKeyIDSize)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("PII key %s should be %d bytes", id, KeySize)
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active PII key %q is not configured", activeID)
+	}
+	if len(indexKey) != KeySize {
+		return nil, fmt.Errorf("PII index key should be %d bytes", KeySize)
+	}
+	return &Keyring{keys: keys, activeID: activeID, indexKey: indexKey}, nil
+}
+
+// ParseKeyring parses keys given as "id:base64key,id2:base64key" and a base64 index key.
+func ParseKeyring(keys string, activeID string, indexKey string) (*Keyring, error) {
+	parsed := make(map[string][]byte)
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("PII key %q should look like id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("PII key %s is not valid base64: %w", id, err)
+		}
+		parsed[id] = key
+	}
+	index, err := base64.StdEncoding.DecodeString(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("PII index key is not valid base64: %w", err)
+	}
+	return NewKeyring(parsed, activeID, index)
+}
+
+// ActiveKeyID returns the ID of the key new values are encrypted with.
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// Seal encrypts the value with the active key. The label names what the value is, e.g. "user.email", and has to
+// be given again to open it, so that a ciphertext copied into another column does not decrypt.
+func (k *Keyring) Seal(label string, value string) (string, []byte, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, err
+	}
+	wrapped, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := seal(dataKey, []byte(value), []byte(label))
+	if err != nil {
+		return "", nil, err
+	}
+	ciphertext := append([]byte{version}, wrapped...)
+	return k.activeID, append(ciphertext, data...), nil
+}
+
+// Open decrypts a value sealed with the key keyID.
+func (k *Keyring) Open(label string, keyID string, ciphertext []byte) (string, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	if len(ciphertext) < 1+wrappedSize || ciphertext[0] != version {
+		return "", ErrInvalidCiphertext
+	}
+	dataKey, err := open(key, ciphertext[1:1+wrappedSize], []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	value, err := open(dataKey, ciphertext[1+wrappedSize:], []byte(label))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// BlindIndex returns the deterministic index of the value used to look it up without decrypting every row.
+func (k *Keyring) BlindIndex(label string, value string) []byte {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// NormalizeEmail returns the form of an email address its blind index is computed from.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < nonceSize {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
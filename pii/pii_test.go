@@ -0,0 +1,71 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testKeyring(t *testing.T, activeID string) *Keyring {
+	keys := map[string][]byte{
+		"2023-01": bytes.Repeat([]byte{1}, KeySize),
+		"2023-06": bytes.Repeat([]byte{2}, KeySize),
+	}
+	keyring, err := NewKeyring(keys, activeID, bytes.Repeat([]byte{3}, KeySize))
+	if err != nil {
+		t.Fatalf("Error creating keyring: %s", err)
+	}
+	return keyring
+}
+
+func TestSealOpen(t *testing.T) {
+	old := testKeyring(t, "2023-01")
+	keyID, ciphertext, err := old.Seal("user.email", "ranger@example.com")
+	assert.NoError(t, err, "Unexpected error while sealing")
+	assert.Equal(t, "2023-01", keyID, "Value should be sealed with the active key")
+	assert.False(t, bytes.Contains(ciphertext, []byte("ranger")), "Ciphertext should not contain the value")
+
+	_, again, _ := old.Seal("user.email", "ranger@example.com")
+	assert.NotEqual(t, ciphertext, again, "Sealing should be randomised")
+
+	rotated := testKeyring(t, "2023-06")
+	value, err := rotated.Open("user.email", keyID, ciphertext)
+	assert.NoError(t, err, "Values sealed with a retired key should still open")
+	assert.Equal(t, "ranger@example.com", value, "Value mismatch")
+
+	_, err = rotated.Open("user_identity.email", keyID, ciphertext)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext, "Value should not open under another label")
+
+	_, err = rotated.Open("user.email", "2023-06", ciphertext)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext, "Value should not open with another key")
+
+	_, err = rotated.Open("user.email", "2022-01", ciphertext)
+	assert.ErrorIs(t, err, ErrUnknownKey, "Unknown keys should be reported")
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = rotated.Open("user.email", keyID, ciphertext)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext, "Tampered values should be rejected")
+}
+
+func TestBlindIndex(t *testing.T) {
+	old, rotated := testKeyring(t, "2023-01"), testKeyring(t, "2023-06")
+	index := old.BlindIndex("user.email", NormalizeEmail(" Ranger@Example.com"))
+	assert.Equal(t, index, rotated.BlindIndex("user.email", "ranger@example.com"), "Index should not depend on the active key")
+	assert.NotEqual(t, index, old.BlindIndex("user.email", "reporter@example.com"), "Different values should have different indexes")
+	assert.NotEqual(t, index, old.BlindIndex("user_identity.email", "ranger@example.com"), "Index should depend on the label")
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, KeySize))
+	keyring, err := ParseKeyring("a:"+key+", b:"+key, "b", key)
+	assert.NoError(t, err, "Unexpected error while parsing keyring")
+	assert.Equal(t, "b", keyring.ActiveKeyID(), "Active key mismatch")
+
+	_, err = ParseKeyring("a:"+key, "b", key)
+	assert.Error(t, err, "Active key should have to be configured")
+	_, err = ParseKeyring("a:"+key[:10], "a", key)
+	assert.Error(t, err, "Short keys should be rejected")
+	_, err = ParseKeyring("a"+key, "a", key)
+	assert.Error(t, err, "Keys without id should be rejected")
+}
@@ -0,0 +1,137 @@
+// Package ratelimit implements token bucket rate limiting. A bucket holds up to Limit tokens and is refilled
+// at Limit tokens per Period; every request takes one token and is rejected when the bucket is empty.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyBy selects what requests share a bucket.
+type KeyBy string
+
+const (
+	// KeyByUser gives every authenticated user a bucket. Anonymous requests fall back to their IP address.
+	KeyByUser KeyBy = "user"
+	KeyByIP   KeyBy = "ip"
+)
+
+// Policy limits a route to Limit requests per Period, allowing bursts of up to Limit requests.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	KeyBy  KeyBy
+}
+
+// Bucket is the state of one client's bucket. The zero Bucket is full.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a rejected request could succeed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets, e.g. in memory or in a database shared by several instances.
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
+
+// ParsePolicy parses a spec such as "10/1m", i.e. 10 requests per minute.
+func ParsePolicy(name string, spec string, keyBy KeyBy) (Policy, error) {
+	limit, period, found := strings.Cut(spec, "/")
+	if !found {
+		return Policy{}, fmt.Errorf("rate limit %s should look like 10/1m, got %q", name, spec)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n < 1 {
+		return Policy{}, fmt.Errorf("rate limit %s should allow at least 1 request, got %q", name, limit)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %s has an invalid period %q", name, period)
+	}
+	return Policy{Name: name, Limit: n, Period: d, KeyBy: keyBy}, nil
+}
+
+// Take refills the bucket for the time passed since it was last updated and takes a token if there is one.
+func (p Policy) Take(bucket *Bucket, now time.Time) Result {
+	limit := float64(p.Limit)
+	perSecond := limit / p.Period.Seconds()
+	tokens := limit
+	if !bucket.UpdatedAt.IsZero() {
+		elapsed := now.Sub(bucket.UpdatedAt).Seconds()
+		tokens = math.Min(limit, bucket.Tokens+math.Max(elapsed, 0)*perSecond)
+	}
+	result := Result{Allowed: tokens >= 1}
+	if result.Allowed {
+		tokens--
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+	bucket.Tokens = tokens
+	bucket.UpdatedAt = now
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((limit - tokens) / perSecond)
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// MemoryStore keeps buckets in memory, so every instance of the service limits on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	periods   map[string]time.Duration
+	lastSweep time.Time
+}
+
+// sweepInterval is how often buckets that have been full long enough to be forgotten are removed.
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*Bucket),
+		periods: make(map[string]time.Duration),
+	}
+}
+
+func (s *MemoryStore) Take(key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &Bucket{}
+		s.buckets[key] = bucket
+		s.periods[key] = policy.Period
+	}
+	return policy.Take(bucket, now), nil
+}
+
+// sweep removes the buckets that have refilled completely, they are the same as new ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.UpdatedAt) >= s.periods[key] {
+			delete(s.buckets, key)
+			delete(s.periods, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPolicyTake(t *testing.T) {
+	policy := Policy{Name: "test", Limit: 3, Period: 3 * time.Second, KeyBy: KeyByIP}
+	now := time.Unix(1700000000, 0)
+	var bucket Bucket
+
+	for i := 2; i >= 0; i-- {
+		result := policy.Take(&bucket, now)
+		assert.True(t, result.Allowed, "Burst up to the limit should be allowed")
+		assert.Equal(t, i, result.Remaining, "Remaining mismatch")
+	}
+	result := policy.Take(&bucket, now)
+	assert.False(t, result.Allowed, "Empty bucket should reject")
+	assert.Equal(t, time.Second, result.RetryAfter, "One token is refilled per second")
+	assert.Equal(t, 3*time.Second, result.Reset, "Bucket should be full after the period")
+
+	result = policy.Take(&bucket, now.Add(1500*time.Millisecond))
+	assert.True(t, result.Allowed, "Refilled token should be allowed")
+	assert.Equal(t, 0, result.Remaining, "Half a token should not count")
+
+	result = policy.Take(&bucket, now.Add(time.Hour))
+	assert.True(t, result.Allowed, "Bucket should refill")
+	assert.Equal(t, 2, result.Remaining, "Bucket should not refill beyond the limit")
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("login", "20/1m", KeyByIP)
+	assert.NoError(t, err, "Unexpected error while parsing policy")
+	assert.Equal(t, Policy{Name: "login", Limit: 20, Period: time.Minute, KeyBy: KeyByIP}, policy, "Policy mismatch")
+
+	for _, spec := range []string{"20", "0/1m", "x/1m", "20/forever", "20/-1s"} {
+		_, err = ParsePolicy("login", spec, KeyByIP)
+		assert.Error(t, err, "Spec %q should be rejected", spec)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 1, Period: time.Minute, KeyBy: KeyByUser}
+	now := time.Unix(1700000000, 0)
+
+	result, _ := store.Take("test:user:1", policy, now)
+	assert.True(t, result.Allowed, "First request should be allowed")
+	result, _ = store.Take("test:user:1", policy, now)
+	assert.False(t, result.Allowed, "Second request should be limited")
+	result, _ = store.Take("test:user:2", policy, now)
+	assert.True(t, result.Allowed, "Other users should have their own bucket")
+
+	store.Take("test:user:3", policy, now.Add(2*time.Minute))
+	assert.Len(t, store.buckets, 1, "Full buckets should be swept")
+}
@@ -5,4 +5,5 @@ docker-compose -f docker-compose.yml up -d
 make dropdb
 make createdb
 make migratedbup
+ls keys/*.pem > /dev/null 2>&1 || make jwtkey
 
@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		assert.NoError(t, err, "Unexpected error while generating code")
+		assert.Equal(t, expected, code, "Code mismatch at %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Step(now))
+
+	step, ok := Validate(rfcSecret, code, now)
+	assert.True(t, ok, "Current code should be valid")
+	assert.Equal(t, Step(now), step, "Matched step mismatch")
+
+	_, ok = Validate(rfcSecret, code, now.Add(Period))
+	assert.True(t, ok, "Code of the previous period should be accepted")
+
+	_, ok = Validate(rfcSecret, code, now.Add(3*Period))
+	assert.False(t, ok, "Stale code should be rejected")
+
+	_, ok = Validate(rfcSecret, "12345", now)
+	assert.False(t, ok, "Short code should be rejected")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err, "Unexpected error while generating secret")
+	_, err = Code(secret, 1)
+	assert.NoError(t, err, "Generated secret should be usable")
+
+	uri, err := url.Parse(URI("Tigerhall Kittens", "ranger", secret))
+	assert.NoError(t, err, "URI should parse")
+	assert.Equal(t, "otpauth", uri.Scheme, "Scheme mismatch")
+	assert.Equal(t, "totp", uri.Host, "Type mismatch")
+	assert.Equal(t, secret, uri.Query().Get("secret"), "Secret mismatch")
+	assert.Equal(t, "Tigerhall Kittens", uri.Query().Get("issuer"), "Issuer mismatch")
+}
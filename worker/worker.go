@@ -1,19 +1,24 @@
 package worker
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/IBM/sarama"
 	"gopkg.in/gomail.v2"
 	"os"
-	"strings"
+	"tigerhall-kittens/database"
 	"tigerhall-kittens/logger"
+	"tigerhall-kittens/model"
+	"time"
 )
 
 const (
-	group = "emailgroup"
-	topic = "email_animal_sighted"
+	group        = "emailgroup"
+	topic        = "email_animal_sighted"
+	accountTopic = "email_account"
 )
 
-func StartConsumer() {
+func StartConsumer(notification database.INotification, user database.IUser) {
 	brokerList := []string{"localhost:9092"} // Add your Kafka broker addresses
 
 	config := sarama.NewConfig()
@@ -38,33 +43,135 @@ func StartConsumer() {
 			logger.LogError(err)
 		}
 	}()
+	accountConsumer, err := consumer.ConsumePartition(accountTopic, 0, sarama.OffsetNewest)
+	if err != nil {
+		logger.LogError(err)
+	}
+	defer func() {
+		if err := accountConsumer.Close(); err != nil {
+			logger.LogError(err)
+		}
+	}()
 
 	logger.LogInfo("Kafka worker started...")
 
 	for {
 		select {
 		case msg := <-partitionConsumer.Messages():
-			emailIDs := strings.Split(string(msg.Value), ",")
-			for _, emailID := range emailIDs {
-				if err := sendEmail(emailID); err != nil {
-					logger.LogError(err)
-				} else {
-					logger.LogInfo("Email sent to %s", emailID)
-				}
-			}
+			handleSightingEvent(msg.Value, notification, user)
 		case err := <-partitionConsumer.Errors():
 			logger.LogError(err)
+		case msg := <-accountConsumer.Messages():
+			handleAccountEmail(msg.Value, user)
+		case err := <-accountConsumer.Errors():
+			logger.LogError(err)
+		}
+	}
+}
+
+func handleSightingEvent(value []byte, notification database.INotification, user database.IUser) {
+	event, err := decodeSightingEvent(value)
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
+	if err := notification.CreateNotifications(event); err != nil {
+		logger.LogError(err)
+	}
+	for _, recipient := range event.Recipients {
+		if recipient.Email == "" {
+			reporter, err := user.GetUserByID(recipient.UserID)
+			if err != nil {
+				logger.LogError(err)
+				continue
+			}
+			recipient.Email = reporter.Email
+		}
+		if err := sendEmail(recipient.Email, "Animal Sighting Update", "An animal you reported has been sighted again"); err != nil {
+			logger.LogError(err)
+		} else {
+			logger.LogInfo("Sighting email sent to user", recipient.UserID)
+		}
+	}
+}
+
+// StartReencryption re-encrypts personal data with the active key every interval, in batches until none is left.
+func StartReencryption(store database.IPII, interval time.Duration) {
+	runPIIJob(store.ReencryptPII, interval)
+}
+
+// StartDecryption decrypts personal data back to plain text every interval, in batches until none is left.
+func StartDecryption(store database.IPII, interval time.Duration) {
+	runPIIJob(store.DecryptPII, interval)
+}
+
+func runPIIJob(job func(batchSize int) (int64, error), interval time.Duration) {
+	const batchSize = 100
+	for ; ; time.Sleep(interval) {
+		for {
+			count, err := job(batchSize)
+			if err != nil {
+				logger.LogError(err)
+				break
+			}
+			if count == 0 {
+				break
+			}
+		}
+	}
+}
+
+func decodeSightingEvent(value []byte) (*model.SightingEvent, error) {
+	var event model.SightingEvent
+	if err := json.Unmarshal(value, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode sighting event: %w", err)
+	}
+	if event.AnimalID == 0 || event.SightingID == 0 {
+		return nil, fmt.Errorf("sighting event is missing animal_id or sighting_id")
+	}
+	return &event, nil
+}
+
+func handleAccountEmail(value []byte, user database.IUser) {
+	email, err := decodeAccountEmail(value)
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
+	if email.To == "" {
+		recipient, err := user.GetUserByID(email.UserID)
+		if err != nil {
+			logger.LogError(err)
+			return
 		}
+		email.To = recipient.Email
+	}
+	if err := sendEmail(email.To, email.Subject, email.Body); err != nil {
+		logger.LogError(fmt.Errorf("failed to send account email to user %d: %w", email.UserID, err))
+	} else {
+		logger.LogInfo("Account email sent to user", email.UserID)
+	}
+}
+
+// decodeAccountEmail decodes an account email, the errors leave out the payload as its body holds a live token.
+func decodeAccountEmail(value []byte) (*model.AccountEmail, error) {
+	var email model.AccountEmail
+	if err := json.Unmarshal(value, &email); err != nil {
+		return nil, fmt.Errorf("failed to decode account email")
+	}
+	if email.UserID == 0 && email.To == "" {
+		return nil, fmt.Errorf("account email is missing user_id")
 	}
+	return &email, nil
 }
 
 // Replace mailtrap smtp credentials
-func sendEmail(emailID string) error {
+func sendEmail(emailID string, subject string, body string) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", "from@example.com")
 	m.SetHeader("To", emailID)
-	m.SetHeader("Subject", "Animal Sighting Update")
-	m.SetBody("text/plain", "An animal you reported has been sighted again")
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", body)
 	username := os.Getenv("MAILTRAP_USERNAME")
 	password := os.Getenv("MAILTRAP_PASSWORD")
 	d := gomail.NewDialer("sandbox.smtp.mailtrap.io", 587, username, password) // Replace with your SMTP server and credentials
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeSightingEvent(t *testing.T) {
+	value := []byte(`{"animal_id":1,"sighting_id":2,"recipients":[{"user_id":3,"email":"test@email.com"}]}`)
+	event, err := decodeSightingEvent(value)
+	assert.NoError(t, err, "Unexpected error while decoding sighting event")
+	assert.Equal(t, int64(1), event.AnimalID, "Animal ID mismatch")
+	assert.Equal(t, int64(2), event.SightingID, "Sighting ID mismatch")
+	assert.Len(t, event.Recipients, 1, "Recipient count mismatch")
+	assert.Equal(t, "test@email.com", event.Recipients[0].Email, "Recipient email mismatch")
+}
+
+func TestDecodeSightingEvent_Invalid(t *testing.T) {
+	_, err := decodeSightingEvent([]byte("test@email.com,other@email.com"))
+	assert.Error(t, err, "Legacy comma separated payload should be rejected")
+
+	_, err = decodeSightingEvent([]byte(`{"animal_id":1}`))
+	assert.Error(t, err, "Payload without sighting_id should be rejected")
+}
+
+func TestDecodeAccountEmail(t *testing.T) {
+	email, err := decodeAccountEmail([]byte(`{"user_id":3,"subject":"Reset your password","body":"token"}`))
+	assert.NoError(t, err, "Unexpected error while decoding account email")
+	assert.Equal(t, int64(3), email.UserID, "User ID mismatch")
+	assert.Empty(t, email.To, "Account emails should not carry the address")
+
+	email, err = decodeAccountEmail([]byte(`{"to":"test@email.com","subject":"Reset your password","body":"token"}`))
+	assert.NoError(t, err, "Emails queued before should still be sent")
+	assert.Equal(t, "test@email.com", email.To, "Recipient email mismatch")
+
+	_, err = decodeAccountEmail([]byte(`{"subject":"Reset your password","body":"token"}`))
+	assert.Error(t, err, "Payload without a recipient should be rejected")
+
+	_, err = decodeAccountEmail([]byte(`{"body":"secret-token"`))
+	assert.Error(t, err, "Invalid payload should be rejected")
+	assert.NotContains(t, err.Error(), "secret-token", "Errors should not leak the token")
+}